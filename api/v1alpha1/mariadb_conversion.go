@@ -0,0 +1,343 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"sigs.k8s.io/controller-runtime/pkg/conversion"
+
+	"github.com/mariadb/mariadb.org-tools/mariadb-operator/api/v1beta1"
+)
+
+// ConvertTo converts this MariaDB to the Hub version (v1beta1).
+func (src *MariaDB) ConvertTo(dstRaw conversion.Hub) error {
+	dst := dstRaw.(*v1beta1.MariaDB)
+	dst.ObjectMeta = src.ObjectMeta
+
+	dst.Spec = v1beta1.MariaDBSpec{
+		Replicas:     src.Spec.Replicas,
+		Image:        src.Spec.Image,
+		ImageVersion: src.Spec.ImageVersion,
+		Database:     src.Spec.Database,
+		Credentials: v1beta1.CredentialsSpec{
+			Username:     src.Spec.Username,
+			Password:     src.Spec.Password,
+			RootPassword: src.Spec.Rootpwd,
+		},
+		Storage: v1beta1.StorageSpec{
+			Path: src.Spec.DataStoragePath,
+			Size: src.Spec.DataStorageSize,
+		},
+		Service: v1beta1.ServiceSpec{
+			Port: src.Spec.Port,
+		},
+		NetworkPolicy:       (*v1beta1.NetworkPolicySpec)(src.Spec.NetworkPolicy),
+		Metrics:             (*v1beta1.MetricsSpec)(src.Spec.Metrics),
+		HealthCheck:         (*v1beta1.HealthCheckSpec)(src.Spec.HealthCheck),
+		Paused:              src.Spec.Paused,
+		ScaleDownWhenPaused: src.Spec.ScaleDownWhenPaused,
+		DriftPolicy:         v1beta1.DriftPolicy(src.Spec.DriftPolicy),
+		MaxScale:            (*v1beta1.MaxScaleSpec)(src.Spec.MaxScale),
+	}
+	if src.Spec.ConnectionSecrets != nil {
+		dst.Spec.ConnectionSecrets = make([]v1beta1.ConnectionSecret, len(src.Spec.ConnectionSecrets))
+		for i, secret := range src.Spec.ConnectionSecrets {
+			dst.Spec.ConnectionSecrets[i] = v1beta1.ConnectionSecret(secret)
+		}
+	}
+	if src.Spec.InitScripts != nil {
+		dst.Spec.InitScripts = make([]v1beta1.InitScript, len(src.Spec.InitScripts))
+		for i, script := range src.Spec.InitScripts {
+			dst.Spec.InitScripts[i] = v1beta1.InitScript(script)
+		}
+	}
+	if bootstrap := src.Spec.Bootstrap; bootstrap != nil {
+		dst.Spec.Bootstrap = &v1beta1.BootstrapSpec{VolumeSnapshot: bootstrap.VolumeSnapshot}
+		if restore := bootstrap.Backup; restore != nil {
+			dst.Spec.Bootstrap.Backup = &v1beta1.BackupRestoreSpec{
+				Name:            restore.Name,
+				VolumeClaimName: restore.VolumeClaimName,
+				Encryption:      (*v1beta1.BackupEncryptionSpec)(restore.Encryption),
+			}
+		}
+		if clone := bootstrap.CloneFrom; clone != nil {
+			dst.Spec.Bootstrap.CloneFrom = &v1beta1.CloneSpec{
+				Name:          clone.Name,
+				Namespace:     clone.Namespace,
+				Method:        v1beta1.CloneMethod(clone.Method),
+				MaskingScript: (*v1beta1.InitScript)(clone.MaskingScript),
+			}
+		}
+	}
+	if storage := src.Spec.Storage; storage != nil {
+		dst.Spec.Storage.StorageClassName = storage.StorageClassName
+		dst.Spec.Storage.AccessModes = storage.AccessModes
+	}
+	if logging := src.Spec.Logging; logging != nil {
+		dst.Spec.Logging = &v1beta1.LoggingSpec{
+			ErrorLog:     logging.ErrorLog,
+			SlowQueryLog: (*v1beta1.SlowQueryLogSpec)(logging.SlowQueryLog),
+			GeneralLog:   logging.GeneralLog,
+			SidecarImage: logging.SidecarImage,
+			MaxFileSize:  logging.MaxFileSize,
+		}
+	}
+	if audit := src.Spec.Audit; audit != nil {
+		dst.Spec.Audit = &v1beta1.AuditSpec{
+			Enabled:        audit.Enabled,
+			ExcludedUsers:  audit.ExcludedUsers,
+			Output:         audit.Output,
+			FileRotateSize: audit.FileRotateSize,
+			FileRotations:  audit.FileRotations,
+		}
+		if audit.Events != nil {
+			dst.Spec.Audit.Events = make([]v1beta1.AuditEvent, len(audit.Events))
+			for i, event := range audit.Events {
+				dst.Spec.Audit.Events[i] = v1beta1.AuditEvent(event)
+			}
+		}
+	}
+	if backup := src.Spec.Backup; backup != nil {
+		dst.Spec.Backup = &v1beta1.BackupSpec{
+			Schedule:                backup.Schedule,
+			Method:                  v1beta1.BackupMethod(backup.Method),
+			VolumeSnapshotClassName: backup.VolumeSnapshotClassName,
+			Retention:               backup.Retention,
+		}
+		if dump := backup.Dump; dump != nil {
+			dst.Spec.Backup.Dump = &v1beta1.DumpBackupSpec{
+				VolumeClaimName: dump.VolumeClaimName,
+				Compression:     dump.Compression,
+				Encryption:      (*v1beta1.BackupEncryptionSpec)(dump.Encryption),
+			}
+		}
+	}
+	if window := src.Spec.MaintenanceWindow; window != nil {
+		dst.Spec.MaintenanceWindow = &v1beta1.MaintenanceWindowSpec{TimeZone: window.TimeZone}
+		if window.Windows != nil {
+			dst.Spec.MaintenanceWindow.Windows = make([]v1beta1.MaintenanceWindow, len(window.Windows))
+			for i, w := range window.Windows {
+				dst.Spec.MaintenanceWindow.Windows[i] = v1beta1.MaintenanceWindow{Start: w.Start, End: w.End}
+				if w.Days != nil {
+					dst.Spec.MaintenanceWindow.Windows[i].Days = make([]v1beta1.Weekday, len(w.Days))
+					for j, day := range w.Days {
+						dst.Spec.MaintenanceWindow.Windows[i].Days[j] = v1beta1.Weekday(day)
+					}
+				}
+			}
+		}
+	}
+
+	dst.Status = v1beta1.MariaDBStatus{
+		CurrentReplicas:    src.Status.CurrentReplicas,
+		DesiredReplicas:    src.Status.DesiredReplicas,
+		LastMessage:        src.Status.LastMessage,
+		DbState:            v1beta1.StatusPhase(src.Status.DbState),
+		ShowState:          src.Status.ShowState,
+		ObservedGeneration: src.Status.ObservedGeneration,
+		ReadyTime:          src.Status.ReadyTime,
+		ReadyReplicas:      src.Status.ReadyReplicas,
+		Selector:           src.Status.Selector,
+		StatsPod:           src.Status.StatsPod,
+		LastBackupTime:     src.Status.LastBackupTime,
+		Binding:            src.Status.Binding,
+		ConnectionSecrets:  src.Status.ConnectionSecrets,
+		MaxScaleUserPods:   src.Status.MaxScaleUserPods,
+		CurrentVersion:     src.Status.CurrentVersion,
+		Upgrade:            (*v1beta1.UpgradeStatus)(src.Status.Upgrade),
+		AuditPluginActive:  src.Status.AuditPluginActive,
+		ExporterUserPods:   src.Status.ExporterUserPods,
+		Health:             (*v1beta1.HealthStatus)(src.Status.Health),
+		Backup:             (*v1beta1.BackupStatus)(src.Status.Backup),
+		Initialization:     (*v1beta1.InitializationStatus)(src.Status.Initialization),
+		PendingChanges:     (*v1beta1.PendingChangesStatus)(src.Status.PendingChanges),
+		Conditions:         src.Status.Conditions,
+	}
+	if src.Status.Storage != nil {
+		dst.Status.Storage = make([]v1beta1.VolumeStatus, len(src.Status.Storage))
+		for i, volume := range src.Status.Storage {
+			dst.Status.Storage[i] = v1beta1.VolumeStatus(volume)
+		}
+	}
+	if clone := src.Status.Clone; clone != nil {
+		dst.Status.Clone = &v1beta1.CloneStatus{
+			Source:         clone.Source,
+			Method:         v1beta1.CloneMethod(clone.Method),
+			Streamed:       clone.Streamed,
+			Completed:      clone.Completed,
+			CompletionTime: clone.CompletionTime,
+		}
+	}
+	return nil
+}
+
+// ConvertFrom converts from the Hub version (v1beta1) to this version.
+func (dst *MariaDB) ConvertFrom(srcRaw conversion.Hub) error {
+	src := srcRaw.(*v1beta1.MariaDB)
+	dst.ObjectMeta = src.ObjectMeta
+
+	dst.Spec = MariaDBSpec{
+		Replicas:            src.Spec.Replicas,
+		Username:            src.Spec.Credentials.Username,
+		Password:            src.Spec.Credentials.Password,
+		Database:            src.Spec.Database,
+		Rootpwd:             src.Spec.Credentials.RootPassword,
+		Image:               src.Spec.Image,
+		ImageVersion:        src.Spec.ImageVersion,
+		DataStoragePath:     src.Spec.Storage.Path,
+		DataStorageSize:     src.Spec.Storage.Size,
+		Port:                src.Spec.Service.Port,
+		NetworkPolicy:       (*NetworkPolicySpec)(src.Spec.NetworkPolicy),
+		Metrics:             (*MetricsSpec)(src.Spec.Metrics),
+		HealthCheck:         (*HealthCheckSpec)(src.Spec.HealthCheck),
+		Paused:              src.Spec.Paused,
+		ScaleDownWhenPaused: src.Spec.ScaleDownWhenPaused,
+		DriftPolicy:         DriftPolicy(src.Spec.DriftPolicy),
+		MaxScale:            (*MaxScaleSpec)(src.Spec.MaxScale),
+	}
+	if src.Spec.ConnectionSecrets != nil {
+		dst.Spec.ConnectionSecrets = make([]ConnectionSecret, len(src.Spec.ConnectionSecrets))
+		for i, secret := range src.Spec.ConnectionSecrets {
+			dst.Spec.ConnectionSecrets[i] = ConnectionSecret(secret)
+		}
+	}
+	if src.Spec.InitScripts != nil {
+		dst.Spec.InitScripts = make([]InitScript, len(src.Spec.InitScripts))
+		for i, script := range src.Spec.InitScripts {
+			dst.Spec.InitScripts[i] = InitScript(script)
+		}
+	}
+	if bootstrap := src.Spec.Bootstrap; bootstrap != nil {
+		dst.Spec.Bootstrap = &BootstrapSpec{VolumeSnapshot: bootstrap.VolumeSnapshot}
+		if restore := bootstrap.Backup; restore != nil {
+			dst.Spec.Bootstrap.Backup = &BackupRestoreSpec{
+				Name:            restore.Name,
+				VolumeClaimName: restore.VolumeClaimName,
+				Encryption:      (*BackupEncryptionSpec)(restore.Encryption),
+			}
+		}
+		if clone := bootstrap.CloneFrom; clone != nil {
+			dst.Spec.Bootstrap.CloneFrom = &CloneSpec{
+				Name:          clone.Name,
+				Namespace:     clone.Namespace,
+				Method:        CloneMethod(clone.Method),
+				MaskingScript: (*InitScript)(clone.MaskingScript),
+			}
+		}
+	}
+	if storage := src.Spec.Storage; storage.StorageClassName != nil || storage.AccessModes != nil {
+		dst.Spec.Storage = &StorageSpec{
+			StorageClassName: storage.StorageClassName,
+			AccessModes:      storage.AccessModes,
+		}
+	}
+	if logging := src.Spec.Logging; logging != nil {
+		dst.Spec.Logging = &LoggingSpec{
+			ErrorLog:     logging.ErrorLog,
+			SlowQueryLog: (*SlowQueryLogSpec)(logging.SlowQueryLog),
+			GeneralLog:   logging.GeneralLog,
+			SidecarImage: logging.SidecarImage,
+			MaxFileSize:  logging.MaxFileSize,
+		}
+	}
+	if audit := src.Spec.Audit; audit != nil {
+		dst.Spec.Audit = &AuditSpec{
+			Enabled:        audit.Enabled,
+			ExcludedUsers:  audit.ExcludedUsers,
+			Output:         audit.Output,
+			FileRotateSize: audit.FileRotateSize,
+			FileRotations:  audit.FileRotations,
+		}
+		if audit.Events != nil {
+			dst.Spec.Audit.Events = make([]AuditEvent, len(audit.Events))
+			for i, event := range audit.Events {
+				dst.Spec.Audit.Events[i] = AuditEvent(event)
+			}
+		}
+	}
+	if backup := src.Spec.Backup; backup != nil {
+		dst.Spec.Backup = &BackupSpec{
+			Schedule:                backup.Schedule,
+			Method:                  BackupMethod(backup.Method),
+			VolumeSnapshotClassName: backup.VolumeSnapshotClassName,
+			Retention:               backup.Retention,
+		}
+		if dump := backup.Dump; dump != nil {
+			dst.Spec.Backup.Dump = &DumpBackupSpec{
+				VolumeClaimName: dump.VolumeClaimName,
+				Compression:     dump.Compression,
+				Encryption:      (*BackupEncryptionSpec)(dump.Encryption),
+			}
+		}
+	}
+	if window := src.Spec.MaintenanceWindow; window != nil {
+		dst.Spec.MaintenanceWindow = &MaintenanceWindowSpec{TimeZone: window.TimeZone}
+		if window.Windows != nil {
+			dst.Spec.MaintenanceWindow.Windows = make([]MaintenanceWindow, len(window.Windows))
+			for i, w := range window.Windows {
+				dst.Spec.MaintenanceWindow.Windows[i] = MaintenanceWindow{Start: w.Start, End: w.End}
+				if w.Days != nil {
+					dst.Spec.MaintenanceWindow.Windows[i].Days = make([]Weekday, len(w.Days))
+					for j, day := range w.Days {
+						dst.Spec.MaintenanceWindow.Windows[i].Days[j] = Weekday(day)
+					}
+				}
+			}
+		}
+	}
+
+	dst.Status = MariaDBStatus{
+		CurrentReplicas:    src.Status.CurrentReplicas,
+		DesiredReplicas:    src.Status.DesiredReplicas,
+		LastMessage:        src.Status.LastMessage,
+		DbState:            StatusPhase(src.Status.DbState),
+		ShowState:          src.Status.ShowState,
+		ObservedGeneration: src.Status.ObservedGeneration,
+		ReadyTime:          src.Status.ReadyTime,
+		ReadyReplicas:      src.Status.ReadyReplicas,
+		Selector:           src.Status.Selector,
+		StatsPod:           src.Status.StatsPod,
+		LastBackupTime:     src.Status.LastBackupTime,
+		Binding:            src.Status.Binding,
+		ConnectionSecrets:  src.Status.ConnectionSecrets,
+		MaxScaleUserPods:   src.Status.MaxScaleUserPods,
+		CurrentVersion:     src.Status.CurrentVersion,
+		Upgrade:            (*UpgradeStatus)(src.Status.Upgrade),
+		AuditPluginActive:  src.Status.AuditPluginActive,
+		ExporterUserPods:   src.Status.ExporterUserPods,
+		Health:             (*HealthStatus)(src.Status.Health),
+		Backup:             (*BackupStatus)(src.Status.Backup),
+		Initialization:     (*InitializationStatus)(src.Status.Initialization),
+		PendingChanges:     (*PendingChangesStatus)(src.Status.PendingChanges),
+		Conditions:         src.Status.Conditions,
+	}
+	if src.Status.Storage != nil {
+		dst.Status.Storage = make([]VolumeStatus, len(src.Status.Storage))
+		for i, volume := range src.Status.Storage {
+			dst.Status.Storage[i] = VolumeStatus(volume)
+		}
+	}
+	if clone := src.Status.Clone; clone != nil {
+		dst.Status.Clone = &CloneStatus{
+			Source:         clone.Source,
+			Method:         CloneMethod(clone.Method),
+			Streamed:       clone.Streamed,
+			Completed:      clone.Completed,
+			CompletionTime: clone.CompletionTime,
+		}
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"testing"
+
+	fuzz "github.com/google/gofuzz"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/diff"
+
+	"github.com/mariadb/mariadb.org-tools/mariadb-operator/api/v1beta1"
+)
+
+const fuzzIterations = 1000
+
+// conversionFuzzer fills every field, leaving out TypeMeta which is set by
+// the conversion webhook and an empty v1alpha1 storage, which v1beta1 can't
+// tell apart from no storage.
+func conversionFuzzer() *fuzz.Fuzzer {
+	return fuzz.New().NilChance(0.2).Funcs(
+		func(m *MariaDB, c fuzz.Continue) {
+			c.FuzzNoCustom(m)
+			m.TypeMeta = metav1.TypeMeta{}
+			if s := m.Spec.Storage; s != nil && s.StorageClassName == nil && s.AccessModes == nil {
+				m.Spec.Storage = nil
+			}
+		},
+		func(m *v1beta1.MariaDB, c fuzz.Continue) {
+			c.FuzzNoCustom(m)
+			m.TypeMeta = metav1.TypeMeta{}
+		},
+	)
+}
+
+func TestMariaDBRoundTripThroughHub(t *testing.T) {
+	f := conversionFuzzer()
+	for i := 0; i < fuzzIterations; i++ {
+		var src MariaDB
+		f.Fuzz(&src)
+
+		var hub v1beta1.MariaDB
+		if err := src.ConvertTo(&hub); err != nil {
+			t.Fatalf("converting to v1beta1: %v", err)
+		}
+		var dst MariaDB
+		if err := dst.ConvertFrom(&hub); err != nil {
+			t.Fatalf("converting from v1beta1: %v", err)
+		}
+
+		if !apiequality.Semantic.DeepEqual(src, dst) {
+			t.Fatalf("v1alpha1 changed by the round trip:\n%s", diff.ObjectReflectDiff(src, dst))
+		}
+	}
+}
+
+func TestHubRoundTripThroughMariaDB(t *testing.T) {
+	f := conversionFuzzer()
+	for i := 0; i < fuzzIterations; i++ {
+		var src v1beta1.MariaDB
+		f.Fuzz(&src)
+
+		var spoke MariaDB
+		if err := spoke.ConvertFrom(&src); err != nil {
+			t.Fatalf("converting from v1beta1: %v", err)
+		}
+		var dst v1beta1.MariaDB
+		if err := spoke.ConvertTo(&dst); err != nil {
+			t.Fatalf("converting to v1beta1: %v", err)
+		}
+
+		if !apiequality.Semantic.DeepEqual(src, dst) {
+			t.Fatalf("v1beta1 changed by the round trip:\n%s", diff.ObjectReflectDiff(src, dst))
+		}
+	}
+}
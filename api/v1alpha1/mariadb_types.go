@@ -17,6 +17,8 @@ limitations under the License.
 package v1alpha1
 
 import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -26,9 +28,11 @@ import (
 // MariaDBSpec defines the desired state of MariaDB
 type MariaDBSpec struct {
 
+	// Number of server pods, 0 or 1. The pods don't replicate each other,
+	// more of them would each serve their own data behind the Service.
 	// +optional
 	// +kubebuilder:default=1
-	// +kubebuilder:validation:Maximum=4
+	// +kubebuilder:validation:Maximum=1
 	Replicas *int32 `json:"replicas"`
 
 	// Database additional user details (base64 encoded)
@@ -60,15 +64,486 @@ type MariaDBSpec struct {
 	// +kubebuilder:validation:Required
 	DataStoragePath string `json:"dataStoragePath"`
 
-	// Database storage Size (Ex. 1Gi, 100Mi)
+	// Database storage Size (Ex. 1Gi, 100Mi), the data directory is a
+	// PersistentVolumeClaim of that size when set, and an emptyDir otherwise.
+	// It can be increased to grow the volume, but not decreased.
 	// +optional
 	DataStorageSize string `json:"dataStorageSize"`
 
+	// Storage class and access modes of the data PersistentVolumeClaim
+	// +optional
+	Storage *StorageSpec `json:"storage,omitempty"`
+
 	// Port number exposed for Database service
 	// +optional
 	// +kubebuilder:default=3306
 
 	Port int32 `json:"port"`
+
+	// NetworkPolicy restricting which pods can reach the instance
+	// +optional
+	NetworkPolicy *NetworkPolicySpec `json:"networkPolicy,omitempty"`
+
+	// Prometheus mysqld-exporter sidecar and ServiceMonitor
+	// +optional
+	Metrics *MetricsSpec `json:"metrics,omitempty"`
+
+	// Server logs written to files, each streamed to stdout by its own sidecar
+	// +optional
+	Logging *LoggingSpec `json:"logging,omitempty"`
+
+	// server_audit plugin settings
+	// +optional
+	Audit *AuditSpec `json:"audit,omitempty"`
+
+	// Interval and thresholds of the server health checks
+	// +optional
+	HealthCheck *HealthCheckSpec `json:"healthCheck,omitempty"`
+
+	// Suspend reconciliation, owned objects aren't modified until it's
+	// unset while the status is still refreshed. Setting the
+	// mariadb.org/paused annotation to "true" has the same effect.
+	// +optional
+	Paused bool `json:"paused,omitempty"`
+
+	// Scale the instance to zero replicas while paused
+	// +optional
+	ScaleDownWhenPaused bool `json:"scaleDownWhenPaused,omitempty"`
+
+	// When changes restarting the pods (configuration, image, ...) can be
+	// applied, they are applied right away when unset. Setting the
+	// mariadb.org/apply-now annotation to "true" applies them immediately, the
+	// annotation is removed once they are.
+	// +optional
+	MaintenanceWindow *MaintenanceWindowSpec `json:"maintenanceWindow,omitempty"`
+
+	// What to do when the Deployment or Service were edited outside of the
+	// operator: Revert the edits, or Report them and leave them in place
+	// until the next change of the spec.
+	// +optional
+	// +kubebuilder:default=Revert
+	// +kubebuilder:validation:Enum=Revert;Report
+	DriftPolicy DriftPolicy `json:"driftPolicy,omitempty"`
+
+	// Copies of the connection Secret kept in other namespaces, which must
+	// be watched by the operator and allowed to receive them by its namespace
+	// selector. The operator needs to get namespaces, a cluster permission.
+	// +optional
+	ConnectionSecrets []ConnectionSecret `json:"connectionSecrets,omitempty"`
+
+	// Scripts the image runs in this order on the first boot of a server,
+	// while its data directory is empty. Keys must end in .sql, .sql.gz or
+	// .sh, and the scripts need storage.size to be set
+	// +optional
+	// +kubebuilder:validation:MaxItems=100
+	InitScripts []InitScript `json:"initScripts,omitempty"`
+
+	// How the data directory of a new instance is populated, before its
+	// server starts. It needs a storage size, and is ignored once the
+	// instance is initialized
+	// +optional
+	Bootstrap *BootstrapSpec `json:"bootstrap,omitempty"`
+
+	// Scheduled backups of the instance
+	// +optional
+	Backup *BackupSpec `json:"backup,omitempty"`
+
+	// MaxScale proxy in front of the instance
+	// +optional
+	MaxScale *MaxScaleSpec `json:"maxscale,omitempty"`
+}
+
+// InitScript references a script held in a ConfigMap or in a Secret, one of
+// the two must be set
+type InitScript struct {
+	// Key of a ConfigMap holding the script
+	// +optional
+	ConfigMapKeyRef *corev1.ConfigMapKeySelector `json:"configMapKeyRef,omitempty"`
+
+	// Key of a Secret holding the script, for scripts with credentials
+	// +optional
+	SecretKeyRef *corev1.SecretKeySelector `json:"secretKeyRef,omitempty"`
+}
+
+// BootstrapSpec populates the data of a new instance
+type BootstrapSpec struct {
+	// Copy the data of another instance
+	// +optional
+	CloneFrom *CloneSpec `json:"cloneFrom,omitempty"`
+
+	// Provision the data volume from a VolumeSnapshot of a snapshot backup,
+	// the storage size must be at least the size of the snapshot. Only read
+	// when the data volume is created
+	// +optional
+	VolumeSnapshot *corev1.LocalObjectReference `json:"volumeSnapshot,omitempty"`
+
+	// Load a dump backup, after its checksum is verified. The server is
+	// restarted once more after the restore, to release the backup volume
+	// +optional
+	Backup *BackupRestoreSpec `json:"backup,omitempty"`
+}
+
+// BackupRestoreSpec names a dump backup to restore
+type BackupRestoreSpec struct {
+	// Name of the backup, the mariadb.org/backup label of the Job that took it
+	Name string `json:"name"`
+
+	// PersistentVolumeClaim the backup is stored in
+	VolumeClaimName string `json:"volumeClaimName"`
+
+	// Key the backup was encrypted with
+	// +optional
+	Encryption *BackupEncryptionSpec `json:"encryption,omitempty"`
+}
+
+// CloneMethod is how the data of the source instance is copied
+// +kubebuilder:validation:Enum=Logical;Physical
+type CloneMethod string
+
+const (
+	// CloneLogical dumps the databases of the source with mariadb-dump, run by a
+	// temporary read only user dropped once done, the users of the clone are
+	// the ones of its spec
+	CloneLogical CloneMethod = "Logical"
+	// ClonePhysical streams a mariadb-backup of the source data directory,
+	// users and grants included, the versions must have the same major
+	ClonePhysical CloneMethod = "Physical"
+)
+
+// CloneSpec names the instance to clone
+type CloneSpec struct {
+	// Name of the source MariaDB
+	Name string `json:"name"`
+
+	// Namespace of the source MariaDB, the one of the clone when empty. Other
+	// namespaces must be allowed by the operator
+	// +optional
+	Namespace string `json:"namespace,omitempty"`
+
+	// +optional
+	// +kubebuilder:default=Logical
+	Method CloneMethod `json:"method,omitempty"`
+
+	// SQL script run on the clone once its server is up, Ex. to mask personal data
+	// +optional
+	MaskingScript *InitScript `json:"maskingScript,omitempty"`
+}
+
+// BackupMethod is how backups of the instance are taken
+// +kubebuilder:validation:Enum=Snapshot;Dump
+type BackupMethod string
+
+const (
+	// BackupSnapshot takes a VolumeSnapshot of each data volume while the
+	// server is quiesced
+	BackupSnapshot BackupMethod = "Snapshot"
+	// BackupDump runs a Job dumping the databases with mariadb-dump, the
+	// system schemas and the users excluded
+	BackupDump BackupMethod = "Dump"
+)
+
+// BackupSpec schedules backups of the instance
+type BackupSpec struct {
+	// Cron schedule of the backups, in the time zone of the operator, Ex. "0 3 * * *"
+	Schedule string `json:"schedule"`
+
+	// +optional
+	// +kubebuilder:default=Snapshot
+	Method BackupMethod `json:"method,omitempty"`
+
+	// VolumeSnapshotClass of the snapshots, the default class when empty
+	// +optional
+	VolumeSnapshotClassName *string `json:"volumeSnapshotClassName,omitempty"`
+
+	// Number of backups kept, the oldest ones are deleted
+	// +optional
+	// +kubebuilder:default=7
+	// +kubebuilder:validation:Minimum=1
+	Retention int32 `json:"retention,omitempty"`
+
+	// Where and how dumps are stored, required by the Dump method
+	// +optional
+	Dump *DumpBackupSpec `json:"dump,omitempty"`
+}
+
+// DumpBackupSpec configures the artifacts of dump backups, each is stored
+// next to a manifest with its checksum, codec and key ID
+type DumpBackupSpec struct {
+	// PersistentVolumeClaim the artifacts are written to, it must exist
+	VolumeClaimName string `json:"volumeClaimName"`
+
+	// +optional
+	// +kubebuilder:default=gzip
+	// +kubebuilder:validation:Enum=none;gzip;zstd
+	Compression string `json:"compression,omitempty"`
+
+	// Encrypt the artifacts, they are stored in clear when unset
+	// +optional
+	Encryption *BackupEncryptionSpec `json:"encryption,omitempty"`
+}
+
+// BackupEncryptionSpec is the key encrypting dump artifacts with AES-256-GCM
+type BackupEncryptionSpec struct {
+	// Key of a Secret holding the 32 bytes of the key, Ex. from openssl rand 32
+	SecretKeyRef corev1.SecretKeySelector `json:"secretKeyRef"`
+
+	// Identifies the key in the manifests, to find it again after a rotation.
+	// The Secret name and key when empty
+	// +optional
+	KeyID string `json:"keyID,omitempty"`
+}
+
+// MaxScaleSpec configures a MaxScale Deployment in front of the instance, with
+// its own Service. The instance pods don't replicate, so it requires a single
+// replica. Its clients are restricted by the network policy of the instance.
+type MaxScaleSpec struct {
+	// Render the MaxScale Deployment and Service
+	// +optional
+	Enabled bool `json:"enabled"`
+
+	// MaxScale image
+	// +optional
+	// +kubebuilder:default="mariadb/maxscale:6.2"
+	Image string `json:"image,omitempty"`
+
+	// +optional
+	// +kubebuilder:default=1
+	// +kubebuilder:validation:Minimum=1
+	Replicas *int32 `json:"replicas,omitempty"`
+
+	// Port of the readwritesplit listener and of the MaxScale Service
+	// +optional
+	// +kubebuilder:default=3306
+	Port int32 `json:"port,omitempty"`
+
+	// Monitor module, galeramon for Galera clusters
+	// +optional
+	// +kubebuilder:default=mariadbmon
+	// +kubebuilder:validation:Enum=mariadbmon;galeramon
+	Monitor string `json:"monitor,omitempty"`
+}
+
+// ConnectionSecret is a copy of the connection Secret in another namespace
+type ConnectionSecret struct {
+	// +kubebuilder:validation:MinLength=1
+	Namespace string `json:"namespace"`
+
+	// Name of the copy, the name of the connection Secret when empty
+	// +optional
+	Name string `json:"name,omitempty"`
+}
+
+type DriftPolicy string
+
+const (
+	RevertDriftPolicy DriftPolicy = "Revert"
+	ReportDriftPolicy DriftPolicy = "Report"
+)
+
+// MaintenanceWindowSpec lists the time ranges disruptive changes are applied in
+type MaintenanceWindowSpec struct {
+	// IANA time zone of the windows (Ex. Europe/Helsinki)
+	// +optional
+	// +kubebuilder:default="UTC"
+	TimeZone string `json:"timeZone,omitempty"`
+
+	// +kubebuilder:validation:MinItems=1
+	Windows []MaintenanceWindow `json:"windows"`
+}
+
+// +kubebuilder:validation:Enum=Monday;Tuesday;Wednesday;Thursday;Friday;Saturday;Sunday
+type Weekday string
+
+// MaintenanceWindow is a time range on some days of the week. It ends on the
+// next day when end isn't after start.
+type MaintenanceWindow struct {
+	// Days the window starts on, every day when empty
+	// +optional
+	Days []Weekday `json:"days,omitempty"`
+
+	// Start time (Ex. 02:00)
+	// +kubebuilder:validation:Pattern=`^([01][0-9]|2[0-3]):[0-5][0-9]$`
+	Start string `json:"start"`
+
+	// End time (Ex. 04:30)
+	// +kubebuilder:validation:Pattern=`^([01][0-9]|2[0-3]):[0-5][0-9]$`
+	End string `json:"end"`
+}
+
+// HealthCheckSpec sets how often the server is inspected and the thresholds
+// over which the instance is reported Degraded
+type HealthCheckSpec struct {
+	// Time between two health checks (Ex. 30s, 5m)
+	// +optional
+	// +kubebuilder:default="1m"
+	Interval *metav1.Duration `json:"interval,omitempty"`
+
+	// Percentage of max_connections in use over which the instance is degraded
+	// +optional
+	// +kubebuilder:default=90
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=100
+	MaxConnectionsPercent int32 `json:"maxConnectionsPercent,omitempty"`
+
+	// Percentage of the data volume in use over which the instance is degraded
+	// +optional
+	// +kubebuilder:default=85
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=100
+	MaxDataUsagePercent int32 `json:"maxDataUsagePercent,omitempty"`
+
+	// InnoDB buffer pool hit ratio percentage under which the instance is degraded
+	// +optional
+	// +kubebuilder:default=90
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=100
+	MinBufferPoolHitPercent int32 `json:"minBufferPoolHitPercent,omitempty"`
+}
+
+// StorageSpec configures the data PersistentVolumeClaim, these can't be changed once it exists
+type StorageSpec struct {
+	// Storage class of the claim, the cluster default is used when empty
+	// +optional
+	StorageClassName *string `json:"storageClassName,omitempty"`
+
+	// Access modes of the claim, defaults to ReadWriteOnce
+	// +optional
+	AccessModes []corev1.PersistentVolumeAccessMode `json:"accessModes,omitempty"`
+}
+
+// MetricsSpec configures the mysqld-exporter sidecar. The exporter connects
+// with its own user, only granted what it needs to read server statistics.
+type MetricsSpec struct {
+	// Add the exporter sidecar and metrics port to the instance
+	// +optional
+	Enabled bool `json:"enabled"`
+
+	// Exporter image
+	// +optional
+	// +kubebuilder:default="prom/mysqld-exporter:v0.14.0"
+	Image string `json:"image,omitempty"`
+
+	// Port the exporter listens on
+	// +optional
+	// +kubebuilder:default=9104
+	Port int32 `json:"port,omitempty"`
+
+	// Labels of the ServiceMonitor, to match the serviceMonitorSelector of Prometheus
+	// +optional
+	ServiceMonitorLabels map[string]string `json:"serviceMonitorLabels,omitempty"`
+
+	// Namespaces whose pods can scrape the metrics port when the NetworkPolicy
+	// is enabled, the monitoring namespace when neither this nor scrapePods is set
+	// +optional
+	ScrapeNamespaces *metav1.LabelSelector `json:"scrapeNamespaces,omitempty"`
+
+	// Pods that can scrape the metrics port, in the scrape namespaces or else
+	// in the instance namespace
+	// +optional
+	ScrapePods *metav1.LabelSelector `json:"scrapePods,omitempty"`
+}
+
+// LoggingSpec selects the server logs shipped through sidecars, so cluster log
+// collectors can route them separately from the server output
+type LoggingSpec struct {
+	// Error log
+	// +optional
+	ErrorLog bool `json:"errorLog,omitempty"`
+
+	// Slow query log
+	// +optional
+	SlowQueryLog *SlowQueryLogSpec `json:"slowQueryLog,omitempty"`
+
+	// General query log, every statement received by the server
+	// +optional
+	GeneralLog bool `json:"generalLog,omitempty"`
+
+	// Image of the sidecars tailing the log files
+	// +optional
+	// +kubebuilder:default="busybox:1.34"
+	SidecarImage string `json:"sidecarImage,omitempty"`
+
+	// Size (Ex. 100Mi) at which the sidecars truncate the error, slow query
+	// and general logs, after streaming them. It also bounds the log volume.
+	// +optional
+	// +kubebuilder:default="100Mi"
+	MaxFileSize string `json:"maxFileSize,omitempty"`
+}
+
+// SlowQueryLogSpec sets which queries are slow enough to be logged
+type SlowQueryLogSpec struct {
+	// +optional
+	Enabled bool `json:"enabled"`
+
+	// Seconds a query takes to be logged (Ex. 0.5)
+	// +optional
+	// +kubebuilder:default="10"
+	// +kubebuilder:validation:Pattern=`^[0-9]+(\.[0-9]+)?$`
+	LongQueryTime string `json:"longQueryTime,omitempty"`
+
+	// Rows a query examines before it can be logged
+	// +optional
+	// +kubebuilder:validation:Minimum=0
+	MinExaminedRowLimit *int64 `json:"minExaminedRowLimit,omitempty"`
+
+	// Also log queries that don't use an index
+	// +optional
+	LogQueriesNotUsingIndexes bool `json:"logQueriesNotUsingIndexes,omitempty"`
+}
+
+// +kubebuilder:validation:Enum=CONNECT;QUERY_DDL;QUERY_DCL;TABLE
+type AuditEvent string
+
+// AuditSpec loads the server_audit plugin and sets what it logs
+type AuditSpec struct {
+	// Load the plugin and log audit events
+	// +optional
+	Enabled bool `json:"enabled"`
+
+	// Events logged, all of them when empty
+	// +optional
+	Events []AuditEvent `json:"events,omitempty"`
+
+	// Users whose activity isn't logged
+	// +optional
+	ExcludedUsers []string `json:"excludedUsers,omitempty"`
+
+	// Write events to a file (streamed by an audit-log sidecar) or to syslog
+	// +optional
+	// +kubebuilder:default=file
+	// +kubebuilder:validation:Enum=file;syslog
+	Output string `json:"output,omitempty"`
+
+	// Size in bytes at which the audit file is rotated
+	// +optional
+	// +kubebuilder:validation:Minimum=100
+	FileRotateSize *int64 `json:"fileRotateSize,omitempty"`
+
+	// Number of rotated audit files kept
+	// +optional
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=999
+	FileRotations *int32 `json:"fileRotations,omitempty"`
+}
+
+// NetworkPolicySpec selects the clients admitted to the database port. Members
+// of the instance (replication and Galera ports) and the operator are always admitted.
+type NetworkPolicySpec struct {
+	// Render the NetworkPolicy
+	// +optional
+	Enabled bool `json:"enabled"`
+
+	// Namespaces whose pods can reach the database port
+	// +optional
+	AllowedNamespaces *metav1.LabelSelector `json:"allowedNamespaces,omitempty"`
+
+	// Pods that can reach the database port, in the allowed namespaces or else in the instance namespace
+	// +optional
+	AllowedPods *metav1.LabelSelector `json:"allowedPods,omitempty"`
+
+	// Without allowed namespaces or pods, deny all clients instead of admitting the instance namespace
+	// +optional
+	DefaultDeny bool `json:"defaultDeny,omitempty"`
 }
 
 type StatusPhase string
@@ -90,12 +565,250 @@ type MariaDBStatus struct {
 	// +kubebuilder:default="NOT STARTED"
 
 	ShowState string `json:"showState"`
+
+	// Generation of the spec last applied
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// When all replicas of the instance were first ready
+	// +optional
+	ReadyTime *metav1.Time `json:"readyTime,omitempty"`
+
+	// Replicas with all their containers ready
+	// +optional
+	ReadyReplicas int32 `json:"readyReplicas,omitempty"`
+
+	// Label selector of the instance pods, for the scale subresource
+	// +optional
+	Selector string `json:"selector,omitempty"`
+
+	// Oldest ready pod of the instance, the one statistics are read from and
+	// commands are run on
+	// +optional
+	StatsPod string `json:"statsPod,omitempty"`
+
+	// When the last successful backup completed
+	// +optional
+	LastBackupTime *metav1.Time `json:"lastBackupTime,omitempty"`
+
+	// Secret with the connection details of the instance, following the
+	// Service Binding specification for provisioned services
+	// +optional
+	Binding *corev1.LocalObjectReference `json:"binding,omitempty"`
+
+	// Copies of the connection Secret in other namespaces, as namespace/name
+	// +optional
+	ConnectionSecrets []string `json:"connectionSecrets,omitempty"`
+
+	// Pods on which the MaxScale user has been created
+	// +optional
+	MaxScaleUserPods []string `json:"maxscaleUserPods,omitempty"`
+
+	// Server version running on all pods of the instance
+	// +optional
+	CurrentVersion string `json:"currentVersion,omitempty"`
+
+	// Progress of the version upgrade in progress, if any
+	// +optional
+	Upgrade *UpgradeStatus `json:"upgrade,omitempty"`
+
+	// Data volumes of the instance
+	// +optional
+	Storage []VolumeStatus `json:"storage,omitempty"`
+
+	// Whether the server_audit plugin is ACTIVE on all ready pods
+	// +optional
+	AuditPluginActive bool `json:"auditPluginActive,omitempty"`
+
+	// Pods on which the metrics exporter user has been created
+	// +optional
+	ExporterUserPods []string `json:"exporterUserPods,omitempty"`
+
+	// Server statistics collected by the last health check
+	// +optional
+	Health *HealthStatus `json:"health,omitempty"`
+
+	// Initialization of the data directory by the init scripts
+	// +optional
+	Initialization *InitializationStatus `json:"initialization,omitempty"`
+
+	// Scheduled backups
+	// +optional
+	Backup *BackupStatus `json:"backup,omitempty"`
+
+	// Progress of the clone of another instance
+	// +optional
+	Clone *CloneStatus `json:"clone,omitempty"`
+
+	// Changes to the pods waiting for the next maintenance window
+	// +optional
+	PendingChanges *PendingChangesStatus `json:"pendingChanges,omitempty"`
+
+	// Latest observations of the instance state, Ex. Degraded
+	// +optional
+	// +patchMergeKey=type
+	// +patchStrategy=merge
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type"`
+}
+
+// Condition types of MariaDB objects
+const (
+	// ConditionDegraded is true when a health check crossed one of its thresholds
+	ConditionDegraded = "Degraded"
+	// ConditionPaused is true while reconciliation is suspended
+	ConditionPaused = "Paused"
+)
+
+// InitializationStatus records the init scripts the instance was initialized
+// with, they are never run again on an initialized data directory
+type InitializationStatus struct {
+	// The first server booted and ran the init scripts
+	Completed bool `json:"completed"`
+
+	// +optional
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+
+	// Hash of the init scripts run at initialization
+	// +optional
+	ScriptsHash string `json:"scriptsHash,omitempty"`
+
+	// The init scripts were edited after initialization, the edits were not applied
+	// +optional
+	ScriptsChanged bool `json:"scriptsChanged,omitempty"`
+}
+
+// BackupStatus reports the scheduled backups, LastBackupTime is the time of
+// the last successful one
+type BackupStatus struct {
+	// When the schedule last started a backup
+	// +optional
+	LastScheduleTime *metav1.Time `json:"lastScheduleTime,omitempty"`
+
+	// Name of the backup whose Job is running or whose snapshots aren't
+	// ready to use yet
+	// +optional
+	InProgress string `json:"inProgress,omitempty"`
+
+	// Name of the last successful backup, the mariadb.org/backup label of its snapshots
+	// +optional
+	LastBackup string `json:"lastBackup,omitempty"`
+
+	// Why the last backup failed, empty when it succeeded
+	// +optional
+	LastError string `json:"lastError,omitempty"`
+}
+
+// CloneStatus tracks the copy of the source data into a new instance
+type CloneStatus struct {
+	// Source MariaDB, as namespace/name
+	Source string `json:"source"`
+
+	Method CloneMethod `json:"method"`
+
+	// The backup of the source was streamed into the data volume
+	// +optional
+	Streamed bool `json:"streamed,omitempty"`
+
+	// The server started on the copied data and the masking script ran
+	// +optional
+	Completed bool `json:"completed,omitempty"`
+
+	// +optional
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+}
+
+// PendingChangesStatus describes pod changes staged until the maintenance window
+type PendingChangesStatus struct {
+	// Hash of the pod template to be applied
+	TemplateHash string `json:"templateHash"`
+
+	// When the changes were first staged
+	// +optional
+	Since *metav1.Time `json:"since,omitempty"`
+
+	// Start of the maintenance window they will be applied in
+	// +optional
+	NextWindow *metav1.Time `json:"nextWindow,omitempty"`
+}
+
+// HealthStatus is a summary of the server statistics, read from the oldest ready pod
+type HealthStatus struct {
+	// Pod the statistics were read from
+	Pod string `json:"pod"`
+
+	// Version reported by the server
+	// +optional
+	Version string `json:"version,omitempty"`
+
+	// Seconds since the server started
+	// +optional
+	UptimeSeconds int64 `json:"uptimeSeconds,omitempty"`
+
+	// Open connections
+	// +optional
+	Connections int64 `json:"connections,omitempty"`
+
+	// max_connections of the server
+	// +optional
+	MaxConnections int64 `json:"maxConnections,omitempty"`
+
+	// Space used on the data directory volume
+	// +optional
+	DataUsed *resource.Quantity `json:"dataUsed,omitempty"`
+
+	// Capacity of the data volume, the PersistentVolumeClaim capacity when there is one
+	// +optional
+	DataCapacity *resource.Quantity `json:"dataCapacity,omitempty"`
+
+	// Percentage of InnoDB buffer pool reads served from memory (Ex. 99.87)
+	// +optional
+	BufferPoolHitRatio string `json:"bufferPoolHitRatio,omitempty"`
+
+	// +optional
+	CheckTime *metav1.Time `json:"checkTime,omitempty"`
+}
+
+// VolumeStatus reports the size of a data PersistentVolumeClaim
+type VolumeStatus struct {
+	// Name of the PersistentVolumeClaim
+	Name string `json:"name"`
+
+	// +optional
+	Requested *resource.Quantity `json:"requested,omitempty"`
+
+	// Size of the bound volume, lags behind requested while resizing
+	// +optional
+	Capacity *resource.Quantity `json:"capacity,omitempty"`
+
+	// Resizing or FileSystemResizePending while the volume grows
+	// +optional
+	ResizeStatus string `json:"resizeStatus,omitempty"`
+}
+
+// UpgradeStatus tracks a rolling version upgrade
+type UpgradeStatus struct {
+	FromVersion string `json:"fromVersion"`
+	ToVersion   string `json:"toVersion"`
+
+	// Pods started with the new version on which mariadb-upgrade completed
+	// +optional
+	UpgradedPods []string `json:"upgradedPods,omitempty"`
+
+	// +optional
+	StartTime *metav1.Time `json:"startTime,omitempty"`
 }
 
 //+kubebuilder:object:root=true
 //+kubebuilder:subresource:status
+//+kubebuilder:subresource:scale:specpath=.spec.replicas,statuspath=.status.currentReplicas,selectorpath=.status.selector
 // +kubebuilder:printcolumn:priority=0,name=MariaDB State,type=string,JSONPath=".status.showState",description="State of the MariaDB instance",format=""
+// +kubebuilder:printcolumn:priority=0,name=Ready,type=integer,JSONPath=".status.readyReplicas",description="Ready replicas of the MariaDB instance"
 // +kubebuilder:printcolumn:priority=0,name=Port,type=string,JSONPath=".spec.port",description="Port of the MariaDB instance",format=""
+// +kubebuilder:printcolumn:priority=0,name=Stats Pod,type=string,JSONPath=".status.statsPod",description="Pod statistics are read from"
+// +kubebuilder:printcolumn:priority=0,name=Version,type=string,JSONPath=".status.currentVersion",description="Server version running on all pods"
+// +kubebuilder:printcolumn:priority=0,name=Last Backup,type=date,JSONPath=".status.lastBackupTime",description="When the last successful backup completed"
 // +kubebuilder:printcolumn:priority=1,name=Image,type=string,JSONPath=".spec.image",description="Image of the MariaDB instance",format=""
 // +kubebuilder:printcolumn:priority=0,name=Age, type=date,JSONPath=".metadata.creationTimestamp"
 
@@ -0,0 +1,114 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// MariaDBSQLJobSpec defines the desired state of MariaDBSQLJob
+type MariaDBSQLJobSpec struct {
+	// MariaDB instance (in the same namespace) the SQL is run against
+	// +kubebuilder:validation:Required
+	MariaDBRef corev1.LocalObjectReference `json:"mariaDBRef"`
+
+	// Database selected before running the SQL, defaults to the instance database
+	// +optional
+	Database string `json:"database,omitempty"`
+
+	// Inline SQL to run
+	// +optional
+	SQL string `json:"sql,omitempty"`
+
+	// ConfigMap key holding the SQL to run, used when sql is empty
+	// +optional
+	SQLConfigMapKeyRef *corev1.ConfigMapKeySelector `json:"sqlConfigMapKeyRef,omitempty"`
+
+	// Cron schedule (Ex. "0 3 * * *"), the SQL runs once when empty, and again
+	// when sql or sqlConfigMapKeyRef is changed
+	// +optional
+	Schedule string `json:"schedule,omitempty"`
+
+	// Other MariaDBSQLJobs (in the same namespace) that have to succeed before this one runs
+	// +optional
+	DependsOn []corev1.LocalObjectReference `json:"dependsOn,omitempty"`
+
+	// Number of retries before the job is marked as failed
+	// +optional
+	// +kubebuilder:default=3
+	// +kubebuilder:validation:Minimum=0
+	BackoffLimit *int32 `json:"backoffLimit,omitempty"`
+}
+
+type SQLJobPhase string
+
+const (
+	PendingSQLJobPhase   SQLJobPhase = "PENDING"
+	RunningSQLJobPhase   SQLJobPhase = "RUNNING"
+	SucceededSQLJobPhase SQLJobPhase = "SUCCEEDED"
+	FailedSQLJobPhase    SQLJobPhase = "FAILED"
+	ScheduledSQLJobPhase SQLJobPhase = "SCHEDULED"
+)
+
+// MariaDBSQLJobStatus defines the observed state of MariaDBSQLJob
+type MariaDBSQLJobStatus struct {
+	// +optional
+	Phase SQLJobPhase `json:"phase,omitempty"`
+
+	// +optional
+	LastMessage string `json:"lastMessage,omitempty"`
+
+	// Tail of the mariadb client output of the last run
+	// +optional
+	Output string `json:"output,omitempty"`
+
+	// +optional
+	LastScheduleTime *metav1.Time `json:"lastScheduleTime,omitempty"`
+
+	// +optional
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+// +kubebuilder:printcolumn:priority=0,name=MariaDB,type=string,JSONPath=".spec.mariaDBRef.name",description="MariaDB instance the SQL runs against",format=""
+// +kubebuilder:printcolumn:priority=0,name=Phase,type=string,JSONPath=".status.phase",description="Phase of the last run",format=""
+// +kubebuilder:printcolumn:priority=1,name=Schedule,type=string,JSONPath=".spec.schedule",description="Cron schedule of the job",format=""
+// +kubebuilder:printcolumn:priority=0,name=Age, type=date,JSONPath=".metadata.creationTimestamp"
+
+// MariaDBSQLJob is the Schema for the mariadbsqljobs API
+type MariaDBSQLJob struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   MariaDBSQLJobSpec   `json:"spec,omitempty"`
+	Status MariaDBSQLJobStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// MariaDBSQLJobList contains a list of MariaDBSQLJob
+type MariaDBSQLJobList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []MariaDBSQLJob `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&MariaDBSQLJob{}, &MariaDBSQLJobList{})
+}
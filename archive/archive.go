@@ -0,0 +1,192 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package archive writes and reads the artifacts of dump backups. An artifact
+// is a mariadb-dump output, compressed and optionally encrypted, next to a
+// JSON manifest describing how to read it back.
+package archive
+
+import (
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression codecs of the artifacts
+const (
+	CodecNone = "none"
+	CodecGzip = "gzip"
+	CodecZstd = "zstd"
+)
+
+// EncryptionAESGCM is the encryption of the artifacts written with a key
+const EncryptionAESGCM = "aes-256-gcm"
+
+// Manifest is written next to each artifact once it is complete.
+type Manifest struct {
+	// Name of the backup, the artifact and the manifest file names start with it
+	Name string `json:"name"`
+	// Instance that was backed up, as namespace/name
+	Instance string `json:"instance"`
+	// Format of the plaintext, mariadb-dump
+	Format string `json:"format"`
+
+	// File name of the artifact
+	Artifact string `json:"artifact"`
+	Size     int64  `json:"size"`
+	// Checksum of the artifact as stored
+	SHA256 string `json:"sha256"`
+
+	Compression string `json:"compression"`
+	// Empty when the artifact is stored in clear
+	Encryption string `json:"encryption,omitempty"`
+	// Identifies the key the artifact was encrypted with
+	KeyID string `json:"keyID,omitempty"`
+
+	StartTime      time.Time `json:"startTime"`
+	CompletionTime time.Time `json:"completionTime"`
+}
+
+// ManifestFile is the file name of the manifest of a backup.
+func ManifestFile(name string) string {
+	return name + ".json"
+}
+
+// ArtifactFile is the file name of the artifact of a backup.
+func ArtifactFile(name, codec string, encrypted bool) string {
+	file := name + ".sql"
+	switch codec {
+	case CodecGzip:
+		file += ".gz"
+	case CodecZstd:
+		file += ".zst"
+	}
+	if encrypted {
+		file += ".enc"
+	}
+	return file
+}
+
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
+
+func compressor(dst io.Writer, codec string) (io.WriteCloser, error) {
+	switch codec {
+	case CodecNone, "":
+		return nopWriteCloser{dst}, nil
+	case CodecGzip:
+		return gzip.NewWriter(dst), nil
+	case CodecZstd:
+		return zstd.NewWriter(dst)
+	}
+	return nil, fmt.Errorf("unknown compression codec %q", codec)
+}
+
+func decompressor(src io.Reader, codec string) (io.ReadCloser, error) {
+	switch codec {
+	case CodecNone, "":
+		return io.NopCloser(src), nil
+	case CodecGzip:
+		return gzip.NewReader(src)
+	case CodecZstd:
+		d, err := zstd.NewReader(src)
+		if err != nil {
+			return nil, err
+		}
+		return d.IOReadCloser(), nil
+	}
+	return nil, fmt.Errorf("unknown compression codec %q", codec)
+}
+
+// Write compresses src into dst with codec, then encrypts it when key isn't nil.
+func Write(dst io.Writer, src io.Reader, codec string, key []byte) error {
+	var sink io.WriteCloser = nopWriteCloser{dst}
+	if key != nil {
+		var err error
+		if sink, err = NewEncrypter(dst, key); err != nil {
+			return err
+		}
+	}
+	compressed, err := compressor(sink, codec)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(compressed, src); err != nil {
+		return err
+	}
+	if err := compressed.Close(); err != nil {
+		return err
+	}
+	return sink.Close()
+}
+
+// Read writes the plaintext of an artifact read from src into dst.
+func Read(dst io.Writer, src io.Reader, codec string, key []byte) error {
+	if key != nil {
+		var err error
+		if src, err = NewDecrypter(src, key); err != nil {
+			return err
+		}
+	}
+	plain, err := decompressor(src, codec)
+	if err != nil {
+		return err
+	}
+	defer plain.Close()
+	_, err = io.Copy(dst, plain)
+	return err
+}
+
+// ReadManifest reads the manifest of a backup stored in dir.
+func ReadManifest(dir, name string) (Manifest, error) {
+	var manifest Manifest
+	data, err := os.ReadFile(filepath.Join(dir, ManifestFile(name)))
+	if err != nil {
+		return manifest, err
+	}
+	return manifest, json.Unmarshal(data, &manifest)
+}
+
+// Verify checks the size and the checksum of the artifact of a manifest.
+func Verify(dir string, manifest Manifest) error {
+	f, err := os.Open(filepath.Join(dir, manifest.Artifact))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return err
+	}
+	if size != manifest.Size {
+		return fmt.Errorf("%s is %d bytes, the manifest says %d", manifest.Artifact, size, manifest.Size)
+	}
+	if sum := hex.EncodeToString(h.Sum(nil)); sum != manifest.SHA256 {
+		return fmt.Errorf("the checksum of %s is %s, the manifest says %s", manifest.Artifact, sum, manifest.SHA256)
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package archive
+
+import (
+	"bytes"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRoundTrip(t *testing.T) {
+	key := make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	// spans several encryption chunks, and one ending on a chunk boundary
+	dumps := map[string][]byte{
+		"empty":    nil,
+		"small":    []byte("CREATE TABLE t (id INT);\n"),
+		"chunks":   bytes.Repeat([]byte("INSERT INTO t VALUES (1);\n"), 20000),
+		"boundary": bytes.Repeat([]byte{'x'}, 2*chunkSize),
+	}
+
+	for name, dump := range dumps {
+		for _, codec := range []string{CodecNone, CodecGzip, CodecZstd} {
+			for _, k := range [][]byte{nil, key} {
+				var artifact, plain bytes.Buffer
+				if err := Write(&artifact, bytes.NewReader(dump), codec, k); err != nil {
+					t.Fatalf("%s %s: write: %v", name, codec, err)
+				}
+				if err := Read(&plain, bytes.NewReader(artifact.Bytes()), codec, k); err != nil {
+					t.Fatalf("%s %s: read: %v", name, codec, err)
+				}
+				if !bytes.Equal(plain.Bytes(), dump) {
+					t.Errorf("%s %s encrypted=%v: the dump changed", name, codec, k != nil)
+				}
+			}
+		}
+	}
+}
+
+func TestTamperedArtifact(t *testing.T) {
+	key := make([]byte, KeySize)
+	dump := bytes.Repeat([]byte("INSERT INTO t VALUES (1);\n"), 10000)
+	var artifact bytes.Buffer
+	if err := Write(&artifact, bytes.NewReader(dump), CodecNone, key); err != nil {
+		t.Fatal(err)
+	}
+	data := artifact.Bytes()
+
+	flipped := append([]byte{}, data...)
+	flipped[len(flipped)/2] ^= 1
+	truncated := data[:len(encryptionMagic)+prefixSize+4+chunkSize+16]
+	appended := append(append([]byte{}, data...), "DROP DATABASE shop;\n"...)
+	wrongKey := make([]byte, KeySize)
+	wrongKey[0] = 1
+
+	cases := map[string]struct {
+		data []byte
+		key  []byte
+		err  string
+	}{
+		"flipped bit": {flipped, key, "can't be decrypted"},
+		"truncated":   {truncated, key, "truncated"},
+		"appended":    {appended, key, "data follows the final chunk"},
+		"wrong key":   {data, wrongKey, "can't be decrypted"},
+	}
+	for name, c := range cases {
+		err := Read(&bytes.Buffer{}, bytes.NewReader(c.data), CodecNone, c.key)
+		if err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("%s: got %v, want an error containing %q", name, err, c.err)
+		}
+	}
+}
+
+func TestDumpStatus(t *testing.T) {
+	defer func(timeout time.Duration) { dumpStatusTimeout = timeout }(dumpStatusTimeout)
+	dumpStatusTimeout = 100 * time.Millisecond
+
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	cases := map[string]struct {
+		status string
+		err    string
+	}{
+		"no status file": {status: ""},
+		"success":        {status: write("success", "0\n")},
+		"failure":        {status: write("failure", "2\n"), err: "exited with 2"},
+		"missing":        {status: filepath.Join(dir, "missing"), err: "no exit code"},
+		// a partial write is read again, up to the deadline
+		"invalid": {status: write("invalid", "exit"), err: "invalid exit code"},
+	}
+	for name, c := range cases {
+		err := dumpStatus(c.status)
+		if c.err == "" && err != nil || c.err != "" && (err == nil || !strings.Contains(err.Error(), c.err)) {
+			t.Errorf("%s: got %v, want an error containing %q", name, err, c.err)
+		}
+	}
+}
@@ -0,0 +1,279 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package archive
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Main runs the archive and restore commands of the backup Jobs and of the
+// restore init containers, which run the manager image. It returns the exit code.
+func Main(args []string) int {
+	var err error
+	switch args[0] {
+	case "archive":
+		err = archiveCommand(args[1:])
+	case "restore":
+		err = restoreCommand(args[1:])
+	default:
+		err = fmt.Errorf("unknown command %q", args[0])
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+func readKey(file string) ([]byte, error) {
+	if file == "" {
+		return nil, nil
+	}
+	key, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("the key in %s is %d bytes, %d expected", file, len(key), KeySize)
+	}
+	return key, nil
+}
+
+type countingWriter struct {
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
+
+// archiveCommand reads a dump from a FIFO and stores it as an artifact and
+// its manifest, the manifest is only written once the dump succeeded.
+func archiveCommand(args []string) error {
+	fs := flag.NewFlagSet("archive", flag.ContinueOnError)
+	in := fs.String("in", "", "FIFO the dump is read from.")
+	status := fs.String("status", "", "File the exit code of the dump is written to once it is done.")
+	dir := fs.String("dir", "", "Directory the artifact and its manifest are written to.")
+	name := fs.String("name", "", "Name of the backup.")
+	instance := fs.String("instance", "", "Instance backed up, as namespace/name.")
+	codec := fs.String("codec", CodecGzip, "Compression codec: none, gzip or zstd.")
+	keyFile := fs.String("key-file", "", "File holding the AES-256 key, the artifact is stored in clear without it.")
+	keyID := fs.String("key-id", "", "Identifier of the key recorded in the manifest.")
+	keep := fs.Int("keep", 0, "Number of backups of the instance kept in the directory, all when 0.")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	key, err := readKey(*keyFile)
+	if err != nil {
+		return err
+	}
+	manifest := Manifest{
+		Name:        *name,
+		Instance:    *instance,
+		Format:      "mariadb-dump",
+		Artifact:    ArtifactFile(*name, *codec, key != nil),
+		Compression: *codec,
+		StartTime:   time.Now().UTC(),
+	}
+	if key != nil {
+		manifest.Encryption = EncryptionAESGCM
+		manifest.KeyID = *keyID
+	}
+
+	tmp := filepath.Join(*dir, "."+manifest.Artifact+".tmp")
+	if err := writeArtifact(tmp, *in, *status, *codec, key, &manifest); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(*dir, manifest.Artifact)); err != nil {
+		return err
+	}
+
+	manifest.CompletionTime = time.Now().UTC()
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp = filepath.Join(*dir, "."+ManifestFile(*name)+".tmp")
+	if err := os.WriteFile(tmp, append(data, '\n'), 0640); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(*dir, ManifestFile(*name))); err != nil {
+		return err
+	}
+	fmt.Printf("stored %s, %d bytes, sha256 %s\n", manifest.Artifact, manifest.Size, manifest.SHA256)
+
+	if *keep > 0 {
+		return prune(*dir, *instance, *keep)
+	}
+	return nil
+}
+
+func writeArtifact(path, in, status, codec string, key []byte, manifest *Manifest) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0640)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	dump, err := os.Open(in)
+	if err != nil {
+		return err
+	}
+	defer dump.Close()
+
+	h := sha256.New()
+	size := &countingWriter{}
+	if err := Write(io.MultiWriter(f, h, size), dump, codec, key); err != nil {
+		return err
+	}
+	if err := dumpStatus(status); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	manifest.Size = size.n
+	manifest.SHA256 = hex.EncodeToString(h.Sum(nil))
+	return f.Close()
+}
+
+// dumpStatusTimeout bounds the wait for the exit code once the dump output ended
+var dumpStatusTimeout = time.Minute
+
+// dumpStatus waits for the exit code of the dump, the end of the FIFO alone
+// doesn't tell a complete dump from a failed one.
+func dumpStatus(status string) error {
+	if status == "" {
+		return nil
+	}
+	for deadline := time.Now().Add(dumpStatusTimeout); ; time.Sleep(time.Second) {
+		data, err := os.ReadFile(status)
+		if errors.Is(err, os.ErrNotExist) && time.Now().Before(deadline) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("no exit code of the dump: %w", err)
+		}
+		code, err := strconv.Atoi(strings.TrimSpace(string(data)))
+		if err != nil && time.Now().Before(deadline) {
+			// partially written, read it again
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("invalid exit code of the dump %q", data)
+		}
+		if code != 0 {
+			return fmt.Errorf("the dump exited with %d", code)
+		}
+		return nil
+	}
+}
+
+// prune deletes the oldest backups of the instance beyond keep, backup names
+// end with their time so they sort from the oldest.
+func prune(dir, instance string, keep int) error {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return err
+	}
+	var manifests []Manifest
+	for _, file := range files {
+		manifest, err := ReadManifest(dir, strings.TrimSuffix(filepath.Base(file), ".json"))
+		if err != nil || manifest.Instance != instance {
+			continue
+		}
+		manifests = append(manifests, manifest)
+	}
+	sort.Slice(manifests, func(i, j int) bool { return manifests[i].Name < manifests[j].Name })
+
+	for i := 0; i < len(manifests)-keep; i++ {
+		// the manifest goes first, an artifact without one isn't a backup
+		if err := os.Remove(filepath.Join(dir, ManifestFile(manifests[i].Name))); err != nil {
+			return err
+		}
+		if err := os.Remove(filepath.Join(dir, manifests[i].Artifact)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// restoreCommand verifies the checksum of an artifact before writing its
+// plaintext to a file.
+func restoreCommand(args []string) error {
+	fs := flag.NewFlagSet("restore", flag.ContinueOnError)
+	dir := fs.String("dir", "", "Directory of the artifact and its manifest.")
+	name := fs.String("name", "", "Name of the backup.")
+	keyFile := fs.String("key-file", "", "File holding the AES-256 key of an encrypted artifact.")
+	out := fs.String("out", "", "File the plaintext dump is written to.")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	manifest, err := ReadManifest(*dir, *name)
+	if err != nil {
+		return err
+	}
+	if err := Verify(*dir, manifest); err != nil {
+		return err
+	}
+	var key []byte
+	if manifest.Encryption != "" {
+		if manifest.Encryption != EncryptionAESGCM {
+			return fmt.Errorf("unknown encryption %q", manifest.Encryption)
+		}
+		if key, err = readKey(*keyFile); err != nil {
+			return err
+		}
+		if key == nil {
+			return fmt.Errorf("%s is encrypted with the key %q, no key given", manifest.Artifact, manifest.KeyID)
+		}
+	}
+
+	f, err := os.Open(filepath.Join(*dir, manifest.Artifact))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	// read by the entrypoint of the server, which runs as another user
+	dst, err := os.OpenFile(*out, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if err := Read(dst, f, manifest.Compression, key); err != nil {
+		dst.Close()
+		os.Remove(*out)
+		return err
+	}
+	fmt.Printf("restored %s, sha256 %s verified\n", manifest.Artifact, manifest.SHA256)
+	return dst.Close()
+}
@@ -0,0 +1,202 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package archive
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Encrypted artifacts are a header, the magic and a random nonce prefix,
+// followed by chunks sealed with AES-256-GCM. Each chunk is its length and
+// its ciphertext, the nonce is the prefix, the chunk counter and a flag set
+// on the last chunk, so reordered, dropped or truncated chunks fail to open.
+const (
+	encryptionMagic = "MDBAES1\n"
+	chunkSize       = 64 * 1024
+	prefixSize      = 7
+)
+
+// KeySize is the size of the AES-256 keys.
+const KeySize = 32
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("the key is %d bytes, %d expected", len(key), KeySize)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func chunkNonce(prefix []byte, counter uint32, last bool) []byte {
+	nonce := make([]byte, prefixSize+5)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[prefixSize:], counter)
+	if last {
+		nonce[prefixSize+4] = 1
+	}
+	return nonce
+}
+
+type encrypter struct {
+	dst     io.Writer
+	aead    cipher.AEAD
+	prefix  []byte
+	counter uint32
+	buf     []byte
+}
+
+// NewEncrypter returns a writer encrypting into dst with key, the last chunk
+// is only written by Close.
+func NewEncrypter(dst io.Writer, key []byte) (io.WriteCloser, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	prefix := make([]byte, prefixSize)
+	if _, err := rand.Read(prefix); err != nil {
+		return nil, err
+	}
+	if _, err := io.WriteString(dst, encryptionMagic); err != nil {
+		return nil, err
+	}
+	if _, err := dst.Write(prefix); err != nil {
+		return nil, err
+	}
+	return &encrypter{dst: dst, aead: aead, prefix: prefix, buf: make([]byte, 0, chunkSize)}, nil
+}
+
+func (e *encrypter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		// a full chunk is only sealed once more data shows it isn't the last
+		if len(e.buf) == chunkSize {
+			if err := e.seal(false); err != nil {
+				return written, err
+			}
+		}
+		n := copy(e.buf[len(e.buf):chunkSize], p)
+		e.buf = e.buf[:len(e.buf)+n]
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+func (e *encrypter) seal(last bool) error {
+	if e.counter == ^uint32(0) {
+		return errors.New("too many chunks")
+	}
+	sealed := e.aead.Seal(nil, chunkNonce(e.prefix, e.counter, last), e.buf, nil)
+	e.counter++
+	e.buf = e.buf[:0]
+
+	var length [4]byte
+	binary.BigEndian.PutUint32(length[:], uint32(len(sealed)))
+	if _, err := e.dst.Write(length[:]); err != nil {
+		return err
+	}
+	_, err := e.dst.Write(sealed)
+	return err
+}
+
+func (e *encrypter) Close() error {
+	return e.seal(true)
+}
+
+type decrypter struct {
+	src     io.Reader
+	aead    cipher.AEAD
+	prefix  []byte
+	counter uint32
+	plain   []byte
+	done    bool
+}
+
+// NewDecrypter returns a reader of the plaintext of src, encrypted with key.
+func NewDecrypter(src io.Reader, key []byte) (io.Reader, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, len(encryptionMagic)+prefixSize)
+	if _, err := io.ReadFull(src, header); err != nil {
+		return nil, fmt.Errorf("reading the encryption header: %w", err)
+	}
+	if string(header[:len(encryptionMagic)]) != encryptionMagic {
+		return nil, errors.New("not an encrypted artifact")
+	}
+	return &decrypter{src: src, aead: aead, prefix: header[len(encryptionMagic):]}, nil
+}
+
+func (d *decrypter) Read(p []byte) (int, error) {
+	for len(d.plain) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		if err := d.open(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, d.plain)
+	d.plain = d.plain[n:]
+	return n, nil
+}
+
+func (d *decrypter) open() error {
+	var length [4]byte
+	if _, err := io.ReadFull(d.src, length[:]); err != nil {
+		if errors.Is(err, io.EOF) {
+			return errors.New("the artifact is truncated")
+		}
+		return err
+	}
+	size := binary.BigEndian.Uint32(length[:])
+	if size > chunkSize+uint32(d.aead.Overhead()) {
+		return errors.New("invalid chunk length")
+	}
+	sealed := make([]byte, size)
+	if _, err := io.ReadFull(d.src, sealed); err != nil {
+		return fmt.Errorf("the artifact is truncated: %w", err)
+	}
+
+	plain, err := d.aead.Open(nil, chunkNonce(d.prefix, d.counter, false), sealed, nil)
+	if err != nil {
+		if plain, err = d.aead.Open(nil, chunkNonce(d.prefix, d.counter, true), sealed, nil); err != nil {
+			return fmt.Errorf("chunk %d can't be decrypted, wrong key or corrupted artifact", d.counter)
+		}
+		d.done = true
+		// the final chunk ends the artifact, data appended to it isn't authenticated
+		var extra [1]byte
+		if n, err := io.ReadFull(d.src, extra[:]); n > 0 {
+			return errors.New("data follows the final chunk of the artifact")
+		} else if !errors.Is(err, io.EOF) {
+			return err
+		}
+	}
+	d.counter++
+	d.plain = plain
+	return nil
+}
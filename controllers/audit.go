@@ -0,0 +1,122 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	mariak8gv1beta1 "github.com/mariadb/mariadb.org-tools/mariadb-operator/api/v1beta1"
+)
+
+var auditLog = serverLog{sidecar: "audit-log", file: logDir + "/audit.log", rotatedByServer: true}
+
+// auditFilesSize is the most the audit log and its rotations take, with the
+// defaults of server_audit for unset options.
+func auditFilesSize(database mariak8gv1beta1.MariaDB) int64 {
+	size, rotations := int64(1000000), int64(9)
+	if audit := database.Spec.Audit; audit != nil {
+		if audit.FileRotateSize != nil {
+			size = *audit.FileRotateSize
+		}
+		if audit.FileRotations != nil {
+			rotations = int64(*audit.FileRotations)
+		}
+	}
+	return (rotations + 1) * size
+}
+
+func auditEnabled(database mariak8gv1beta1.MariaDB) bool {
+	return database.Spec.Audit != nil && database.Spec.Audit.Enabled
+}
+
+func auditToFile(database mariak8gv1beta1.MariaDB) bool {
+	return auditEnabled(database) && database.Spec.Audit.Output != "syslog"
+}
+
+// auditArgs load the server_audit plugin and translate the spec to its options.
+func auditArgs(database mariak8gv1beta1.MariaDB) []string {
+	if !auditEnabled(database) {
+		return nil
+	}
+	audit := database.Spec.Audit
+
+	args := []string{"--plugin-load-add=server_audit", "--server-audit-logging=ON"}
+	if len(audit.Events) > 0 {
+		events := make([]string, len(audit.Events))
+		for i, event := range audit.Events {
+			events[i] = string(event)
+		}
+		args = append(args, "--server-audit-events="+strings.Join(events, ","))
+	}
+	if len(audit.ExcludedUsers) > 0 {
+		args = append(args, "--server-audit-excl-users="+strings.Join(audit.ExcludedUsers, ","))
+	}
+	if !auditToFile(database) {
+		return append(args, "--server-audit-output-type=syslog")
+	}
+
+	args = append(args, "--server-audit-output-type=file", "--server-audit-file-path="+auditLog.file)
+	if audit.FileRotateSize != nil {
+		args = append(args, fmt.Sprintf("--server-audit-file-rotate-size=%d", *audit.FileRotateSize))
+	}
+	if audit.FileRotations != nil {
+		args = append(args, fmt.Sprintf("--server-audit-file-rotations=%d", *audit.FileRotations))
+	}
+	return args
+}
+
+// auditPluginActive checks with SHOW PLUGINS that server_audit is active on
+// every ready pod of the instance.
+func (r *MariaDBReconciler) auditPluginActive(ctx context.Context, database mariak8gv1beta1.MariaDB) (bool, error) {
+	if !auditEnabled(database) {
+		return false, nil
+	}
+
+	pods, err := r.instancePods(ctx, database)
+	if err != nil {
+		return false, err
+	}
+
+	checked := 0
+	for _, pod := range pods {
+		if !podReady(pod) {
+			continue
+		}
+		out, err := r.execSQL(ctx, pod, "SHOW PLUGINS;")
+		if err != nil {
+			return false, err
+		}
+		if !pluginActive(out, "SERVER_AUDIT") {
+			return false, nil
+		}
+		checked++
+	}
+	return checked > 0, nil
+}
+
+// pluginActive looks for the plugin in the Name, Status, ... rows of SHOW PLUGINS.
+func pluginActive(showPlugins string, name string) bool {
+	for _, line := range strings.Split(showPlugins, "\n") {
+		fields := strings.Split(line, "\t")
+		if len(fields) >= 2 && strings.EqualFold(fields[0], name) {
+			return fields[1] == "ACTIVE"
+		}
+	}
+	return false
+}
@@ -0,0 +1,114 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"reflect"
+	"testing"
+
+	mariak8gv1beta1 "github.com/mariadb/mariadb.org-tools/mariadb-operator/api/v1beta1"
+)
+
+func TestAuditArgs(t *testing.T) {
+	size, rotations := int64(1048576), int32(4)
+	tests := []struct {
+		name      string
+		audit     *mariak8gv1beta1.AuditSpec
+		want      []string
+		wantFiles int64
+	}{
+		{name: "no audit"},
+		{name: "disabled", audit: &mariak8gv1beta1.AuditSpec{Events: []mariak8gv1beta1.AuditEvent{"CONNECT"}}},
+		{
+			name:  "file with the plugin defaults",
+			audit: &mariak8gv1beta1.AuditSpec{Enabled: true},
+			want: []string{
+				"--plugin-load-add=server_audit", "--server-audit-logging=ON",
+				"--server-audit-output-type=file", "--server-audit-file-path=/var/log/mysql/audit.log",
+			},
+			wantFiles: 10 * 1000000,
+		},
+		{
+			name: "file with filters and rotation",
+			audit: &mariak8gv1beta1.AuditSpec{
+				Enabled:        true,
+				Events:         []mariak8gv1beta1.AuditEvent{"CONNECT", "QUERY_DDL"},
+				ExcludedUsers:  []string{"mariadb-exporter", "maxscale"},
+				FileRotateSize: &size,
+				FileRotations:  &rotations,
+			},
+			want: []string{
+				"--plugin-load-add=server_audit", "--server-audit-logging=ON",
+				"--server-audit-events=CONNECT,QUERY_DDL", "--server-audit-excl-users=mariadb-exporter,maxscale",
+				"--server-audit-output-type=file", "--server-audit-file-path=/var/log/mysql/audit.log",
+				"--server-audit-file-rotate-size=1048576", "--server-audit-file-rotations=4",
+			},
+			wantFiles: 5 * 1048576,
+		},
+		{
+			name:  "syslog",
+			audit: &mariak8gv1beta1.AuditSpec{Enabled: true, Output: "syslog", FileRotations: &rotations},
+			want: []string{
+				"--plugin-load-add=server_audit", "--server-audit-logging=ON", "--server-audit-output-type=syslog",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			database := mariak8gv1beta1.MariaDB{Spec: mariak8gv1beta1.MariaDBSpec{Audit: tt.audit}}
+			if got := auditArgs(database); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("auditArgs() = %q, want %q", got, tt.want)
+			}
+
+			// the audit file is streamed by its own sidecar, rotated by the server
+			logs := loggedFiles(database)
+			if (tt.wantFiles > 0) != (len(logs) == 1 && logs[0] == auditLog) {
+				t.Fatalf("logged files %v", logs)
+			}
+			if tt.wantFiles == 0 {
+				return
+			}
+			if got := logVolumeSizeLimit(database).Value(); got != tt.wantFiles {
+				t.Errorf("log volume limited to %d bytes, want %d", got, tt.wantFiles)
+			}
+			if sidecars := logSidecars(database); len(sidecars) != 1 || !sidecars[0].VolumeMounts[0].ReadOnly {
+				t.Errorf("audit sidecars %v", sidecars)
+			}
+		})
+	}
+}
+
+func TestPluginActive(t *testing.T) {
+	showPlugins := "binlog\tACTIVE\tSTORAGE ENGINE\tNULL\tGPL\n" +
+		"SERVER_AUDIT\tACTIVE\tAUDIT\tserver_audit.so\tGPL\n" +
+		"FEEDBACK\tDISABLED\tINFORMATION SCHEMA\tNULL\tGPL\n"
+
+	tests := []struct {
+		plugin string
+		want   bool
+	}{
+		{plugin: "SERVER_AUDIT", want: true},
+		{plugin: "server_audit", want: true},
+		{plugin: "FEEDBACK"},
+		{plugin: "SQL_ERROR_LOG"},
+	}
+	for _, tt := range tests {
+		if got := pluginActive(showPlugins, tt.plugin); got != tt.want {
+			t.Errorf("pluginActive(%q) = %v, want %v", tt.plugin, got, tt.want)
+		}
+	}
+}
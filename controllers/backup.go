@@ -0,0 +1,418 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	mariak8gv1beta1 "github.com/mariadb/mariadb.org-tools/mariadb-operator/api/v1beta1"
+)
+
+const (
+	// backupOfLabel selects the snapshots of the backups of an instance
+	backupOfLabel = "mariadb.org/backup-of"
+	// backupLabel groups the snapshots of the volumes taken by one backup
+	backupLabel = "mariadb.org/backup"
+
+	// backupTimeout bounds how long the server stays quiesced, the lock is
+	// released with the session when it is exceeded. The snapshots are cut
+	// within seconds, their upload isn't waited for under the lock.
+	backupTimeout = 30 * time.Second
+
+	// snapshotPollInterval is how often snapshots are checked until they are
+	// ready to use, they aren't watched as their CRDs are optional
+	snapshotPollInterval = 10 * time.Second
+)
+
+// backupLockCommand is the mariadb client flushing its output after each
+// statement, to read when the lock is held while the session stays open.
+var backupLockCommand = []string{"sh", "-c", `exec mariadb --user=root --password="$MARIADB_ROOT_PASSWORD" --batch --skip-column-names --unbuffered`}
+
+var volumeSnapshotGVK = schema.GroupVersionKind{Group: "snapshot.storage.k8s.io", Version: "v1", Kind: "VolumeSnapshot"}
+
+func backupSchedule(database mariak8gv1beta1.MariaDB) (cron.Schedule, error) {
+	schedule, err := cron.ParseStandard(database.Spec.Backup.Schedule)
+	if err != nil {
+		return nil, invalidSpecError(fmt.Sprintf("invalid backup schedule %q: %v", database.Spec.Backup.Schedule, err))
+	}
+	return schedule, nil
+}
+
+func validateBackup(database mariak8gv1beta1.MariaDB) error {
+	if bootstrap := database.Spec.Bootstrap; bootstrap != nil {
+		sources := 0
+		for _, set := range []bool{bootstrap.CloneFrom != nil, bootstrap.VolumeSnapshot != nil, bootstrap.Backup != nil} {
+			if set {
+				sources++
+			}
+		}
+		if sources > 1 {
+			return invalidSpecError("bootstrap can only set one of cloneFrom, volumeSnapshot and backup")
+		}
+		// the bootstrap only runs once, the data must outlive the first pod
+		if sources > 0 && database.Spec.Storage.Size == "" {
+			return invalidSpecError("bootstrap needs a storage size, the data would be lost with the emptyDir of a pod")
+		}
+	}
+	if err := validateDumpBackup(database); err != nil {
+		return err
+	}
+	if database.Spec.Backup == nil {
+		return nil
+	}
+	if backupMethod(database) == mariak8gv1beta1.BackupSnapshot && database.Spec.Storage.Size == "" {
+		return invalidSpecError("snapshot backups need a storage size, the data of the instance is in an emptyDir")
+	}
+	_, err := backupSchedule(database)
+	return err
+}
+
+func backupRetention(database mariak8gv1beta1.MariaDB) int {
+	if database.Spec.Backup.Retention == 0 {
+		return 7
+	}
+	return int(database.Spec.Backup.Retention)
+}
+
+// volumeSnapshotsInstalled looks the VolumeSnapshot kind up at runtime, the
+// snapshot CRDs are installed with the CSI snapshotter, not with Kubernetes.
+func (r *MariaDBReconciler) volumeSnapshotsInstalled() (bool, error) {
+	_, err := r.RESTMapper().RESTMapping(volumeSnapshotGVK.GroupKind(), volumeSnapshotGVK.Version)
+	if meta.IsNoMatchError(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// reconcileBackup takes the scheduled backup when it is due and returns the
+// wait until the next one. A failed backup is reported, not retried before
+// the next scheduled time, the returned error is about expired backups.
+// Dump backups run in a Job, their result is recorded once it finishes.
+// Snapshot backups are recorded once their snapshots are ready to use,
+// polled until then.
+func (r *MariaDBReconciler) reconcileBackup(ctx context.Context, database *mariak8gv1beta1.MariaDB) (time.Duration, error) {
+	if database.Spec.Backup == nil {
+		return 0, nil
+	}
+	// validated before the reconcile gets here
+	schedule, _ := backupSchedule(*database)
+	if database.Status.Backup == nil {
+		database.Status.Backup = &mariak8gv1beta1.BackupStatus{}
+	}
+	status := database.Status.Backup
+	snapshots := backupMethod(*database) == mariak8gv1beta1.BackupSnapshot
+	if status.InProgress != "" {
+		reconcileInProgress := r.reconcileDumpJob
+		if snapshots {
+			reconcileInProgress = r.reconcileSnapshots
+		}
+		if err := reconcileInProgress(ctx, database); err != nil {
+			return 0, err
+		}
+	}
+	requeue := func(wait time.Duration) time.Duration {
+		if snapshots && status.InProgress != "" && wait > snapshotPollInterval {
+			return snapshotPollInterval
+		}
+		return wait
+	}
+
+	last := database.CreationTimestamp.Time
+	if status.LastScheduleTime != nil {
+		last = status.LastScheduleTime.Time
+	}
+	now := time.Now()
+	if next := schedule.Next(last); now.Before(next) {
+		return requeue(next.Sub(now)), nil
+	}
+
+	status.LastScheduleTime = &metav1.Time{Time: now}
+	name := fmt.Sprintf("%s-%s", database.Name, now.UTC().Format("20060102150405"))
+	wait := schedule.Next(now).Sub(now)
+	if status.InProgress != "" {
+		r.recordBackupResult(database, name, metav1.Time{Time: now}, fmt.Errorf("backup %s is still running", status.InProgress))
+		return requeue(wait), nil
+	}
+
+	start := r.startDumpJob
+	if snapshots {
+		start = r.snapshotBackup
+	}
+	if err := start(ctx, *database, name); err != nil {
+		r.recordBackupResult(database, name, metav1.Time{Time: now}, err)
+		return wait, nil
+	}
+	status.InProgress = name
+	return requeue(wait), nil
+}
+
+// recordBackupResult reports a backup in the status, the metrics and the events.
+func (r *MariaDBReconciler) recordBackupResult(database *mariak8gv1beta1.MariaDB, name string, completion metav1.Time, err error) {
+	status := database.Status.Backup
+	if err != nil {
+		status.LastError = err.Error()
+		backupResults.WithLabelValues(database.Namespace, database.Name, "failure").Inc()
+		r.Recorder.Eventf(database, corev1.EventTypeWarning, EventReasonBackupFailed, "backup %s failed: %v", name, err)
+		return
+	}
+
+	status.LastBackup = name
+	status.LastError = ""
+	database.Status.LastBackupTime = &completion
+	backupResults.WithLabelValues(database.Namespace, database.Name, "success").Inc()
+	lastSuccessfulBackup.WithLabelValues(database.Namespace, database.Name).Set(float64(completion.Unix()))
+	r.Recorder.Eventf(database, corev1.EventTypeNormal, EventReasonBackupSucceeded, "took backup %s", name)
+}
+
+// snapshotBackup quiesces the server, takes a VolumeSnapshot of every data
+// volume of the instance and releases the server once the snapshots are cut.
+// It doesn't wait for them to be ready to use, reconcileSnapshots does.
+func (r *MariaDBReconciler) snapshotBackup(ctx context.Context, database mariak8gv1beta1.MariaDB, name string) error {
+	installed, err := r.volumeSnapshotsInstalled()
+	if err != nil {
+		return err
+	}
+	if !installed {
+		return fmt.Errorf("the VolumeSnapshot CRDs (%s) are not installed", volumeSnapshotGVK.GroupVersion())
+	}
+
+	pods, err := r.instancePods(ctx, database)
+	if err != nil {
+		return err
+	}
+	pod := oldestReadyPod(pods)
+	if pod == nil {
+		return errors.New("no ready pod to quiesce")
+	}
+	var pvcs corev1.PersistentVolumeClaimList
+	if err := r.List(ctx, &pvcs, client.InNamespace(database.Namespace), client.MatchingLabels{"mariadb": database.Name}); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, backupTimeout)
+	defer cancel()
+	release, err := r.lockForBackup(ctx, database, *pod)
+	if err != nil {
+		return err
+	}
+
+	var snapshots []*unstructured.Unstructured
+	for _, pvc := range pvcs.Items {
+		snapshot := desiredVolumeSnapshot(database, pvc.Name, name)
+		if err = r.Create(ctx, snapshot); err != nil {
+			break
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+	if err == nil {
+		err = r.waitForSnapshotsCut(ctx, snapshots)
+	}
+	if releaseErr := release(); err == nil {
+		err = releaseErr
+	}
+
+	// snapshots taken without the lock held all along aren't consistent
+	if err != nil {
+		for _, snapshot := range snapshots {
+			_ = r.Delete(context.Background(), snapshot)
+		}
+	}
+	return err
+}
+
+// lockForBackup blocks commits and DDL in a session of the server, kept open
+// over the exec stdin until the returned release function ends it. Servers
+// older than 10.4 don't have BACKUP STAGE and are locked with FLUSH TABLES
+// WITH READ LOCK.
+func (r *MariaDBReconciler) lockForBackup(ctx context.Context, database mariak8gv1beta1.MariaDB, pod corev1.Pod) (func() error, error) {
+	lock := "BACKUP STAGE START;\nBACKUP STAGE FLUSH;\nBACKUP STAGE BLOCK_DDL;\nBACKUP STAGE BLOCK_COMMIT;\n"
+	unlock := "BACKUP STAGE END;\n"
+	if major, minor, ok := majorVersion(mariadbVersion(database)); ok && (major < 10 || (major == 10 && minor < 4)) {
+		lock = "FLUSH TABLES WITH READ LOCK;\n"
+		unlock = "UNLOCK TABLES;\n"
+	}
+
+	stdin, stdinWriter := io.Pipe()
+	stdoutReader, stdout := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		err := streamInPod(ctx, r.Config, pod, "mariadb", backupLockCommand, stdin, stdout)
+		stdin.CloseWithError(err)
+		stdout.CloseWithError(err)
+		done <- err
+	}()
+
+	go func() {
+		_, _ = io.WriteString(stdinWriter, lock+"SELECT 'locked';\n")
+	}()
+	scanner := bufio.NewScanner(stdoutReader)
+	for scanner.Scan() {
+		if scanner.Text() == "locked" {
+			break
+		}
+	}
+	if scanner.Err() != nil || scanner.Text() != "locked" {
+		stdinWriter.Close()
+		if err := <-done; err != nil {
+			return nil, err
+		}
+		return nil, errors.New("the session ended before the server was locked")
+	}
+	go func() {
+		_, _ = io.Copy(io.Discard, stdoutReader)
+	}()
+
+	return func() error {
+		_, _ = io.WriteString(stdinWriter, unlock)
+		stdinWriter.Close()
+		return <-done
+	}, nil
+}
+
+func desiredVolumeSnapshot(database mariak8gv1beta1.MariaDB, pvc, backup string) *unstructured.Unstructured {
+	snapshot := &unstructured.Unstructured{}
+	snapshot.SetGroupVersionKind(volumeSnapshotGVK)
+	snapshot.SetName(pvc + backup[len(database.Name):])
+	snapshot.SetNamespace(database.Namespace)
+	snapshot.SetLabels(map[string]string{backupOfLabel: database.Name, backupLabel: backup})
+
+	spec := map[string]interface{}{
+		"source": map[string]interface{}{"persistentVolumeClaimName": pvc},
+	}
+	if class := database.Spec.Backup.VolumeSnapshotClassName; class != nil {
+		spec["volumeSnapshotClassName"] = *class
+	}
+	snapshot.Object["spec"] = spec
+	return snapshot
+}
+
+// waitForSnapshotsCut returns once every snapshot has a creation time, the
+// point in time it captures, which may be well before it is ready to use.
+func (r *MariaDBReconciler) waitForSnapshotsCut(ctx context.Context, snapshots []*unstructured.Unstructured) error {
+	return wait.PollImmediateUntil(2*time.Second, func() (bool, error) {
+		for _, snapshot := range snapshots {
+			if err := r.Get(ctx, client.ObjectKeyFromObject(snapshot), snapshot); err != nil {
+				return false, err
+			}
+			if message, found, _ := unstructured.NestedString(snapshot.Object, "status", "error", "message"); found {
+				return false, fmt.Errorf("snapshot %s failed: %s", snapshot.GetName(), message)
+			}
+			if _, found, _ := unstructured.NestedString(snapshot.Object, "status", "creationTime"); !found {
+				return false, nil
+			}
+		}
+		return true, nil
+	}, ctx.Done())
+}
+
+// reconcileSnapshots records the backup in progress once all its snapshots
+// are ready to use. A backup with a failed snapshot is recorded as failed
+// and its snapshots are deleted, a partial backup can't be restored.
+func (r *MariaDBReconciler) reconcileSnapshots(ctx context.Context, database *mariak8gv1beta1.MariaDB) error {
+	status := database.Status.Backup
+	name := status.InProgress
+
+	var snapshots unstructured.UnstructuredList
+	snapshots.SetGroupVersionKind(volumeSnapshotGVK.GroupVersion().WithKind("VolumeSnapshotList"))
+	if err := r.List(ctx, &snapshots, client.InNamespace(database.Namespace), client.MatchingLabels{backupOfLabel: database.Name, backupLabel: name}); err != nil {
+		return err
+	}
+	if len(snapshots.Items) == 0 {
+		status.InProgress = ""
+		r.recordBackupResult(database, name, metav1.Now(), errors.New("its snapshots were deleted"))
+		return nil
+	}
+
+	ready := true
+	for _, snapshot := range snapshots.Items {
+		if message, found, _ := unstructured.NestedString(snapshot.Object, "status", "error", "message"); found {
+			status.InProgress = ""
+			r.recordBackupResult(database, name, metav1.Now(), fmt.Errorf("snapshot %s failed: %s", snapshot.GetName(), message))
+			for i := range snapshots.Items {
+				if err := r.Delete(ctx, &snapshots.Items[i]); ignoreNotFound(err) != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		readyToUse, _, _ := unstructured.NestedBool(snapshot.Object, "status", "readyToUse")
+		ready = ready && readyToUse
+	}
+	if !ready {
+		return nil
+	}
+
+	status.InProgress = ""
+	r.recordBackupResult(database, name, metav1.Now(), nil)
+	return r.pruneSnapshots(ctx, *database)
+}
+
+// pruneSnapshots deletes the snapshots of the backups beyond the retention,
+// backup names end with their time so they sort from the oldest.
+func (r *MariaDBReconciler) pruneSnapshots(ctx context.Context, database mariak8gv1beta1.MariaDB) error {
+	var snapshots unstructured.UnstructuredList
+	snapshots.SetGroupVersionKind(volumeSnapshotGVK.GroupVersion().WithKind("VolumeSnapshotList"))
+	if err := r.List(ctx, &snapshots, client.InNamespace(database.Namespace), client.MatchingLabels{backupOfLabel: database.Name}); err != nil {
+		return err
+	}
+
+	var backups []string
+	byBackup := map[string][]unstructured.Unstructured{}
+	for _, snapshot := range snapshots.Items {
+		backup := snapshot.GetLabels()[backupLabel]
+		if _, ok := byBackup[backup]; !ok {
+			backups = append(backups, backup)
+		}
+		byBackup[backup] = append(byBackup[backup], snapshot)
+	}
+	sort.Strings(backups)
+
+	for i := 0; i < len(backups)-backupRetention(database); i++ {
+		for j := range byBackup[backups[i]] {
+			if err := r.Delete(ctx, &byBackup[backups[i]][j]); ignoreNotFound(err) != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// volumeSnapshotDataSource provisions a data volume from the snapshot the
+// instance is bootstrapped from, nil when there is none.
+func volumeSnapshotDataSource(database mariak8gv1beta1.MariaDB) *corev1.TypedLocalObjectReference {
+	bootstrap := database.Spec.Bootstrap
+	if bootstrap == nil || bootstrap.VolumeSnapshot == nil {
+		return nil
+	}
+	group := volumeSnapshotGVK.Group
+	return &corev1.TypedLocalObjectReference{APIGroup: &group, Kind: volumeSnapshotGVK.Kind, Name: bootstrap.VolumeSnapshot.Name}
+}
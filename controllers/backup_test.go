@@ -0,0 +1,303 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/envtest"
+
+	mariak8gv1beta1 "github.com/mariadb/mariadb.org-tools/mariadb-operator/api/v1beta1"
+)
+
+var _ = Describe("Snapshot backups", func() {
+	database := mariak8gv1beta1.MariaDB{
+		ObjectMeta: metav1.ObjectMeta{Name: "snapshots", Namespace: "default"},
+		Spec: mariak8gv1beta1.MariaDBSpec{
+			Storage: mariak8gv1beta1.StorageSpec{Size: "1Gi"},
+			Backup:  &mariak8gv1beta1.BackupSpec{Schedule: "0 3 * * *"},
+		},
+	}
+
+	It("detects the VolumeSnapshot CRDs once they are installed", func() {
+		r := &MariaDBReconciler{Client: k8sClient, Scheme: scheme.Scheme}
+
+		installed, err := r.volumeSnapshotsInstalled()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(installed).To(BeFalse())
+
+		_, err = envtest.InstallCRDs(cfg, envtest.CRDInstallOptions{
+			Paths: []string{filepath.Join("testdata", "crds")},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Eventually(r.volumeSnapshotsInstalled, 10*time.Second, time.Second).Should(BeTrue())
+
+		snapshot := desiredVolumeSnapshot(database, dataVolumeClaimName(database), "snapshots-20211019030000")
+		Expect(k8sClient.Create(context.Background(), snapshot)).To(Succeed())
+
+		var snapshots unstructured.UnstructuredList
+		snapshots.SetGroupVersionKind(volumeSnapshotGVK.GroupVersion().WithKind("VolumeSnapshotList"))
+		Expect(k8sClient.List(context.Background(), &snapshots, client.InNamespace("default"),
+			client.MatchingLabels{backupOfLabel: database.Name})).To(Succeed())
+		Expect(snapshots.Items).To(HaveLen(1))
+		claim, _, _ := unstructured.NestedString(snapshots.Items[0].Object, "spec", "source", "persistentVolumeClaimName")
+		Expect(claim).To(Equal(dataVolumeClaimName(database)))
+		Expect(snapshots.Items[0].GetLabels()).To(HaveKeyWithValue(backupLabel, "snapshots-20211019030000"))
+	})
+})
+
+func TestRestoreVolumeSnapshot(t *testing.T) {
+	existing := &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: "restored-data", Namespace: "default", Labels: map[string]string{"mariadb": "restored"}},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			Resources: corev1.ResourceRequirements{Requests: corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("1Gi")}},
+		},
+	}
+	tests := []struct {
+		name         string
+		bootstrap    *mariak8gv1beta1.BootstrapSpec
+		existing     *corev1.PersistentVolumeClaim
+		wantSnapshot string
+	}{
+		{name: "no bootstrap"},
+		{
+			name:         "restored",
+			bootstrap:    &mariak8gv1beta1.BootstrapSpec{VolumeSnapshot: &corev1.LocalObjectReference{Name: "snapshots-data-20211019030000"}},
+			wantSnapshot: "snapshots-data-20211019030000",
+		},
+		{
+			// the data source only provisions a new claim, the restore isn't repeated
+			name:      "claim already provisioned",
+			bootstrap: &mariak8gv1beta1.BootstrapSpec{VolumeSnapshot: &corev1.LocalObjectReference{Name: "snapshots-data-20211019030000"}},
+			existing:  existing,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			builder := fake.NewClientBuilder().WithScheme(testScheme(t))
+			if tt.existing != nil {
+				builder = builder.WithObjects(tt.existing.DeepCopy())
+			}
+			c := builder.Build()
+			r := &MariaDBReconciler{Client: c, Scheme: testScheme(t)}
+			restored := mariak8gv1beta1.MariaDB{
+				ObjectMeta: metav1.ObjectMeta{Name: "restored", Namespace: "default"},
+				Spec: mariak8gv1beta1.MariaDBSpec{
+					Storage:   mariak8gv1beta1.StorageSpec{Size: "1Gi"},
+					Bootstrap: tt.bootstrap,
+				},
+			}
+			if err := validateBackup(restored); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := r.reconcileStorage(context.Background(), &restored); err != nil {
+				t.Fatal(err)
+			}
+
+			var pvc corev1.PersistentVolumeClaim
+			if err := c.Get(context.Background(), types.NamespacedName{Namespace: "default", Name: dataVolumeClaimName(restored)}, &pvc); err != nil {
+				t.Fatal(err)
+			}
+			source := pvc.Spec.DataSource
+			if tt.wantSnapshot == "" {
+				if source != nil {
+					t.Errorf("claim provisioned from %v", source)
+				}
+				return
+			}
+			if source == nil || source.Kind != "VolumeSnapshot" || source.APIGroup == nil ||
+				*source.APIGroup != "snapshot.storage.k8s.io" || source.Name != tt.wantSnapshot {
+				t.Errorf("claim provisioned from %+v, want snapshot %s", source, tt.wantSnapshot)
+			}
+		})
+	}
+}
+
+// testVolumeSnapshot is a snapshot of the claim of the shop instance, taken by backup.
+func testVolumeSnapshot(claim, backup string, status map[string]interface{}) *unstructured.Unstructured {
+	database := mariak8gv1beta1.MariaDB{
+		ObjectMeta: metav1.ObjectMeta{Name: "shop", Namespace: "default"},
+		Spec:       mariak8gv1beta1.MariaDBSpec{Backup: &mariak8gv1beta1.BackupSpec{}},
+	}
+	snapshot := desiredVolumeSnapshot(database, claim, backup)
+	if status != nil {
+		snapshot.Object["status"] = status
+	}
+	return snapshot
+}
+
+func TestReconcileSnapshots(t *testing.T) {
+	snapshot := testVolumeSnapshot
+	cut := map[string]interface{}{"creationTime": "2021-10-19T03:00:01Z", "readyToUse": false}
+	ready := map[string]interface{}{"creationTime": "2021-10-19T03:00:01Z", "readyToUse": true}
+	failed := map[string]interface{}{"readyToUse": false, "error": map[string]interface{}{"message": "quota exceeded"}}
+
+	tests := []struct {
+		name           string
+		snapshots      []*unstructured.Unstructured
+		wantInProgress bool
+		wantBackup     string
+		wantError      string
+		wantSnapshots  int
+	}{
+		{
+			name:           "uploading",
+			snapshots:      []*unstructured.Unstructured{snapshot("shop-data", "shop-20211019030000", cut)},
+			wantInProgress: true,
+			wantSnapshots:  1,
+		},
+		{
+			name:          "ready",
+			snapshots:     []*unstructured.Unstructured{snapshot("shop-data", "shop-20211019030000", ready)},
+			wantBackup:    "shop-20211019030000",
+			wantSnapshots: 1,
+		},
+		{
+			name: "ready beyond the retention",
+			snapshots: []*unstructured.Unstructured{
+				snapshot("shop-data", "shop-20211017030000", ready),
+				snapshot("shop-data", "shop-20211018030000", ready),
+				snapshot("shop-data", "shop-20211019030000", ready),
+			},
+			wantBackup:    "shop-20211019030000",
+			wantSnapshots: 2,
+		},
+		{
+			name:      "failed",
+			snapshots: []*unstructured.Unstructured{snapshot("shop-data", "shop-20211019030000", failed)},
+			wantError: "quota exceeded",
+		},
+		{name: "deleted", wantError: "deleted"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			builder := fake.NewClientBuilder().WithScheme(testScheme(t))
+			for _, s := range tt.snapshots {
+				builder = builder.WithObjects(s)
+			}
+			c := builder.Build()
+			r := &MariaDBReconciler{Client: c, Recorder: record.NewFakeRecorder(10)}
+			database := mariak8gv1beta1.MariaDB{
+				ObjectMeta: metav1.ObjectMeta{Name: "shop", Namespace: "default"},
+				Spec:       mariak8gv1beta1.MariaDBSpec{Backup: &mariak8gv1beta1.BackupSpec{Retention: 2}},
+				Status: mariak8gv1beta1.MariaDBStatus{
+					Backup: &mariak8gv1beta1.BackupStatus{InProgress: "shop-20211019030000"},
+				},
+			}
+			defer deleteInstanceMetrics(database.Namespace, database.Name)
+
+			if err := r.reconcileSnapshots(context.Background(), &database); err != nil {
+				t.Fatal(err)
+			}
+			status := database.Status.Backup
+			if (status.InProgress != "") != tt.wantInProgress || status.LastBackup != tt.wantBackup {
+				t.Errorf("backup in progress %q, last backup %q", status.InProgress, status.LastBackup)
+			}
+			if (database.Status.LastBackupTime != nil) != (tt.wantBackup != "") {
+				t.Errorf("last backup time %v", database.Status.LastBackupTime)
+			}
+			if tt.wantError == "" && status.LastError != "" || !strings.Contains(status.LastError, tt.wantError) {
+				t.Errorf("last error %q, want %q", status.LastError, tt.wantError)
+			}
+
+			var left unstructured.UnstructuredList
+			left.SetGroupVersionKind(volumeSnapshotGVK.GroupVersion().WithKind("VolumeSnapshotList"))
+			if err := c.List(context.Background(), &left, client.InNamespace("default")); err != nil {
+				t.Fatal(err)
+			}
+			if len(left.Items) != tt.wantSnapshots {
+				t.Errorf("%d snapshots left, want %d", len(left.Items), tt.wantSnapshots)
+			}
+		})
+	}
+}
+
+// mappedClient has the RESTMapper the fake client lacks.
+type mappedClient struct {
+	client.Client
+	mapper meta.RESTMapper
+}
+
+func (c mappedClient) RESTMapper() meta.RESTMapper {
+	return c.mapper
+}
+
+func TestReconcileBackupInProgress(t *testing.T) {
+	created := metav1.NewTime(time.Now().Add(-48 * time.Hour))
+	tests := []struct {
+		name        string
+		inProgress  string
+		wantError   string
+		wantRequeue time.Duration
+	}{
+		// snapshotBackup fails on the missing CRDs, without blocking the reconcile
+		{name: "due", wantError: "are not installed"},
+		// the snapshots of the backup in progress are polled
+		{name: "due while in progress", inProgress: "shop-20211019030000", wantError: "is still running", wantRequeue: snapshotPollInterval},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			builder := fake.NewClientBuilder().WithScheme(testScheme(t))
+			if tt.inProgress != "" {
+				builder = builder.WithObjects(testVolumeSnapshot("shop-data", tt.inProgress, map[string]interface{}{"readyToUse": false}))
+			}
+			// without the VolumeSnapshot kind
+			c := mappedClient{Client: builder.Build(), mapper: meta.NewDefaultRESTMapper(nil)}
+			r := &MariaDBReconciler{Client: c, Recorder: record.NewFakeRecorder(10)}
+			database := mariak8gv1beta1.MariaDB{
+				ObjectMeta: metav1.ObjectMeta{Name: "shop", Namespace: "default", CreationTimestamp: created},
+				Spec: mariak8gv1beta1.MariaDBSpec{
+					Storage: mariak8gv1beta1.StorageSpec{Size: "1Gi"},
+					Backup:  &mariak8gv1beta1.BackupSpec{Schedule: "@daily"},
+				},
+				Status: mariak8gv1beta1.MariaDBStatus{Backup: &mariak8gv1beta1.BackupStatus{InProgress: tt.inProgress}},
+			}
+			defer deleteInstanceMetrics(database.Namespace, database.Name)
+
+			wait, err := r.reconcileBackup(context.Background(), &database)
+			if err != nil {
+				t.Fatal(err)
+			}
+			status := database.Status.Backup
+			if status.LastScheduleTime == nil || !strings.Contains(status.LastError, tt.wantError) {
+				t.Errorf("scheduled %v, last error %q, want %q", status.LastScheduleTime, status.LastError, tt.wantError)
+			}
+			if status.InProgress != tt.inProgress {
+				t.Errorf("backup in progress %q, want %q", status.InProgress, tt.inProgress)
+			}
+			if tt.wantRequeue > 0 && wait != tt.wantRequeue || tt.wantRequeue == 0 && wait <= snapshotPollInterval {
+				t.Errorf("requeued after %v", wait)
+			}
+		})
+	}
+}
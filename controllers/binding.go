@@ -0,0 +1,79 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	mariak8gv1beta1 "github.com/mariadb/mariadb.org-tools/mariadb-operator/api/v1beta1"
+)
+
+// bindingType is the Service Binding type of the connection Secret. MariaDB
+// speaks the MySQL protocol, and mysql is the type binding libraries know.
+const bindingType = "mysql"
+
+func connectionSecretName(database mariak8gv1beta1.MariaDB) string {
+	return database.Name + "-connection"
+}
+
+// desiredConnectionSecret holds what applications need to connect to the
+// instance, laid out as a Service Binding Secret.
+func (r *MariaDBReconciler) desiredConnectionSecret(database mariak8gv1beta1.MariaDB) (corev1.Secret, error) {
+	host := serviceHost(database)
+	port := strconv.Itoa(int(mariadbPort(database)))
+	credentials := database.Spec.Credentials
+
+	uri := url.URL{
+		Scheme: "mariadb",
+		User:   url.UserPassword(credentials.Username, credentials.Password),
+		Host:   host + ":" + port,
+		Path:   "/" + database.Spec.Database,
+	}
+
+	secret := corev1.Secret{
+		TypeMeta: metav1.TypeMeta{APIVersion: corev1.SchemeGroupVersion.String(), Kind: "Secret"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      connectionSecretName(database),
+			Namespace: database.Namespace,
+			Labels:    map[string]string{"mariadb": database.Name},
+		},
+		Type: corev1.SecretType("servicebinding.io/" + bindingType),
+		Data: map[string][]byte{
+			"type":     []byte(bindingType),
+			"provider": []byte("mariadb"),
+			"host":     []byte(host),
+			"port":     []byte(port),
+			"database": []byte(database.Spec.Database),
+			"username": []byte(credentials.Username),
+			"password": []byte(credentials.Password),
+			"uri":      []byte(uri.String()),
+			"jdbc-url": []byte(fmt.Sprintf("jdbc:mariadb://%s:%s/%s", host, port, database.Spec.Database)),
+			"dsn":      []byte(fmt.Sprintf("%s:%s@tcp(%s:%s)/%s", credentials.Username, credentials.Password, host, port, database.Spec.Database)),
+		},
+	}
+
+	if err := ctrl.SetControllerReference(&database, &secret, r.Scheme); err != nil {
+		return secret, err
+	}
+	return secret, nil
+}
@@ -0,0 +1,411 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	mariak8gv1beta1 "github.com/mariadb/mariadb.org-tools/mariadb-operator/api/v1beta1"
+)
+
+// DefaultCloneSourceNamespaces is the label namespaces need to be cloned
+// from by instances of other namespaces.
+const DefaultCloneSourceNamespaces = "mariadb.org/clone-source=allowed"
+
+const (
+	cloneContainer = "clone"
+	cloneDir       = "/clone"
+	// cloneCredentialsDir holds the address of the source and the temporary
+	// user a logical clone dumps it with
+	cloneCredentialsDir = "/clone-source"
+	// cloneDumpFile sorts before the NN- init scripts, they run on the cloned data
+	cloneDumpFile = "0-clone.sql"
+	// clonePartialMarker is in the data directory while a physical copy is extracted
+	clonePartialMarker = ".clone-partial"
+)
+
+func cloneSpec(database mariak8gv1beta1.MariaDB) *mariak8gv1beta1.CloneSpec {
+	if database.Spec.Bootstrap == nil {
+		return nil
+	}
+	return database.Spec.Bootstrap.CloneFrom
+}
+
+func cloneMethod(clone mariak8gv1beta1.CloneSpec) mariak8gv1beta1.CloneMethod {
+	if clone.Method == "" {
+		return mariak8gv1beta1.CloneLogical
+	}
+	return clone.Method
+}
+
+func cloneCredentialsName(database mariak8gv1beta1.MariaDB) string {
+	return database.Name + "-clone-source"
+}
+
+// cloneUser is the temporary user created on the source for a logical clone,
+// unique to the clone as several instances may clone the same source.
+func cloneUser(database mariak8gv1beta1.MariaDB) string {
+	return "clone-" + string(database.UID)
+}
+
+func initialized(database mariak8gv1beta1.MariaDB) bool {
+	return database.Status.Initialization != nil && database.Status.Initialization.Completed
+}
+
+// cloneSource gets the instance to clone, from the API server as its
+// namespace may not be watched.
+func (r *MariaDBReconciler) cloneSource(ctx context.Context, database mariak8gv1beta1.MariaDB) (*mariak8gv1beta1.MariaDB, error) {
+	clone := cloneSpec(database)
+	namespace := clone.Namespace
+	if namespace == "" {
+		namespace = database.Namespace
+	}
+	if namespace == database.Namespace && clone.Name == database.Name {
+		return nil, invalidSpecError("an instance can't be cloned from itself")
+	}
+	if script := clone.MaskingScript; script != nil {
+		if (script.ConfigMapKeyRef == nil) == (script.SecretKeyRef == nil) {
+			return nil, invalidSpecError("the masking script must set one of configMapKeyRef and secretKeyRef")
+		}
+		if key, _ := initScriptKey(*script); !strings.HasSuffix(key, ".sql") {
+			return nil, invalidSpecError(fmt.Sprintf("masking script %s must end in .sql", key))
+		}
+	}
+
+	if namespace != database.Namespace {
+		selector := r.CloneSourceNamespaces
+		if selector == nil {
+			selector = labels.Nothing()
+		}
+		var ns corev1.Namespace
+		if err := r.APIReader.Get(ctx, types.NamespacedName{Name: namespace}, &ns); err != nil {
+			return nil, err
+		}
+		if !selector.Matches(labels.Set(ns.Labels)) {
+			return nil, invalidSpecError(fmt.Sprintf("namespace %s doesn't allow clones from other namespaces (%s)", namespace, selector.String()))
+		}
+	}
+
+	var source mariak8gv1beta1.MariaDB
+	if err := r.APIReader.Get(ctx, types.NamespacedName{Namespace: namespace, Name: clone.Name}, &source); err != nil {
+		return nil, err
+	}
+
+	// the data files of a physical copy are only readable by the same major version
+	if cloneMethod(*clone) == mariak8gv1beta1.ClonePhysical {
+		from, to := mariadbVersion(source), mariadbVersion(database)
+		fromMajor, fromMinor, okFrom := majorVersion(from)
+		toMajor, toMinor, okTo := majorVersion(to)
+		if okFrom && okTo && (fromMajor != toMajor || fromMinor != toMinor) {
+			return nil, invalidSpecError(fmt.Sprintf("a physical clone of %s can't run %s", from, to))
+		}
+	}
+	return &source, nil
+}
+
+// addCloneContainer fills the data directory before the server starts. A
+// logical clone dumps the source into a script the entrypoint runs, a
+// physical one waits for the operator to stream a backup into the volume.
+// The container only depends on the spec and does nothing once the data
+// directory is initialized, so it stays after the clone without a rollout.
+func addCloneContainer(database mariak8gv1beta1.MariaDB, depl *appsv1.Deployment) {
+	podSpec := &depl.Spec.Template.Spec
+	container := corev1.Container{
+		Name:         cloneContainer,
+		Image:        mariadbImage(database),
+		VolumeMounts: []corev1.VolumeMount{{Name: "data", MountPath: dataDir(database)}},
+	}
+
+	if cloneMethod(*cloneSpec(database)) == mariak8gv1beta1.ClonePhysical {
+		container.Command = []string{"sh", "-c", fmt.Sprintf(
+			`until [ -d %[1]s/mysql ] && [ ! -f %[1]s/%[2]s ]; do sleep 5; done`, dataDir(database), clonePartialMarker)}
+		podSpec.InitContainers = append(podSpec.InitContainers, container)
+		return
+	}
+
+	// the system schemas stay the ones of the clone, with the users of its spec
+	container.Command = []string{"sh", "-c", `set -e
+: > ` + cloneDir + "/" + cloneDumpFile + `
+[ -d ` + dataDir(database) + `/mysql ] && exit 0
+until [ -f ` + cloneCredentialsDir + `/password ]; do sleep 5; done
+host=$(cat ` + cloneCredentialsDir + `/host)
+port=$(cat ` + cloneCredentialsDir + `/port)
+user=$(cat ` + cloneCredentialsDir + `/username)
+export MYSQL_PWD="$(cat ` + cloneCredentialsDir + `/password)"
+databases=$(mariadb --host="$host" --port="$port" --user="$user" --batch --skip-column-names -e 'SHOW DATABASES' |
+  grep -Ev '^(information_schema|performance_schema|mysql|sys)$' || true)
+[ -z "$databases" ] && exit 0
+exec mariadb-dump --host="$host" --port="$port" --user="$user" \
+  --single-transaction --routines --events --triggers --databases $databases > ` + cloneDir + "/" + cloneDumpFile}
+	container.VolumeMounts = append(container.VolumeMounts,
+		corev1.VolumeMount{Name: "clone", MountPath: cloneDir},
+		corev1.VolumeMount{Name: "clone-source", MountPath: cloneCredentialsDir, ReadOnly: true})
+	podSpec.InitContainers = append(podSpec.InitContainers, container)
+	// the Secret is deleted once the clone is complete
+	optional := true
+	podSpec.Volumes = append(podSpec.Volumes,
+		corev1.Volume{Name: "clone", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}},
+		corev1.Volume{Name: "clone-source", VolumeSource: corev1.VolumeSource{
+			Secret: &corev1.SecretVolumeSource{SecretName: cloneCredentialsName(database), Optional: &optional},
+		}})
+	podSpec.Containers[0].VolumeMounts = append(podSpec.Containers[0].VolumeMounts,
+		corev1.VolumeMount{Name: "clone", MountPath: initScriptsDir + "/" + cloneDumpFile, SubPath: cloneDumpFile, ReadOnly: true})
+}
+
+// ensureCloneCredentials creates a temporary user on the source of a logical
+// clone, only allowed to read it, and passes it to the clone init container
+// in a Secret, so the credentials of the source never leave its namespace.
+func (r *MariaDBReconciler) ensureCloneCredentials(ctx context.Context, database, source mariak8gv1beta1.MariaDB) error {
+	if cloneMethod(*cloneSpec(database)) != mariak8gv1beta1.CloneLogical ||
+		(database.Status.Clone != nil && database.Status.Clone.Completed) {
+		return nil
+	}
+	var secret corev1.Secret
+	err := r.Get(ctx, types.NamespacedName{Namespace: database.Namespace, Name: cloneCredentialsName(database)}, &secret)
+	if err == nil || ignoreNotFound(err) != nil {
+		return err
+	}
+
+	pods, err := r.instancePods(ctx, source)
+	if err != nil {
+		return err
+	}
+	pod := oldestReadyPod(pods)
+	if pod == nil {
+		return fmt.Errorf("no ready pod to clone in %s/%s", source.Namespace, source.Name)
+	}
+	password, err := randomPassword()
+	if err != nil {
+		return err
+	}
+	// a password from an earlier attempt whose Secret wasn't created is replaced
+	user := quoteSQL(cloneUser(database)) + "@'%'"
+	sql := fmt.Sprintf(`CREATE USER IF NOT EXISTS %[1]s IDENTIFIED BY %[2]s;
+ALTER USER %[1]s IDENTIFIED BY %[2]s;
+GRANT SELECT, SHOW VIEW, TRIGGER, EVENT, SHOW DATABASES ON *.* TO %[1]s;
+`, user, quoteSQL(password))
+	if _, err := r.execSQL(ctx, *pod, sql); err != nil {
+		return err
+	}
+
+	secret = corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      cloneCredentialsName(database),
+			Namespace: database.Namespace,
+		},
+		StringData: map[string]string{
+			"host":     serviceHost(source),
+			"port":     fmt.Sprint(mariadbPort(source)),
+			"username": cloneUser(database),
+			"password": password,
+		},
+	}
+	if err := ctrl.SetControllerReference(&database, &secret, r.Scheme); err != nil {
+		return err
+	}
+	return r.Create(ctx, &secret)
+}
+
+// dropCloneCredentials drops the temporary user of a logical clone from the
+// source and deletes its Secret.
+func (r *MariaDBReconciler) dropCloneCredentials(ctx context.Context, database, source mariak8gv1beta1.MariaDB) error {
+	pods, err := r.instancePods(ctx, source)
+	if err != nil {
+		return err
+	}
+	pod := oldestReadyPod(pods)
+	if pod == nil {
+		return fmt.Errorf("no ready pod to drop the clone user from in %s/%s", source.Namespace, source.Name)
+	}
+	if _, err := r.execSQL(ctx, *pod, fmt.Sprintf("DROP USER IF EXISTS %s@'%%';\n", quoteSQL(cloneUser(database)))); err != nil {
+		return err
+	}
+	secret := corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: cloneCredentialsName(database), Namespace: database.Namespace}}
+	return ignoreNotFound(r.Delete(ctx, &secret))
+}
+
+// reconcileClone streams the physical copy into a pod waiting for it, then
+// once the server runs on the copied data it resets the credentials of a
+// physical copy to the ones of the spec and runs the masking script. It
+// returns true when the clone is complete, or when there is nothing to clone.
+func (r *MariaDBReconciler) reconcileClone(ctx context.Context, database *mariak8gv1beta1.MariaDB, source *mariak8gv1beta1.MariaDB, rolledOut bool) (bool, error) {
+	clone := cloneSpec(*database)
+	if clone == nil || source == nil {
+		return true, nil
+	}
+	method := cloneMethod(*clone)
+	if database.Status.Clone == nil {
+		database.Status.Clone = &mariak8gv1beta1.CloneStatus{
+			Source: source.Namespace + "/" + source.Name,
+			Method: method,
+		}
+	}
+	status := database.Status.Clone
+	if status.Completed {
+		return true, nil
+	}
+
+	if method == mariak8gv1beta1.ClonePhysical && !status.Streamed {
+		target, err := r.podWaitingForClone(ctx, *database)
+		if err != nil || target == nil {
+			return false, err
+		}
+		sourcePods, err := r.instancePods(ctx, *source)
+		if err != nil {
+			return false, err
+		}
+		sourcePod := oldestReadyPod(sourcePods)
+		if sourcePod == nil {
+			return false, fmt.Errorf("no ready pod to clone in %s", status.Source)
+		}
+		if err := r.streamPhysicalClone(ctx, *source, *sourcePod, *database, *target); err != nil {
+			return false, err
+		}
+		status.Streamed = true
+		return false, nil
+	}
+
+	if !rolledOut {
+		return false, nil
+	}
+	pods, err := r.instancePods(ctx, *database)
+	if err != nil {
+		return false, err
+	}
+	pod := oldestReadyPod(pods)
+	if pod == nil {
+		return false, nil
+	}
+
+	if method == mariak8gv1beta1.ClonePhysical {
+		if err := r.resetClonedCredentials(ctx, *database, *source, *pod); err != nil {
+			return false, err
+		}
+	}
+	if script := clone.MaskingScript; script != nil {
+		sql, err := r.initScriptContent(ctx, database.Namespace, *script)
+		if err != nil {
+			return false, err
+		}
+		if _, err := r.execSQL(ctx, *pod, string(sql)); err != nil {
+			return false, err
+		}
+	}
+	if method == mariak8gv1beta1.CloneLogical {
+		if err := r.dropCloneCredentials(ctx, *database, *source); err != nil {
+			return false, err
+		}
+	}
+
+	now := metav1.Now()
+	status.Completed = true
+	status.CompletionTime = &now
+	r.Recorder.Eventf(database, corev1.EventTypeNormal, EventReasonCloned, "cloned %s with a %s copy", status.Source, method)
+	return true, nil
+}
+
+// podWaitingForClone returns a pod of the instance running the clone init
+// container, the instance pods that are running aren't.
+func (r *MariaDBReconciler) podWaitingForClone(ctx context.Context, database mariak8gv1beta1.MariaDB) (*corev1.Pod, error) {
+	var pods corev1.PodList
+	if err := r.List(ctx, &pods, client.InNamespace(database.Namespace), client.MatchingLabels{"mariadb": database.Name}); err != nil {
+		return nil, err
+	}
+	for i := range pods.Items {
+		for _, status := range pods.Items[i].Status.InitContainerStatuses {
+			if status.Name == cloneContainer && status.State.Running != nil {
+				return &pods.Items[i], nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+// streamPhysicalClone pipes a mariadb-backup stream of the source server into
+// the clone init container, which extracts and prepares it in the data volume.
+// The copy blocks the reconcile for as long as it takes.
+func (r *MariaDBReconciler) streamPhysicalClone(ctx context.Context, source mariak8gv1beta1.MariaDB, sourcePod corev1.Pod, database mariak8gv1beta1.MariaDB, target corev1.Pod) error {
+	backup := []string{"sh", "-c", fmt.Sprintf(
+		`exec mariadb-backup --backup --stream=xbstream --user=root --password="$MARIADB_ROOT_PASSWORD" --datadir=%s --target-dir=/tmp`,
+		dataDir(source))}
+	// a failed copy is started over from an empty directory
+	extract := []string{"sh", "-c", fmt.Sprintf(`set -e
+cd %s
+find . -mindepth 1 -delete
+touch %[2]s
+mbstream -x
+mariadb-backup --prepare --target-dir=.
+rm %[2]s`, dataDir(database), clonePartialMarker)}
+
+	reader, writer := io.Pipe()
+	backupErr := make(chan error, 1)
+	go func() {
+		err := streamInPod(ctx, r.Config, sourcePod, "mariadb", backup, nil, writer)
+		writer.CloseWithError(err)
+		backupErr <- err
+	}()
+
+	err := streamInPod(ctx, r.Config, target, cloneContainer, extract, reader, io.Discard)
+	// stops the backup when the extraction failed early
+	reader.CloseWithError(err)
+	if err := <-backupErr; err != nil {
+		return err
+	}
+	return err
+}
+
+// resetClonedCredentials sets the root password and the user of the spec on
+// a physical copy, which came with the users of the source. The password of
+// the source is passed on stdin, it isn't part of the command.
+func (r *MariaDBReconciler) resetClonedCredentials(ctx context.Context, database, source mariak8gv1beta1.MariaDB, pod corev1.Pod) error {
+	// already reset, by an earlier reconcile whose status update failed
+	if _, err := r.execSQL(ctx, pod, "SELECT 1;"); err == nil {
+		return nil
+	}
+
+	credentials := database.Spec.Credentials
+	var sql strings.Builder
+	fmt.Fprintf(&sql, "ALTER USER IF EXISTS 'root'@'%%' IDENTIFIED BY %s;\n", quoteSQL(credentials.RootPassword))
+	fmt.Fprintf(&sql, "ALTER USER IF EXISTS 'root'@'localhost' IDENTIFIED BY %s;\n", quoteSQL(credentials.RootPassword))
+	if database.Spec.Database != "" {
+		fmt.Fprintf(&sql, "CREATE DATABASE IF NOT EXISTS `%s`;\n", strings.ReplaceAll(database.Spec.Database, "`", "``"))
+	}
+	if credentials.Username != "" {
+		user := quoteSQL(credentials.Username) + "@'%'"
+		fmt.Fprintf(&sql, "CREATE USER IF NOT EXISTS %s IDENTIFIED BY %s;\n", user, quoteSQL(credentials.Password))
+		fmt.Fprintf(&sql, "ALTER USER %s IDENTIFIED BY %s;\n", user, quoteSQL(credentials.Password))
+		if database.Spec.Database != "" {
+			fmt.Fprintf(&sql, "GRANT ALL ON `%s`.* TO %s;\n", strings.ReplaceAll(database.Spec.Database, "`", "``"), user)
+		}
+	}
+
+	command := []string{"sh", "-c", `read -r password; MYSQL_PWD="$password" exec mariadb --user=root --batch --skip-column-names`}
+	stdin := strings.NewReader(source.Spec.Credentials.RootPassword + "\n" + sql.String())
+	_, err := execInPod(ctx, r.Config, pod, "mariadb", command, stdin)
+	return err
+}
@@ -0,0 +1,147 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	mariak8gv1beta1 "github.com/mariadb/mariadb.org-tools/mariadb-operator/api/v1beta1"
+)
+
+func TestCloneSource(t *testing.T) {
+	source := func(namespace, version string) *mariak8gv1beta1.MariaDB {
+		return &mariak8gv1beta1.MariaDB{
+			ObjectMeta: metav1.ObjectMeta{Name: "shop", Namespace: namespace},
+			Spec:       mariak8gv1beta1.MariaDBSpec{ImageVersion: version},
+		}
+	}
+	namespace := func(name string, labels map[string]string) *corev1.Namespace {
+		return &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels}}
+	}
+	masking := func(key string) *mariak8gv1beta1.InitScript {
+		return &mariak8gv1beta1.InitScript{ConfigMapKeyRef: &corev1.ConfigMapKeySelector{
+			LocalObjectReference: corev1.LocalObjectReference{Name: "masking"}, Key: key,
+		}}
+	}
+	reader := fake.NewClientBuilder().WithScheme(testScheme(t)).WithObjects(
+		source("default", "10.6"),
+		source("production", "10.6"),
+		source("archive", "10.6"),
+		namespace("production", map[string]string{"mariadb.org/clone-source": "allowed"}),
+		namespace("archive", nil),
+	).Build()
+	r := &MariaDBReconciler{
+		APIReader:             reader,
+		CloneSourceNamespaces: labels.SelectorFromSet(labels.Set{"mariadb.org/clone-source": "allowed"}),
+	}
+
+	tests := []struct {
+		name         string
+		clone        mariak8gv1beta1.CloneSpec
+		version      string
+		wantInvalid  bool
+		wantNotFound bool
+	}{
+		{name: "same namespace", clone: mariak8gv1beta1.CloneSpec{Name: "shop"}},
+		{name: "itself", clone: mariak8gv1beta1.CloneSpec{Name: "staging"}, wantInvalid: true},
+		{name: "itself by namespace", clone: mariak8gv1beta1.CloneSpec{Name: "staging", Namespace: "default"}, wantInvalid: true},
+		{name: "allowed namespace", clone: mariak8gv1beta1.CloneSpec{Name: "shop", Namespace: "production"}},
+		{name: "namespace not allowed", clone: mariak8gv1beta1.CloneSpec{Name: "shop", Namespace: "archive"}, wantInvalid: true},
+		{name: "missing source", clone: mariak8gv1beta1.CloneSpec{Name: "orders"}, wantNotFound: true},
+		{name: "masking script", clone: mariak8gv1beta1.CloneSpec{Name: "shop", MaskingScript: masking("mask.sql")}},
+		{name: "masking script not in SQL", clone: mariak8gv1beta1.CloneSpec{Name: "shop", MaskingScript: masking("mask.sh")}, wantInvalid: true},
+		{name: "masking script without source", clone: mariak8gv1beta1.CloneSpec{Name: "shop", MaskingScript: &mariak8gv1beta1.InitScript{}}, wantInvalid: true},
+		{name: "logical clone to another version", clone: mariak8gv1beta1.CloneSpec{Name: "shop"}, version: "10.11"},
+		{name: "physical clone, same major", clone: mariak8gv1beta1.CloneSpec{Name: "shop", Method: mariak8gv1beta1.ClonePhysical}, version: "10.6.5"},
+		{name: "physical clone to another major", clone: mariak8gv1beta1.CloneSpec{Name: "shop", Method: mariak8gv1beta1.ClonePhysical}, version: "10.11", wantInvalid: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			version := tt.version
+			if version == "" {
+				version = "10.6"
+			}
+			clone := tt.clone
+			database := mariak8gv1beta1.MariaDB{
+				ObjectMeta: metav1.ObjectMeta{Name: "staging", Namespace: "default"},
+				Spec: mariak8gv1beta1.MariaDBSpec{
+					ImageVersion: version,
+					Bootstrap:    &mariak8gv1beta1.BootstrapSpec{CloneFrom: &clone},
+				},
+			}
+
+			got, err := r.cloneSource(context.Background(), database)
+			_, invalid := err.(invalidSpecError)
+			if invalid != tt.wantInvalid {
+				t.Fatalf("cloneSource() error = %v, want invalid spec: %v", err, tt.wantInvalid)
+			}
+			if errors.IsNotFound(err) != tt.wantNotFound {
+				t.Fatalf("cloneSource() error = %v, want not found: %v", err, tt.wantNotFound)
+			}
+			if err == nil && (got.Name != clone.Name || (clone.Namespace != "" && got.Namespace != clone.Namespace)) {
+				t.Errorf("cloneSource() = %s/%s", got.Namespace, got.Name)
+			}
+		})
+	}
+}
+
+func TestAddCloneContainer(t *testing.T) {
+	for _, method := range []mariak8gv1beta1.CloneMethod{mariak8gv1beta1.CloneLogical, mariak8gv1beta1.ClonePhysical} {
+		database := mariak8gv1beta1.MariaDB{
+			ObjectMeta: metav1.ObjectMeta{Name: "staging", Namespace: "default"},
+			Spec: mariak8gv1beta1.MariaDBSpec{
+				Storage:   mariak8gv1beta1.StorageSpec{Size: "1Gi"},
+				Bootstrap: &mariak8gv1beta1.BootstrapSpec{CloneFrom: &mariak8gv1beta1.CloneSpec{Name: "shop", Method: method}},
+			},
+		}
+		if err := validateBackup(database); err != nil {
+			t.Errorf("%s: validateBackup() = %v", method, err)
+		}
+		var depl appsv1.Deployment
+		depl.Spec.Template.Spec.Containers = []corev1.Container{{Name: "mariadb"}}
+		addCloneContainer(database, &depl)
+
+		podSpec := depl.Spec.Template.Spec
+		if len(podSpec.InitContainers) != 1 || podSpec.InitContainers[0].Name != cloneContainer {
+			t.Fatalf("%s: init containers %v", method, podSpec.InitContainers)
+		}
+		// no credentials in the pod template, the pods outlive the temporary Secret
+		if env := podSpec.InitContainers[0].Env; len(env) > 0 {
+			t.Errorf("%s: clone container environment %v", method, env)
+		}
+		for _, volume := range podSpec.Volumes {
+			if secret := volume.Secret; secret != nil && (secret.Optional == nil || !*secret.Optional) {
+				t.Errorf("%s: pods need Secret %s, deleted after the clone", method, secret.SecretName)
+			}
+		}
+	}
+
+	database := mariak8gv1beta1.MariaDB{Spec: mariak8gv1beta1.MariaDBSpec{
+		Bootstrap: &mariak8gv1beta1.BootstrapSpec{CloneFrom: &mariak8gv1beta1.CloneSpec{Name: "shop"}},
+	}}
+	if _, ok := validateBackup(database).(invalidSpecError); !ok {
+		t.Errorf("a clone without storage size is accepted")
+	}
+}
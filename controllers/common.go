@@ -2,12 +2,14 @@ package controllers
 
 import (
 	//"context"
+	"fmt"
 
 	//"github.com/go-logr/logr"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
 	//"k8s.io/apimachinery/pkg/api/errors"
-	//"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/intstr"
 
@@ -15,29 +17,99 @@ import (
 	ctrl "sigs.k8s.io/controller-runtime"
 	//"sigs.k8s.io/controller-runtime/pkg/client"
 
-	mariak8gv1alpha1 "github.com/mariadb/mariadb.org-tools/mariadb-operator/api/v1alpha1"
+	mariak8gv1beta1 "github.com/mariadb/mariadb.org-tools/mariadb-operator/api/v1beta1"
 )
 
-func (r *MariaDBReconciler) desiredDeployment(database mariak8gv1alpha1.MariaDB) (appsv1.Deployment, error) {
+// mariadbImage returns the server image of the instance, also used for client jobs.
+func mariadbImage(database mariak8gv1beta1.MariaDB) string {
 	mariaImage := database.Spec.Image // image can be assigned
 	if mariaImage == "" {
 		mariaImage = "quay.io/mariadb-foundation/mariadb-devel:" + database.Spec.ImageVersion // get the latest image version
 	}
+	return mariaImage
+}
 
-	mariaPort := database.Spec.Port
+func mariadbPort(database mariak8gv1beta1.MariaDB) int32 {
+	mariaPort := database.Spec.Service.Port
 	if mariaPort == 0 {
 		mariaPort = 3306
 	}
+	return mariaPort
+}
+
+func serviceName(database mariak8gv1beta1.MariaDB) string {
+	return database.Name + "-server-service"
+}
+
+// serviceHost is the in-cluster DNS name of the instance service.
+func serviceHost(database mariak8gv1beta1.MariaDB) string {
+	return serviceName(database) + "." + database.Namespace + ".svc"
+}
+
+// dataDir is where the data volume is mounted and the server keeps its data.
+func dataDir(database mariak8gv1beta1.MariaDB) string {
+	if database.Spec.Storage.Path == "" {
+		return "/var/lib/mysql"
+	}
+	return database.Spec.Storage.Path
+}
+
+// serverArgs are the options the server container is started with.
+func serverArgs(database mariak8gv1beta1.MariaDB) []string {
+	args := []string{"--datadir=" + dataDir(database)}
+	args = append(args, loggingArgs(database)...)
+	return append(args, auditArgs(database)...)
+}
+
+func dataVolumeClaimName(database mariak8gv1beta1.MariaDB) string {
+	return database.Name + "-data"
+}
+
+func dataVolume(database mariak8gv1beta1.MariaDB) corev1.Volume {
+	if database.Spec.Storage.Size == "" {
+		return corev1.Volume{Name: "data", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}}
+	}
+	return corev1.Volume{Name: "data", VolumeSource: corev1.VolumeSource{
+		PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: dataVolumeClaimName(database)},
+	}}
+}
+
+func deploymentName(database mariak8gv1beta1.MariaDB) string {
+	return database.Name + "-server-deployment"
+}
+
+// validateReplicas rejects several pods, which the CRD of earlier versions
+// allowed. The servers don't replicate, each pod would serve its own data.
+func validateReplicas(database mariak8gv1beta1.MariaDB) error {
+	if database.Spec.Replicas != nil && *database.Spec.Replicas > 1 {
+		return invalidSpecError(fmt.Sprintf("replicas is %d, an instance runs a single server as its pods don't replicate each other", *database.Spec.Replicas))
+	}
+	return nil
+}
+
+func (r *MariaDBReconciler) desiredDeployment(database mariak8gv1beta1.MariaDB) (appsv1.Deployment, error) {
+	mariaImage := mariadbImage(database)
+	mariaPort := mariadbPort(database)
+	maxUnavailable := intstr.FromInt(1)
+	maxSurge := intstr.FromInt(0)
 
 	// Create the deployment
 	depl := appsv1.Deployment{
 		TypeMeta: metav1.TypeMeta{APIVersion: appsv1.SchemeGroupVersion.String(), Kind: "Deployment"},
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      database.Name + "-server-deployment",
+			Name:      deploymentName(database),
 			Namespace: database.Namespace,
 		},
 		Spec: appsv1.DeploymentSpec{
 			Replicas: database.Spec.Replicas, // won't be nil because defaulting
+			// stop the old pod before starting the new one, both would open the data directory
+			Strategy: appsv1.DeploymentStrategy{
+				Type: appsv1.RollingUpdateDeploymentStrategyType,
+				RollingUpdate: &appsv1.RollingUpdateDeployment{
+					MaxUnavailable: &maxUnavailable,
+					MaxSurge:       &maxSurge,
+				},
+			},
 			Selector: &metav1.LabelSelector{
 				MatchLabels: map[string]string{"mariadb": database.Name},
 			},
@@ -53,22 +125,54 @@ func (r *MariaDBReconciler) desiredDeployment(database mariak8gv1alpha1.MariaDB)
 							Env: []corev1.EnvVar{
 								{Name: "MARIADB_ALLOW_EMPTY_ROOT_PASSWORD", Value: "0"},
 								// root password should be set from secret - test
-								{Name: "MARIADB_ROOT_PASSWORD", Value: database.Spec.Rootpwd},
-								{Name: "MARIADB_USER", Value: database.Spec.Username},
-								{Name: "MARIADB_PASSWORD", Value: database.Spec.Password},
+								{Name: "MARIADB_ROOT_PASSWORD", Value: database.Spec.Credentials.RootPassword},
+								{Name: "MARIADB_USER", Value: database.Spec.Credentials.Username},
+								{Name: "MARIADB_PASSWORD", Value: database.Spec.Credentials.Password},
 								{Name: "MARIADB_DATABASE", Value: database.Spec.Database},
 							},
 							Ports: []corev1.ContainerPort{
 								{ContainerPort: mariaPort, Name: "mariadb-port", Protocol: "TCP"},
 							},
+							Args: serverArgs(database),
+							VolumeMounts: []corev1.VolumeMount{
+								{Name: "data", MountPath: dataDir(database)},
+							},
+							ReadinessProbe: &corev1.Probe{
+								Handler: corev1.Handler{
+									TCPSocket: &corev1.TCPSocketAction{Port: intstr.FromString("mariadb-port")},
+								},
+								InitialDelaySeconds: 5,
+								PeriodSeconds:       10,
+							},
 							//Resources:
 						},
 					},
+					Volumes: []corev1.Volume{dataVolume(database)},
 				},
 			},
 		},
 	}
 
+	if len(database.Spec.InitScripts) > 0 {
+		podSpec := &depl.Spec.Template.Spec
+		volumes, mounts := initScriptVolumes(database)
+		podSpec.Volumes = append(podSpec.Volumes, volumes...)
+		podSpec.Containers[0].VolumeMounts = append(podSpec.Containers[0].VolumeMounts, mounts...)
+	}
+
+	if metricsEnabled(database) {
+		depl.Spec.Template.Spec.Containers = append(depl.Spec.Template.Spec.Containers, exporterContainer(database))
+	}
+
+	if len(loggedFiles(database)) > 0 {
+		podSpec := &depl.Spec.Template.Spec
+		podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{Name: "logs", VolumeSource: corev1.VolumeSource{
+			EmptyDir: &corev1.EmptyDirVolumeSource{SizeLimit: logVolumeSizeLimit(database)},
+		}})
+		podSpec.Containers[0].VolumeMounts = append(podSpec.Containers[0].VolumeMounts, corev1.VolumeMount{Name: "logs", MountPath: logDir})
+		podSpec.Containers = append(podSpec.Containers, logSidecars(database)...)
+	}
+
 	if err := ctrl.SetControllerReference(&database, &depl, r.Scheme); err != nil {
 		return depl, err
 	}
@@ -76,22 +180,27 @@ func (r *MariaDBReconciler) desiredDeployment(database mariak8gv1alpha1.MariaDB)
 	return depl, nil
 }
 
-func (r *MariaDBReconciler) desiredService(database mariak8gv1alpha1.MariaDB) (corev1.Service, error) {
+func (r *MariaDBReconciler) desiredService(database mariak8gv1beta1.MariaDB) (corev1.Service, error) {
 	svc := corev1.Service{
 		TypeMeta: metav1.TypeMeta{APIVersion: corev1.SchemeGroupVersion.String(), Kind: "Service"},
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      database.Name + "-server-service",
+			Name:      serviceName(database),
 			Namespace: database.Namespace,
+			Labels:    map[string]string{"mariadb": database.Name},
 		},
 		Spec: corev1.ServiceSpec{
 			Ports: []corev1.ServicePort{
-				{Name: "mariadb-service", Port: database.Spec.Port, Protocol: "TCP", TargetPort: intstr.FromString("mariadb-service")},
+				{Name: "mariadb-service", Port: mariadbPort(database), Protocol: "TCP", TargetPort: intstr.FromString("mariadb-port")},
 			},
 			Selector: map[string]string{"mariadb": database.Name},
 			Type:     corev1.ServiceTypeClusterIP,
 		},
 	}
 
+	if metricsEnabled(database) {
+		svc.Spec.Ports = append(svc.Spec.Ports, metricsServicePort(database))
+	}
+
 	// always set the controller reference so that we know which object owns this.
 	if err := ctrl.SetControllerReference(&database, &svc, r.Scheme); err != nil {
 		return svc, err
@@ -99,3 +208,141 @@ func (r *MariaDBReconciler) desiredService(database mariak8gv1alpha1.MariaDB) (c
 
 	return svc, nil
 }
+
+func networkPolicyName(database mariak8gv1beta1.MariaDB) string {
+	return database.Name + "-server-networkpolicy"
+}
+
+// clientIngressRules admit the allowed clients on ports, or the whole
+// instance namespace unless denied by default.
+func clientIngressRules(spec mariak8gv1beta1.NetworkPolicySpec, ports []networkingv1.NetworkPolicyPort) []networkingv1.NetworkPolicyIngressRule {
+	switch {
+	case spec.AllowedNamespaces != nil || spec.AllowedPods != nil:
+		return []networkingv1.NetworkPolicyIngressRule{{
+			From:  []networkingv1.NetworkPolicyPeer{{NamespaceSelector: spec.AllowedNamespaces, PodSelector: spec.AllowedPods}},
+			Ports: ports,
+		}}
+	case !spec.DefaultDeny:
+		return []networkingv1.NetworkPolicyIngressRule{{
+			From:  []networkingv1.NetworkPolicyPeer{{PodSelector: &metav1.LabelSelector{}}},
+			Ports: ports,
+		}}
+	}
+	return nil
+}
+
+// clusterPorts are the ports instance members use to talk to each other:
+// SST (4444), Galera replication (4567) and IST (4568).
+var clusterPorts = []int{4444, 4567, 4568}
+
+func (r *MariaDBReconciler) desiredNetworkPolicy(database mariak8gv1beta1.MariaDB) (networkingv1.NetworkPolicy, error) {
+	spec := database.Spec.NetworkPolicy
+	tcp := corev1.ProtocolTCP
+	udp := corev1.ProtocolUDP
+	dbPort := intstr.FromInt(int(mariadbPort(database)))
+	dbPorts := []networkingv1.NetworkPolicyPort{{Protocol: &tcp, Port: &dbPort}}
+	instancePods := &metav1.LabelSelector{MatchLabels: map[string]string{"mariadb": database.Name}}
+
+	// members of the instance reach each other on the database and cluster ports
+	memberPorts := append([]networkingv1.NetworkPolicyPort{}, dbPorts...)
+	for _, p := range clusterPorts {
+		port := intstr.FromInt(p)
+		memberPorts = append(memberPorts, networkingv1.NetworkPolicyPort{Protocol: &tcp, Port: &port})
+	}
+	galeraPort := intstr.FromInt(4567)
+	memberPorts = append(memberPorts, networkingv1.NetworkPolicyPort{Protocol: &udp, Port: &galeraPort})
+
+	rules := []networkingv1.NetworkPolicyIngressRule{
+		{From: []networkingv1.NetworkPolicyPeer{{PodSelector: instancePods}}, Ports: memberPorts},
+	}
+
+	// the operator runs with the kubebuilder manager labels in its own namespace.
+	// Without POD_NAMESPACE it isn't admitted, the labels alone would admit
+	// any pod carrying them in any namespace.
+	if r.OperatorNamespace != "" {
+		operator := networkingv1.NetworkPolicyPeer{
+			PodSelector:       &metav1.LabelSelector{MatchLabels: map[string]string{"control-plane": "controller-manager"}},
+			NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"kubernetes.io/metadata.name": r.OperatorNamespace}},
+		}
+		rules = append(rules, networkingv1.NetworkPolicyIngressRule{From: []networkingv1.NetworkPolicyPeer{operator}, Ports: dbPorts})
+	}
+
+	// the proxy connects to the servers on behalf of its clients
+	if maxscaleEnabled(database) {
+		rules = append(rules, networkingv1.NetworkPolicyIngressRule{
+			From:  []networkingv1.NetworkPolicyPeer{{PodSelector: &metav1.LabelSelector{MatchLabels: maxscaleLabels(database)}}},
+			Ports: dbPorts,
+		})
+	}
+
+	// the backup Jobs dump the databases over the network
+	if dumpBackupsEnabled(database) {
+		rules = append(rules, networkingv1.NetworkPolicyIngressRule{
+			From:  []networkingv1.NetworkPolicyPeer{{PodSelector: &metav1.LabelSelector{MatchLabels: dumpPodLabels(database)}}},
+			Ports: dbPorts,
+		})
+	}
+
+	// Prometheus runs in the monitoring namespace unless told otherwise
+	if metricsEnabled(database) {
+		port := intstr.FromString("metrics")
+		metrics := database.Spec.Metrics
+		scraper := networkingv1.NetworkPolicyPeer{NamespaceSelector: metrics.ScrapeNamespaces, PodSelector: metrics.ScrapePods}
+		if scraper.NamespaceSelector == nil && scraper.PodSelector == nil {
+			scraper.NamespaceSelector = &metav1.LabelSelector{MatchLabels: map[string]string{"kubernetes.io/metadata.name": defaultMonitoringNamespace}}
+		}
+		rules = append(rules, networkingv1.NetworkPolicyIngressRule{
+			From:  []networkingv1.NetworkPolicyPeer{scraper},
+			Ports: []networkingv1.NetworkPolicyPort{{Protocol: &tcp, Port: &port}},
+		})
+	}
+
+	rules = append(rules, clientIngressRules(*spec, dbPorts)...)
+
+	np := networkingv1.NetworkPolicy{
+		TypeMeta: metav1.TypeMeta{APIVersion: networkingv1.SchemeGroupVersion.String(), Kind: "NetworkPolicy"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      networkPolicyName(database),
+			Namespace: database.Namespace,
+		},
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: *instancePods,
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress},
+			Ingress:     rules,
+		},
+	}
+
+	if err := ctrl.SetControllerReference(&database, &np, r.Scheme); err != nil {
+		return np, err
+	}
+
+	return np, nil
+}
+
+func (r *MariaDBReconciler) desiredPersistentVolumeClaim(database mariak8gv1beta1.MariaDB, size resource.Quantity) (corev1.PersistentVolumeClaim, error) {
+	pvc := corev1.PersistentVolumeClaim{
+		TypeMeta: metav1.TypeMeta{APIVersion: corev1.SchemeGroupVersion.String(), Kind: "PersistentVolumeClaim"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      dataVolumeClaimName(database),
+			Namespace: database.Namespace,
+			Labels:    map[string]string{"mariadb": database.Name},
+		},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+			Resources: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceStorage: size},
+			},
+		},
+	}
+
+	pvc.Spec.StorageClassName = database.Spec.Storage.StorageClassName
+	if len(database.Spec.Storage.AccessModes) > 0 {
+		pvc.Spec.AccessModes = database.Spec.Storage.AccessModes
+	}
+
+	if err := ctrl.SetControllerReference(&database, &pvc, r.Scheme); err != nil {
+		return pvc, err
+	}
+
+	return pvc, nil
+}
@@ -0,0 +1,204 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"testing"
+
+	networkingv1 "k8s.io/api/networking/v1"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/diff"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+
+	mariak8gv1alpha1 "github.com/mariadb/mariadb.org-tools/mariadb-operator/api/v1alpha1"
+	mariak8gv1beta1 "github.com/mariadb/mariadb.org-tools/mariadb-operator/api/v1beta1"
+)
+
+// testScheme knows the owned kinds and their MariaDB and MariaDBSQLJob
+// owners, for the owner references set on the desired objects.
+func testScheme(t *testing.T) *runtime.Scheme {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := mariak8gv1alpha1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := mariak8gv1beta1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	return scheme
+}
+
+func TestValidateReplicas(t *testing.T) {
+	replicas := func(n int32) *int32 { return &n }
+	tests := []struct {
+		name     string
+		replicas *int32
+		wantErr  bool
+	}{
+		{name: "default"},
+		{name: "scaled down", replicas: replicas(0)},
+		{name: "single replica", replicas: replicas(1)},
+		{name: "several replicas", replicas: replicas(2), wantErr: true},
+	}
+	for _, tt := range tests {
+		database := mariak8gv1beta1.MariaDB{Spec: mariak8gv1beta1.MariaDBSpec{Replicas: tt.replicas}}
+		err := validateReplicas(database)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: validateReplicas() = %v, want error: %v", tt.name, err, tt.wantErr)
+		}
+		if _, ok := err.(invalidSpecError); err != nil && !ok {
+			t.Errorf("%s: error %v isn't an invalid spec", tt.name, err)
+		}
+	}
+}
+
+func TestDesiredNetworkPolicy(t *testing.T) {
+	allowedNamespaces := &metav1.LabelSelector{MatchLabels: map[string]string{"team": "shop"}}
+	allowedPods := &metav1.LabelSelector{MatchLabels: map[string]string{"app": "shop"}}
+	namespace := networkingv1.NetworkPolicyPeer{PodSelector: &metav1.LabelSelector{}}
+
+	tests := []struct {
+		name  string
+		spec  func(*mariak8gv1beta1.MariaDBSpec)
+		peers []networkingv1.NetworkPolicyPeer
+	}{
+		{
+			name:  "instance namespace by default",
+			spec:  func(spec *mariak8gv1beta1.MariaDBSpec) {},
+			peers: []networkingv1.NetworkPolicyPeer{namespace},
+		},
+		{
+			name:  "default deny",
+			spec:  func(spec *mariak8gv1beta1.MariaDBSpec) { spec.NetworkPolicy.DefaultDeny = true },
+			peers: nil,
+		},
+		{
+			name: "allowed namespaces and pods",
+			spec: func(spec *mariak8gv1beta1.MariaDBSpec) {
+				spec.NetworkPolicy.AllowedNamespaces = allowedNamespaces
+				spec.NetworkPolicy.AllowedPods = allowedPods
+				spec.NetworkPolicy.DefaultDeny = true
+			},
+			peers: []networkingv1.NetworkPolicyPeer{{NamespaceSelector: allowedNamespaces, PodSelector: allowedPods}},
+		},
+		{
+			name:  "MaxScale",
+			spec:  func(spec *mariak8gv1beta1.MariaDBSpec) { spec.MaxScale = &mariak8gv1beta1.MaxScaleSpec{Enabled: true} },
+			peers: []networkingv1.NetworkPolicyPeer{{PodSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"mariadb-maxscale": "shop"}}}, namespace},
+		},
+		{
+			name: "dump backups",
+			spec: func(spec *mariak8gv1beta1.MariaDBSpec) {
+				spec.Backup = &mariak8gv1beta1.BackupSpec{Schedule: "0 3 * * *", Method: mariak8gv1beta1.BackupDump}
+			},
+			peers: []networkingv1.NetworkPolicyPeer{{PodSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"mariadb-backup": "shop"}}}, namespace},
+		},
+		{
+			name: "metrics scraped from the monitoring namespace",
+			spec: func(spec *mariak8gv1beta1.MariaDBSpec) { spec.Metrics = &mariak8gv1beta1.MetricsSpec{Enabled: true} },
+			peers: []networkingv1.NetworkPolicyPeer{
+				{NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"kubernetes.io/metadata.name": "monitoring"}}},
+				namespace,
+			},
+		},
+		{
+			name: "metrics scraped by some pods",
+			spec: func(spec *mariak8gv1beta1.MariaDBSpec) {
+				spec.Metrics = &mariak8gv1beta1.MetricsSpec{Enabled: true, ScrapePods: allowedPods}
+			},
+			peers: []networkingv1.NetworkPolicyPeer{{PodSelector: allowedPods}, namespace},
+		},
+	}
+
+	r := &MariaDBReconciler{Scheme: testScheme(t), OperatorNamespace: "mariadb-system"}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			database := mariak8gv1beta1.MariaDB{
+				ObjectMeta: metav1.ObjectMeta{Name: "shop", Namespace: "default"},
+				Spec:       mariak8gv1beta1.MariaDBSpec{NetworkPolicy: &mariak8gv1beta1.NetworkPolicySpec{Enabled: true}},
+			}
+			tt.spec(&database.Spec)
+
+			np, err := r.desiredNetworkPolicy(database)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := np.Spec.PodSelector.MatchLabels["mariadb"]; got != "shop" {
+				t.Errorf("the policy selects the pods of %q", got)
+			}
+			operator := np.Spec.Ingress[1].From[0].NamespaceSelector.MatchLabels["kubernetes.io/metadata.name"]
+			if operator != "mariadb-system" {
+				t.Errorf("the operator is admitted from namespace %q", operator)
+			}
+
+			// the first rules admit the other members and the operator
+			var peers []networkingv1.NetworkPolicyPeer
+			for _, rule := range np.Spec.Ingress[2:] {
+				peers = append(peers, rule.From...)
+			}
+			if !apiequality.Semantic.DeepEqual(peers, tt.peers) {
+				t.Errorf("unexpected peers:\n%s", diff.ObjectReflectDiff(tt.peers, peers))
+			}
+		})
+	}
+}
+
+func TestDesiredNetworkPolicyOperator(t *testing.T) {
+	operatorPods := map[string]string{"control-plane": "controller-manager"}
+	tests := []struct {
+		name              string
+		operatorNamespace string
+		wantRule          bool
+	}{
+		{name: "operator namespace known", operatorNamespace: "mariadb-system", wantRule: true},
+		// an empty namespace selector would admit the labels in every namespace
+		{name: "operator namespace unknown"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &MariaDBReconciler{Scheme: testScheme(t), OperatorNamespace: tt.operatorNamespace}
+			database := mariak8gv1beta1.MariaDB{
+				ObjectMeta: metav1.ObjectMeta{Name: "shop", Namespace: "default"},
+				Spec:       mariak8gv1beta1.MariaDBSpec{NetworkPolicy: &mariak8gv1beta1.NetworkPolicySpec{Enabled: true, DefaultDeny: true}},
+			}
+			np, err := r.desiredNetworkPolicy(database)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			rules := 0
+			for _, rule := range np.Spec.Ingress {
+				for _, peer := range rule.From {
+					if peer.PodSelector == nil || !apiequality.Semantic.DeepEqual(peer.PodSelector.MatchLabels, operatorPods) {
+						continue
+					}
+					rules++
+					if peer.NamespaceSelector == nil || peer.NamespaceSelector.MatchLabels["kubernetes.io/metadata.name"] != tt.operatorNamespace {
+						t.Errorf("the operator is admitted from namespaces %v", peer.NamespaceSelector)
+					}
+				}
+			}
+			if (rules > 0) != tt.wantRule {
+				t.Errorf("%d rules admit the operator, want one: %v", rules, tt.wantRule)
+			}
+		})
+	}
+}
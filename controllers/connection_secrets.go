@@ -0,0 +1,180 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	mariak8gv1beta1 "github.com/mariadb/mariadb.org-tools/mariadb-operator/api/v1beta1"
+)
+
+const (
+	// connectionSecretsFinalizer deletes the copies of the connection Secret,
+	// which can't be owned by the instance from other namespaces
+	connectionSecretsFinalizer = "mariadb.org/connection-secrets"
+
+	// Labels of the copies, naming the instance they are copied from
+	sourceNamespaceLabel = "mariadb.org/source-namespace"
+	sourceNameLabel      = "mariadb.org/source-name"
+)
+
+// DefaultConnectionSecretNamespaces is the label namespaces need to be
+// allowed to receive copies of connection Secrets
+const DefaultConnectionSecretNamespaces = "mariadb.org/connection-secrets=allowed"
+
+func copiedFrom(database mariak8gv1beta1.MariaDB) client.MatchingLabels {
+	return client.MatchingLabels{sourceNamespaceLabel: database.Namespace, sourceNameLabel: database.Name}
+}
+
+// connectionSecretCopy maps copies back to the instance they are copied from.
+func connectionSecretCopy(obj client.Object) []reconcile.Request {
+	namespace, ok := obj.GetLabels()[sourceNamespaceLabel]
+	if !ok {
+		return nil
+	}
+	return []reconcile.Request{{NamespacedName: types.NamespacedName{Namespace: namespace, Name: obj.GetLabels()[sourceNameLabel]}}}
+}
+
+// watched tells if the manager watches namespace, its cache only sees the
+// copies made in watched namespaces.
+func (r *MariaDBReconciler) watched(namespace string) bool {
+	return len(r.WatchNamespaces) == 0 || containsString(r.WatchNamespaces, namespace)
+}
+
+// reconcileConnectionSecretsFinalizer adds the finalizer to instances with
+// copies and, once the instance is deleted, deletes the copies and removes
+// it. It returns true when the instance is being deleted.
+//
+// A paused instance doesn't get the finalizer, the copies are only made once
+// it is resumed. Its deletion still deletes the copies, else the finalizer
+// would hold it until it is resumed.
+func (r *MariaDBReconciler) reconcileConnectionSecretsFinalizer(ctx context.Context, database *mariak8gv1beta1.MariaDB) (bool, error) {
+	finalizing := !database.DeletionTimestamp.IsZero()
+	if !finalizing && pauseReason(*database) == "" && len(database.Spec.ConnectionSecrets) > 0 &&
+		!controllerutil.ContainsFinalizer(database, connectionSecretsFinalizer) {
+		controllerutil.AddFinalizer(database, connectionSecretsFinalizer)
+		return false, r.Update(ctx, database)
+	}
+	if finalizing && controllerutil.ContainsFinalizer(database, connectionSecretsFinalizer) {
+		if err := r.deleteConnectionSecrets(ctx, *database, nil); err != nil {
+			return true, err
+		}
+		controllerutil.RemoveFinalizer(database, connectionSecretsFinalizer)
+		return true, r.Update(ctx, database)
+	}
+	return finalizing, nil
+}
+
+// reconcileConnectionSecrets keeps a copy of the connection Secret in the
+// listed namespaces allowed to receive it, and deletes the copies no longer
+// listed. Only watched namespaces can receive copies, so that they are all
+// found again for their deletion.
+func (r *MariaDBReconciler) reconcileConnectionSecrets(ctx context.Context, database *mariak8gv1beta1.MariaDB, connection corev1.Secret, opts ...client.PatchOption) error {
+	selector := r.ConnectionSecretNamespaces
+	if selector == nil {
+		selector = labels.Nothing()
+	}
+
+	keep := map[types.NamespacedName]bool{}
+	database.Status.ConnectionSecrets = nil
+	for _, target := range database.Spec.ConnectionSecrets {
+		if !r.watched(target.Namespace) {
+			r.Recorder.Eventf(database, corev1.EventTypeWarning, EventReasonInvalidSpec,
+				"namespace %s isn't watched by the operator, it can't receive connection secrets", target.Namespace)
+			continue
+		}
+		var namespace corev1.Namespace
+		err := r.APIReader.Get(ctx, types.NamespacedName{Name: target.Namespace}, &namespace)
+		if errors.IsForbidden(err) {
+			// a Role can't grant it, namespace only permissions need a ClusterRole for it
+			return invalidSpecError(fmt.Sprintf("connection secrets need the operator to get namespaces, which it isn't allowed to: %v", err))
+		}
+		if err != nil {
+			return err
+		}
+		if !selector.Matches(labels.Set(namespace.Labels)) {
+			r.Recorder.Eventf(database, corev1.EventTypeWarning, EventReasonInvalidSpec,
+				"namespace %s isn't allowed to receive connection secrets (%s)", target.Namespace, selector.String())
+			continue
+		}
+
+		name := target.Name
+		if name == "" {
+			name = connection.Name
+		}
+		secret := corev1.Secret{
+			TypeMeta: connection.TypeMeta,
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: target.Namespace,
+				Labels:    copiedFrom(*database),
+			},
+			Type: connection.Type,
+			Data: connection.Data,
+		}
+		if err := r.Patch(ctx, &secret, client.Apply, opts...); err != nil {
+			return err
+		}
+		keep[types.NamespacedName{Namespace: secret.Namespace, Name: secret.Name}] = true
+		database.Status.ConnectionSecrets = append(database.Status.ConnectionSecrets, fmt.Sprintf("%s/%s", secret.Namespace, secret.Name))
+	}
+
+	if err := r.deleteConnectionSecrets(ctx, *database, keep); err != nil {
+		return err
+	}
+	if len(database.Spec.ConnectionSecrets) == 0 && controllerutil.ContainsFinalizer(database, connectionSecretsFinalizer) {
+		// the status is updated afterwards, so patch the finalizer only
+		patch := client.MergeFrom(database.DeepCopy())
+		controllerutil.RemoveFinalizer(database, connectionSecretsFinalizer)
+		status := database.Status
+		if err := r.Patch(ctx, database, patch); err != nil {
+			return err
+		}
+		database.Status = status
+	}
+	return nil
+}
+
+// deleteConnectionSecrets deletes the copies of the connection Secret, except
+// the ones to keep. The copies are only made in watched namespaces, so the
+// cache lists them all.
+func (r *MariaDBReconciler) deleteConnectionSecrets(ctx context.Context, database mariak8gv1beta1.MariaDB, keep map[types.NamespacedName]bool) error {
+	var copies corev1.SecretList
+	if err := r.List(ctx, &copies, copiedFrom(database)); err != nil {
+		return err
+	}
+	for i := range copies.Items {
+		secret := &copies.Items[i]
+		if keep[types.NamespacedName{Namespace: secret.Namespace, Name: secret.Name}] {
+			continue
+		}
+		if err := r.Delete(ctx, secret); ignoreNotFound(err) != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,246 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	mariak8gv1beta1 "github.com/mariadb/mariadb.org-tools/mariadb-operator/api/v1beta1"
+)
+
+// applyClient creates or updates the objects of server-side apply patches,
+// which the fake client doesn't support.
+type applyClient struct {
+	client.Client
+}
+
+func (c applyClient) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	if patch.Type() != types.ApplyPatchType {
+		return c.Client.Patch(ctx, obj, patch, opts...)
+	}
+	live := obj.DeepCopyObject().(client.Object)
+	err := c.Get(ctx, client.ObjectKeyFromObject(obj), live)
+	if apierrors.IsNotFound(err) {
+		return c.Create(ctx, obj)
+	}
+	if err != nil {
+		return err
+	}
+	obj.SetResourceVersion(live.GetResourceVersion())
+	return c.Update(ctx, obj)
+}
+
+// testSecretCopy is a copy of the connection Secret of the shop instance.
+func testSecretCopy(namespace, name string) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels:    map[string]string{sourceNamespaceLabel: "default", sourceNameLabel: "shop"},
+		},
+	}
+}
+
+func TestConnectionSecretCopy(t *testing.T) {
+	if got := connectionSecretCopy(&corev1.Secret{}); got != nil {
+		t.Errorf("secret mapped to %v", got)
+	}
+	got := connectionSecretCopy(testSecretCopy("billing", "shop-connection"))
+	if len(got) != 1 || got[0].NamespacedName != (types.NamespacedName{Namespace: "default", Name: "shop"}) {
+		t.Errorf("copy mapped to %v", got)
+	}
+}
+
+func TestReconcileConnectionSecrets(t *testing.T) {
+	allowed := map[string]string{"mariadb.org/connection-secrets": "allowed"}
+	namespace := func(name string, labels map[string]string) *corev1.Namespace {
+		return &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels}}
+	}
+	namespaces := []client.Object{
+		namespace("billing", allowed),
+		namespace("reports", allowed),
+		namespace("public", nil),
+	}
+	selector, err := labels.Parse(DefaultConnectionSecretNamespaces)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		targets    []mariak8gv1beta1.ConnectionSecret
+		watched    []string
+		selector   labels.Selector
+		existing   []client.Object
+		want       []string
+		wantEvents int
+	}{
+		{
+			name:     "copies",
+			targets:  []mariak8gv1beta1.ConnectionSecret{{Namespace: "billing"}, {Namespace: "reports", Name: "orders-db"}},
+			selector: selector,
+			want:     []string{"billing/shop-connection", "reports/orders-db"},
+		},
+		{
+			name:       "namespace not allowed",
+			targets:    []mariak8gv1beta1.ConnectionSecret{{Namespace: "billing"}, {Namespace: "public"}},
+			selector:   selector,
+			want:       []string{"billing/shop-connection"},
+			wantEvents: 1,
+		},
+		{
+			name:       "no namespace allowed",
+			targets:    []mariak8gv1beta1.ConnectionSecret{{Namespace: "billing"}},
+			wantEvents: 1,
+		},
+		{
+			name:       "namespace not watched",
+			targets:    []mariak8gv1beta1.ConnectionSecret{{Namespace: "billing"}, {Namespace: "reports"}},
+			watched:    []string{"default", "reports"},
+			selector:   selector,
+			want:       []string{"reports/shop-connection"},
+			wantEvents: 1,
+		},
+		{
+			name:     "copies no longer listed",
+			targets:  []mariak8gv1beta1.ConnectionSecret{{Namespace: "billing"}},
+			selector: selector,
+			existing: []client.Object{testSecretCopy("reports", "shop-connection"), testSecretCopy("billing", "orders-db")},
+			want:     []string{"billing/shop-connection"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := fake.NewClientBuilder().WithScheme(testScheme(t)).WithObjects(append(namespaces, tt.existing...)...).Build()
+			recorder := record.NewFakeRecorder(10)
+			r := &MariaDBReconciler{
+				Client:                     applyClient{c},
+				APIReader:                  c,
+				Recorder:                   recorder,
+				WatchNamespaces:            tt.watched,
+				ConnectionSecretNamespaces: tt.selector,
+			}
+			database := mariak8gv1beta1.MariaDB{
+				ObjectMeta: metav1.ObjectMeta{Name: "shop", Namespace: "default"},
+				Spec:       mariak8gv1beta1.MariaDBSpec{ConnectionSecrets: tt.targets},
+			}
+			connection := corev1.Secret{
+				TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"},
+				ObjectMeta: metav1.ObjectMeta{Name: "shop-connection", Namespace: "default"},
+				Data:       map[string][]byte{"password": []byte("secret")},
+			}
+			if err := r.reconcileConnectionSecrets(context.Background(), &database, connection); err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(database.Status.ConnectionSecrets, tt.want) {
+				t.Errorf("status lists %v, want %v", database.Status.ConnectionSecrets, tt.want)
+			}
+			var copies corev1.SecretList
+			if err := c.List(context.Background(), &copies, copiedFrom(database)); err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, secret := range copies.Items {
+				got = append(got, secret.Namespace+"/"+secret.Name)
+				if string(secret.Data["password"]) != "secret" {
+					t.Errorf("copy %s/%s data %v", secret.Namespace, secret.Name, secret.Data)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("copies %v, want %v", got, tt.want)
+			}
+			if len(recorder.Events) != tt.wantEvents {
+				t.Errorf("%d events, want %d", len(recorder.Events), tt.wantEvents)
+			}
+		})
+	}
+}
+
+func TestReconcileConnectionSecretsFinalizer(t *testing.T) {
+	targets := []mariak8gv1beta1.ConnectionSecret{{Namespace: "billing"}}
+	deleted := metav1.Now()
+
+	tests := []struct {
+		name          string
+		targets       []mariak8gv1beta1.ConnectionSecret
+		paused        bool
+		deleting      bool
+		wantDeleting  bool
+		wantFinalizer bool
+		wantCopies    int
+	}{
+		{name: "no connection secrets", wantCopies: 1},
+		{name: "copies", targets: targets, wantFinalizer: true, wantCopies: 1},
+		// the pause holds every change to the instance, the copies are made once resumed
+		{name: "paused", targets: targets, paused: true, wantCopies: 1},
+		{name: "deleted", targets: targets, deleting: true, wantDeleting: true},
+		{name: "deleted while paused", targets: targets, paused: true, deleting: true, wantDeleting: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			database := &mariak8gv1beta1.MariaDB{
+				ObjectMeta: metav1.ObjectMeta{Name: "shop", Namespace: "default"},
+				Spec:       mariak8gv1beta1.MariaDBSpec{ConnectionSecrets: tt.targets, Paused: tt.paused},
+			}
+			if tt.deleting {
+				database.DeletionTimestamp = &deleted
+				database.Finalizers = []string{connectionSecretsFinalizer}
+			}
+			c := fake.NewClientBuilder().WithScheme(testScheme(t)).WithObjects(database, testSecretCopy("billing", "shop-connection")).Build()
+			r := &MariaDBReconciler{Client: c}
+
+			deleting, err := r.reconcileConnectionSecretsFinalizer(context.Background(), database)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if deleting != tt.wantDeleting {
+				t.Errorf("deleting %v, want %v", deleting, tt.wantDeleting)
+			}
+			// the instance is gone once its last finalizer is removed
+			var got mariak8gv1beta1.MariaDB
+			err = c.Get(context.Background(), types.NamespacedName{Namespace: "default", Name: "shop"}, &got)
+			if tt.deleting && !apierrors.IsNotFound(err) {
+				t.Errorf("deleted instance left with finalizers %v", got.Finalizers)
+			} else if !tt.deleting && err != nil {
+				t.Fatal(err)
+			}
+			if controllerutil.ContainsFinalizer(&got, connectionSecretsFinalizer) != tt.wantFinalizer {
+				t.Errorf("finalizers %v", got.Finalizers)
+			}
+			var copies corev1.SecretList
+			if err := c.List(context.Background(), &copies, copiedFrom(*database)); err != nil {
+				t.Fatal(err)
+			}
+			if len(copies.Items) != tt.wantCopies {
+				t.Errorf("%d copies left, want %d", len(copies.Items), tt.wantCopies)
+			}
+		})
+	}
+}
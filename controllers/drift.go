@@ -0,0 +1,157 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	mariak8gv1beta1 "github.com/mariadb/mariadb.org-tools/mariadb-operator/api/v1beta1"
+)
+
+// appliedHashAnnotation records on owned objects a hash of what was last applied
+const appliedHashAnnotation = "mariadb.org/applied-hash"
+
+// driftCheckedKinds are the kinds applied through applyOwned
+var driftCheckedKinds = []string{"Deployment", "Service"}
+
+// applyOwned applies desired, first comparing it to the live object. When
+// nothing changed in the spec since the last apply, any field of desired with
+// another value in the live object was edited outside of the operator: the
+// edit is logged and recorded, then reverted by the apply or, with the Report
+// drift policy, kept by not applying. In that case desired is set to the live
+// object, like the apply would have.
+func (r *MariaDBReconciler) applyOwned(ctx context.Context, database mariak8gv1beta1.MariaDB, desired client.Object, opts ...client.PatchOption) error {
+	desiredFields, err := runtime.DefaultUnstructuredConverter.ToUnstructured(desired)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(desiredFields)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:8])
+
+	gvk := desired.GetObjectKind().GroupVersionKind()
+	obj, err := r.Scheme.New(gvk)
+	if err != nil {
+		return err
+	}
+	live := obj.(client.Object)
+	err = r.Get(ctx, client.ObjectKeyFromObject(desired), live)
+	if ignoreNotFound(err) != nil {
+		return err
+	}
+
+	annotations := desired.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[appliedHashAnnotation] = hash
+	desired.SetAnnotations(annotations)
+
+	if err == nil && live.GetAnnotations()[appliedHashAnnotation] == hash {
+		liveFields, err := runtime.DefaultUnstructuredConverter.ToUnstructured(live)
+		if err != nil {
+			return err
+		}
+		if drifted := objectDrift(desiredFields, liveFields); len(drifted) > 0 {
+			log := r.Log.WithValues("MariaDB: ", client.ObjectKeyFromObject(&database), "kind", gvk.Kind, "name", desired.GetName())
+			message := fmt.Sprintf("%s %s was edited outside of the operator: %s", gvk.Kind, desired.GetName(), strings.Join(drifted, ", "))
+
+			if database.Spec.DriftPolicy == mariak8gv1beta1.ReportDriftPolicy {
+				log.Info("Drift detected, left in place", "fields", drifted)
+				r.Recorder.Event(&database, corev1.EventTypeWarning, EventReasonDriftDetected, message+", left in place")
+				// hand back the live object, as the apply would have
+				reflect.ValueOf(desired).Elem().Set(reflect.ValueOf(live).Elem())
+				return nil
+			}
+
+			log.Info("Drift detected, reverting", "fields", drifted)
+			r.Recorder.Event(&database, corev1.EventTypeWarning, EventReasonDriftDetected, message+", reverted")
+			driftCorrections.WithLabelValues(database.Namespace, database.Name, gvk.Kind).Inc()
+		}
+	}
+
+	return r.Patch(ctx, desired, client.Apply, opts...)
+}
+
+// objectDrift compares the spec, labels and annotations of the desired and
+// live objects.
+func objectDrift(desired, live map[string]interface{}) []string {
+	desiredMeta, _ := desired["metadata"].(map[string]interface{})
+	liveMeta, _ := live["metadata"].(map[string]interface{})
+
+	var drifted []string
+	drifted = append(drifted, driftedFields("metadata.labels", desiredMeta["labels"], liveMeta["labels"])...)
+	drifted = append(drifted, driftedFields("metadata.annotations", desiredMeta["annotations"], liveMeta["annotations"])...)
+	return append(drifted, driftedFields("spec", desired["spec"], live["spec"])...)
+}
+
+// driftedFields lists the paths of the fields set in desired with another
+// value in live. Fields only set in live, like the ones defaulted by the API
+// server, are ignored, except in lists which must have the same length.
+func driftedFields(path string, desired, live interface{}) []string {
+	switch d := desired.(type) {
+	case nil:
+		return nil
+	case map[string]interface{}:
+		l, ok := live.(map[string]interface{})
+		if !ok {
+			if len(d) == 0 {
+				return nil
+			}
+			return []string{path}
+		}
+		keys := make([]string, 0, len(d))
+		for key := range d {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		var drifted []string
+		for _, key := range keys {
+			drifted = append(drifted, driftedFields(path+"."+key, d[key], l[key])...)
+		}
+		return drifted
+	case []interface{}:
+		l, _ := live.([]interface{})
+		if len(l) != len(d) {
+			return []string{path}
+		}
+		var drifted []string
+		for i := range d {
+			drifted = append(drifted, driftedFields(fmt.Sprintf("%s[%d]", path, i), d[i], l[i])...)
+		}
+		return drifted
+	default:
+		if !reflect.DeepEqual(d, live) {
+			return []string{path}
+		}
+		return nil
+	}
+}
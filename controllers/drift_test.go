@@ -0,0 +1,91 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDriftedFields(t *testing.T) {
+	type object = map[string]interface{}
+	type list = []interface{}
+
+	tests := []struct {
+		name          string
+		desired, live interface{}
+		want          []string
+	}{
+		{
+			name:    "same values",
+			desired: object{"replicas": int64(1), "paused": false},
+			live:    object{"replicas": int64(1), "paused": false},
+		},
+		{
+			name:    "fields only set in live",
+			desired: object{"replicas": int64(1)},
+			live:    object{"replicas": int64(1), "revisionHistoryLimit": int64(10)},
+		},
+		{
+			name:    "fields unset in desired",
+			desired: object{"replicas": int64(1), "paused": nil},
+			live:    object{"replicas": int64(1), "paused": true},
+		},
+		{
+			name:    "changed values, sorted",
+			desired: object{"replicas": int64(1), "minReadySeconds": int64(0)},
+			live:    object{"replicas": int64(3), "minReadySeconds": int64(5)},
+			want:    []string{"spec.minReadySeconds", "spec.replicas"},
+		},
+		{
+			name:    "nested field removed from live",
+			desired: object{"selector": object{"matchLabels": object{"mariadb": "shop"}}},
+			live:    object{"selector": object{}},
+			want:    []string{"spec.selector.matchLabels"},
+		},
+		{
+			name:    "empty object missing from live",
+			desired: object{"securityContext": object{}},
+			live:    object{},
+		},
+		{
+			name:    "list item changed",
+			desired: object{"ports": list{object{"port": int64(3306)}, object{"port": int64(9104)}}},
+			live:    object{"ports": list{object{"port": int64(3306), "protocol": "TCP"}, object{"port": int64(9105)}}},
+			want:    []string{"spec.ports[1].port"},
+		},
+		{
+			name:    "list item added in live",
+			desired: object{"ports": list{object{"port": int64(3306)}}},
+			live:    object{"ports": list{object{"port": int64(3306)}, object{"port": int64(8080)}}},
+			want:    []string{"spec.ports"},
+		},
+		{
+			name:    "type changed",
+			desired: object{"replicas": int64(1)},
+			live:    object{"replicas": "1"},
+			want:    []string{"spec.replicas"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := driftedFields("spec", tt.desired, tt.live); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("driftedFields() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
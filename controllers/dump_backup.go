@@ -0,0 +1,315 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	mariak8gv1beta1 "github.com/mariadb/mariadb.org-tools/mariadb-operator/api/v1beta1"
+	"github.com/mariadb/mariadb.org-tools/mariadb-operator/archive"
+)
+
+const (
+	dumpWorkDir    = "/work"
+	dumpBackupsDir = "/backups"
+	dumpKeyDir     = "/backup-key"
+	// restoreDumpFile sorts before the NN- init scripts, like the dump of a clone
+	restoreDumpFile = "0-restore.sql"
+	// the user of the distroless manager image
+	managerUserID = 65532
+)
+
+func backupMethod(database mariak8gv1beta1.MariaDB) mariak8gv1beta1.BackupMethod {
+	if database.Spec.Backup.Method == "" {
+		return mariak8gv1beta1.BackupSnapshot
+	}
+	return database.Spec.Backup.Method
+}
+
+func dumpBackupsEnabled(database mariak8gv1beta1.MariaDB) bool {
+	return database.Spec.Backup != nil && backupMethod(database) == mariak8gv1beta1.BackupDump
+}
+
+// dumpPodLabels select the pods of the backup Jobs, admitted by the NetworkPolicy.
+func dumpPodLabels(database mariak8gv1beta1.MariaDB) map[string]string {
+	return map[string]string{"mariadb-backup": database.Name}
+}
+
+func backupRestore(database mariak8gv1beta1.MariaDB) *mariak8gv1beta1.BackupRestoreSpec {
+	if database.Spec.Bootstrap == nil {
+		return nil
+	}
+	return database.Spec.Bootstrap.Backup
+}
+
+func keyID(encryption mariak8gv1beta1.BackupEncryptionSpec) string {
+	if encryption.KeyID != "" {
+		return encryption.KeyID
+	}
+	return encryption.SecretKeyRef.Name + "/" + encryption.SecretKeyRef.Key
+}
+
+func keyVolume(encryption mariak8gv1beta1.BackupEncryptionSpec) corev1.Volume {
+	return corev1.Volume{Name: "key", VolumeSource: corev1.VolumeSource{Secret: &corev1.SecretVolumeSource{
+		SecretName: encryption.SecretKeyRef.Name,
+		Items:      []corev1.KeyToPath{{Key: encryption.SecretKeyRef.Key, Path: "key"}},
+	}}}
+}
+
+// startDumpJob runs a Job where mariadb-dump writes into a FIFO read by the
+// manager image, which compresses, encrypts and stores the dump with its
+// manifest, then deletes the backups beyond the retention.
+func (r *MariaDBReconciler) startDumpJob(ctx context.Context, database mariak8gv1beta1.MariaDB, name string) error {
+	if r.BackupImage == "" {
+		return errors.New("the manager image is unknown, dump backups need the --backup-image flag")
+	}
+	job, err := r.desiredDumpJob(database, name)
+	if err != nil {
+		return err
+	}
+	return r.Create(ctx, &job)
+}
+
+func (r *MariaDBReconciler) desiredDumpJob(database mariak8gv1beta1.MariaDB, name string) (batchv1.Job, error) {
+	dump := database.Spec.Backup.Dump
+	codec := dump.Compression
+	if codec == "" {
+		codec = archive.CodecGzip
+	}
+	backoffLimit := int32(0)
+	// kept a day to read the logs of failed backups
+	ttl := int32(24 * 60 * 60)
+	fsGroup := int64(managerUserID)
+
+	// the FIFO is always opened, the archive container waits on it, and the
+	// exit code tells a complete dump from a failed one
+	script := fmt.Sprintf(`exec 3>%[1]s/dump
+list=$(mariadb --host="$SOURCE_HOST" --port="$SOURCE_PORT" --user=root --batch --skip-column-names -e 'SHOW DATABASES')
+code=$?
+if [ $code -eq 0 ]; then
+  databases=$(echo "$list" | grep -Ev '^(information_schema|performance_schema|mysql|sys)$')
+  if [ -n "$databases" ]; then
+    mariadb-dump --host="$SOURCE_HOST" --port="$SOURCE_PORT" --user=root \
+      --single-transaction --routines --events --triggers --databases $databases >&3
+    code=$?
+  fi
+fi
+exec 3>&-
+echo $code > %[1]s/dump.status
+exit $code`, dumpWorkDir)
+
+	args := []string{
+		"archive",
+		"--in=" + dumpWorkDir + "/dump",
+		"--status=" + dumpWorkDir + "/dump.status",
+		"--dir=" + dumpBackupsDir,
+		"--name=" + name,
+		"--instance=" + database.Namespace + "/" + database.Name,
+		"--codec=" + codec,
+		fmt.Sprintf("--keep=%d", backupRetention(database)),
+	}
+	volumes := []corev1.Volume{
+		{Name: "work", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}},
+		{Name: "backups", VolumeSource: corev1.VolumeSource{
+			PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: dump.VolumeClaimName},
+		}},
+	}
+	archiveMounts := []corev1.VolumeMount{
+		{Name: "work", MountPath: dumpWorkDir},
+		{Name: "backups", MountPath: dumpBackupsDir},
+	}
+	if encryption := dump.Encryption; encryption != nil {
+		args = append(args, "--key-file="+dumpKeyDir+"/key", "--key-id="+keyID(*encryption))
+		volumes = append(volumes, keyVolume(*encryption))
+		archiveMounts = append(archiveMounts, corev1.VolumeMount{Name: "key", MountPath: dumpKeyDir, ReadOnly: true})
+	}
+
+	labels := map[string]string{backupOfLabel: database.Name, backupLabel: name}
+	podLabels := dumpPodLabels(database)
+	for k, v := range labels {
+		podLabels[k] = v
+	}
+	job := batchv1.Job{
+		TypeMeta: metav1.TypeMeta{APIVersion: batchv1.SchemeGroupVersion.String(), Kind: "Job"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: database.Namespace,
+			Labels:    labels,
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit:            &backoffLimit,
+			TTLSecondsAfterFinished: &ttl,
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: podLabels},
+				Spec: corev1.PodSpec{
+					RestartPolicy:   corev1.RestartPolicyNever,
+					SecurityContext: &corev1.PodSecurityContext{FSGroup: &fsGroup},
+					InitContainers: []corev1.Container{{
+						Name:         "fifo",
+						Image:        mariadbImage(database),
+						Command:      []string{"mkfifo", dumpWorkDir + "/dump"},
+						VolumeMounts: []corev1.VolumeMount{{Name: "work", MountPath: dumpWorkDir}},
+					}},
+					Containers: []corev1.Container{
+						{
+							Name:    "dump",
+							Image:   mariadbImage(database),
+							Command: []string{"sh", "-c", script},
+							Env: []corev1.EnvVar{
+								{Name: "SOURCE_HOST", Value: serviceHost(database)},
+								{Name: "SOURCE_PORT", Value: fmt.Sprint(mariadbPort(database))},
+								{Name: "MYSQL_PWD", Value: database.Spec.Credentials.RootPassword},
+							},
+							VolumeMounts: []corev1.VolumeMount{{Name: "work", MountPath: dumpWorkDir}},
+						},
+						{
+							Name:         "archive",
+							Image:        r.BackupImage,
+							Command:      []string{"/manager"},
+							Args:         args,
+							VolumeMounts: archiveMounts,
+						},
+					},
+					Volumes: volumes,
+				},
+			},
+		},
+	}
+
+	if err := ctrl.SetControllerReference(&database, &job, r.Scheme); err != nil {
+		return job, err
+	}
+	return job, nil
+}
+
+// reconcileDumpJob records the result of the backup Job in progress once it
+// is finished.
+func (r *MariaDBReconciler) reconcileDumpJob(ctx context.Context, database *mariak8gv1beta1.MariaDB) error {
+	status := database.Status.Backup
+	name := status.InProgress
+
+	var job batchv1.Job
+	key := types.NamespacedName{Namespace: database.Namespace, Name: name}
+	err := r.Get(ctx, key, &job)
+	// the cache may not have the Job created by the last reconcile yet
+	if apierrors.IsNotFound(err) && r.APIReader != nil {
+		err = r.APIReader.Get(ctx, key, &job)
+	}
+	if ignoreNotFound(err) != nil {
+		return err
+	}
+	if err != nil {
+		status.InProgress = ""
+		r.recordBackupResult(database, name, metav1.Now(), errors.New("its Job was deleted"))
+		return nil
+	}
+
+	if job.Status.Succeeded > 0 {
+		status.InProgress = ""
+		completion := metav1.Now()
+		if job.Status.CompletionTime != nil {
+			completion = *job.Status.CompletionTime
+		}
+		r.recordBackupResult(database, name, completion, nil)
+		return nil
+	}
+	for _, c := range job.Status.Conditions {
+		if c.Type == batchv1.JobFailed && c.Status == corev1.ConditionTrue {
+			status.InProgress = ""
+			r.recordBackupResult(database, name, metav1.Now(), fmt.Errorf("job %s failed: %s", job.Name, c.Message))
+		}
+	}
+	return nil
+}
+
+// addRestoreContainer verifies the checksum of the dump backup the instance
+// is bootstrapped from, then decrypts and decompresses it into a script the
+// entrypoint runs before the init scripts.
+//
+// The container is only added until the instance is initialized, so that the
+// backup volume is released once the restored data is on the data volume.
+// Removing it changes the pod template, which restarts the server once more,
+// in the maintenance window when there is one.
+func (r *MariaDBReconciler) addRestoreContainer(database mariak8gv1beta1.MariaDB, podSpec *corev1.PodSpec) error {
+	restore := backupRestore(database)
+	if restore == nil || initialized(database) {
+		return nil
+	}
+	if r.BackupImage == "" {
+		return errors.New("the manager image is unknown, restoring dump backups needs the --backup-image flag")
+	}
+	args := []string{
+		"restore",
+		"--dir=" + dumpBackupsDir,
+		"--name=" + restore.Name,
+		"--out=" + dumpWorkDir + "/" + restoreDumpFile,
+	}
+	mounts := []corev1.VolumeMount{
+		{Name: "restore", MountPath: dumpWorkDir},
+		{Name: "backups", MountPath: dumpBackupsDir, ReadOnly: true},
+	}
+	podSpec.Volumes = append(podSpec.Volumes,
+		corev1.Volume{Name: "restore", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}},
+		corev1.Volume{Name: "backups", VolumeSource: corev1.VolumeSource{
+			PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: restore.VolumeClaimName, ReadOnly: true},
+		}},
+	)
+	if encryption := restore.Encryption; encryption != nil {
+		args = append(args, "--key-file="+dumpKeyDir+"/key")
+		podSpec.Volumes = append(podSpec.Volumes, keyVolume(*encryption))
+		mounts = append(mounts, corev1.VolumeMount{Name: "key", MountPath: dumpKeyDir, ReadOnly: true})
+	}
+
+	podSpec.InitContainers = append(podSpec.InitContainers, corev1.Container{
+		Name:         "restore",
+		Image:        r.BackupImage,
+		Command:      []string{"/manager"},
+		Args:         args,
+		VolumeMounts: mounts,
+	})
+	podSpec.Containers[0].VolumeMounts = append(podSpec.Containers[0].VolumeMounts,
+		corev1.VolumeMount{Name: "restore", MountPath: initScriptsDir + "/" + restoreDumpFile, SubPath: restoreDumpFile, ReadOnly: true})
+	return nil
+}
+
+func validateDumpBackup(database mariak8gv1beta1.MariaDB) error {
+	if restore := backupRestore(database); restore != nil {
+		if restore.Name == "" || restore.VolumeClaimName == "" {
+			return invalidSpecError("bootstrap.backup needs the name of the backup and its volumeClaimName")
+		}
+		if strings.ContainsAny(restore.Name, "/ ") {
+			return invalidSpecError(fmt.Sprintf("invalid backup name %q", restore.Name))
+		}
+	}
+	if !dumpBackupsEnabled(database) {
+		return nil
+	}
+	if database.Spec.Backup.Dump == nil || database.Spec.Backup.Dump.VolumeClaimName == "" {
+		return invalidSpecError("dump backups need backup.dump.volumeClaimName")
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+
+	mariak8gv1beta1 "github.com/mariadb/mariadb.org-tools/mariadb-operator/api/v1beta1"
+)
+
+func TestAddRestoreContainer(t *testing.T) {
+	restore := &mariak8gv1beta1.BackupRestoreSpec{Name: "shop-20211019030000", VolumeClaimName: "backups"}
+	encrypted := *restore
+	encrypted.Encryption = &mariak8gv1beta1.BackupEncryptionSpec{
+		SecretKeyRef: corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "backup-key"}, Key: "key"},
+	}
+	completed := &mariak8gv1beta1.InitializationStatus{Completed: true}
+
+	tests := []struct {
+		name           string
+		restore        *mariak8gv1beta1.BackupRestoreSpec
+		initialization *mariak8gv1beta1.InitializationStatus
+		backupImage    string
+		wantArgs       []string
+		wantVolumes    []string
+		wantErr        bool
+	}{
+		{name: "no restore", backupImage: "operator:v1"},
+		{
+			name:        "restore",
+			restore:     restore,
+			backupImage: "operator:v1",
+			wantArgs:    []string{"restore", "--dir=/backups", "--name=shop-20211019030000", "--out=/work/0-restore.sql"},
+			wantVolumes: []string{"restore", "backups"},
+		},
+		{
+			name:        "encrypted",
+			restore:     &encrypted,
+			backupImage: "operator:v1",
+			wantArgs:    []string{"restore", "--dir=/backups", "--name=shop-20211019030000", "--out=/work/0-restore.sql", "--key-file=/backup-key/key"},
+			wantVolumes: []string{"restore", "backups", "key"},
+		},
+		// the pods are restarted without the container, releasing the backup volume
+		{name: "initialized", restore: restore, initialization: completed, backupImage: "operator:v1"},
+		{name: "unknown manager image", restore: restore, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &MariaDBReconciler{BackupImage: tt.backupImage}
+			database := mariak8gv1beta1.MariaDB{
+				Spec:   mariak8gv1beta1.MariaDBSpec{Bootstrap: &mariak8gv1beta1.BootstrapSpec{Backup: tt.restore}},
+				Status: mariak8gv1beta1.MariaDBStatus{Initialization: tt.initialization},
+			}
+			podSpec := corev1.PodSpec{Containers: []corev1.Container{{Name: "mariadb"}}}
+			err := r.addRestoreContainer(database, &podSpec)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("addRestoreContainer() = %v, want error: %v", err, tt.wantErr)
+			}
+
+			if tt.wantArgs == nil {
+				if len(podSpec.InitContainers) > 0 || len(podSpec.Volumes) > 0 || len(podSpec.Containers[0].VolumeMounts) > 0 {
+					t.Errorf("pod spec changed: %+v", podSpec)
+				}
+				return
+			}
+			if len(podSpec.InitContainers) != 1 || podSpec.InitContainers[0].Image != tt.backupImage {
+				t.Fatalf("init containers %+v", podSpec.InitContainers)
+			}
+			if args := podSpec.InitContainers[0].Args; !reflect.DeepEqual(args, tt.wantArgs) {
+				t.Errorf("restore args %q, want %q", args, tt.wantArgs)
+			}
+			var volumes []string
+			for _, volume := range podSpec.Volumes {
+				volumes = append(volumes, volume.Name)
+				if claim := volume.PersistentVolumeClaim; claim != nil && (claim.ClaimName != "backups" || !claim.ReadOnly) {
+					t.Errorf("backup claim %+v", claim)
+				}
+			}
+			if !reflect.DeepEqual(volumes, tt.wantVolumes) {
+				t.Errorf("volumes %v, want %v", volumes, tt.wantVolumes)
+			}
+			// the entrypoint runs the restored dump like an init script
+			mounts := podSpec.Containers[0].VolumeMounts
+			if len(mounts) != 1 || mounts[0].MountPath != initScriptsDir+"/0-restore.sql" || mounts[0].SubPath != "0-restore.sql" {
+				t.Errorf("server mounts %+v", mounts)
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	corev1 "k8s.io/api/core/v1"
+
+	mariak8gv1beta1 "github.com/mariadb/mariadb.org-tools/mariadb-operator/api/v1beta1"
+)
+
+// Reasons of the events recorded on MariaDB objects. Each situation has a
+// single reason, so repeated occurrences are aggregated by the event recorder.
+const (
+	// EventReasonCreated is recorded when the workload of a new instance is first applied
+	EventReasonCreated = "Created"
+	// EventReasonSpecApplied is recorded when a new generation of the spec has been applied
+	EventReasonSpecApplied = "SpecApplied"
+	// EventReasonPhaseChanged is recorded when the instance moves to another phase
+	EventReasonPhaseChanged = "PhaseChanged"
+	// EventReasonInvalidSpec is recorded when the spec can't be applied as is
+	EventReasonInvalidSpec = "InvalidSpec"
+	// EventReasonApplyFailed is recorded when an owned object can't be applied
+	EventReasonApplyFailed = "ApplyFailed"
+	// EventReasonUpgradeStarted is recorded when a version upgrade starts rolling
+	EventReasonUpgradeStarted = "UpgradeStarted"
+	// EventReasonUpgradeFailed is recorded when mariadb-upgrade fails on a pod
+	EventReasonUpgradeFailed = "UpgradeFailed"
+	// EventReasonUpgraded is recorded when all pods run and were upgraded to the new version
+	EventReasonUpgraded = "Upgraded"
+	// EventReasonPaused is recorded when reconciliation gets suspended
+	EventReasonPaused = "Paused"
+	// EventReasonResumed is recorded when reconciliation resumes after a pause
+	EventReasonResumed = "Resumed"
+	// EventReasonChangesStaged is recorded when pod changes are held until the maintenance window
+	EventReasonChangesStaged = "ChangesStaged"
+	// EventReasonDriftDetected is recorded when an owned object was edited outside of the operator
+	EventReasonDriftDetected = "DriftDetected"
+	// EventReasonInitScriptsChanged is recorded when init scripts are edited after initialization
+	EventReasonInitScriptsChanged = "InitScriptsChanged"
+	// EventReasonCloned is recorded when the data of another instance was copied into a new one
+	EventReasonCloned = "Cloned"
+	// EventReasonBackupSucceeded is recorded when a backup completes
+	EventReasonBackupSucceeded = "BackupSucceeded"
+	// EventReasonBackupFailed is recorded when a backup can't be completed
+	EventReasonBackupFailed = "BackupFailed"
+)
+
+// recordStatusEvents records the lifecycle events of a saved status: the
+// phase change, and the first or a new generation of the spec applied.
+func (r *MariaDBReconciler) recordStatusEvents(app *mariak8gv1beta1.MariaDB, previousPhase mariak8gv1beta1.StatusPhase, previousGeneration int64) {
+	if app.Status.ObservedGeneration != previousGeneration {
+		if previousPhase == "" {
+			r.Recorder.Eventf(app, corev1.EventTypeNormal, EventReasonCreated, "created deployment %s and service %s", deploymentName(*app), serviceName(*app))
+		} else {
+			r.Recorder.Eventf(app, corev1.EventTypeNormal, EventReasonSpecApplied, "applied generation %d of the spec", app.Generation)
+		}
+	}
+	if app.Status.DbState != previousPhase {
+		eventType := corev1.EventTypeNormal
+		if app.Status.DbState == mariak8gv1beta1.ErrorStatusPhase {
+			eventType = corev1.EventTypeWarning
+		}
+		r.Recorder.Eventf(app, eventType, EventReasonPhaseChanged, "phase changed from %q to %q", previousPhase, app.Status.DbState)
+	}
+}
@@ -0,0 +1,90 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"strings"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+
+	mariak8gv1beta1 "github.com/mariadb/mariadb.org-tools/mariadb-operator/api/v1beta1"
+)
+
+func TestRecordStatusEvents(t *testing.T) {
+	running, failed := mariak8gv1beta1.RunningStatusPhase, mariak8gv1beta1.ErrorStatusPhase
+
+	tests := []struct {
+		name               string
+		previousPhase      mariak8gv1beta1.StatusPhase
+		previousGeneration int64
+		status             mariak8gv1beta1.MariaDBStatus
+		want               []string
+	}{
+		{
+			name:   "created",
+			status: mariak8gv1beta1.MariaDBStatus{DbState: running, ObservedGeneration: 1},
+			want:   []string{"Normal Created", `Normal PhaseChanged phase changed from "" to "RUNNING"`},
+		},
+		{
+			name:               "spec applied",
+			previousPhase:      running,
+			previousGeneration: 1,
+			status:             mariak8gv1beta1.MariaDBStatus{DbState: running, ObservedGeneration: 2},
+			want:               []string{"Normal SpecApplied applied generation 2 of the spec"},
+		},
+		{
+			name:               "failed",
+			previousPhase:      running,
+			previousGeneration: 2,
+			status:             mariak8gv1beta1.MariaDBStatus{DbState: failed, ObservedGeneration: 2},
+			want:               []string{`Warning PhaseChanged phase changed from "RUNNING" to "ERROR"`},
+		},
+		{
+			name:               "nothing changed",
+			previousPhase:      running,
+			previousGeneration: 2,
+			status:             mariak8gv1beta1.MariaDBStatus{DbState: running, ObservedGeneration: 2},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := record.NewFakeRecorder(10)
+			r := &MariaDBReconciler{Recorder: recorder}
+			database := mariak8gv1beta1.MariaDB{
+				ObjectMeta: metav1.ObjectMeta{Name: "shop", Namespace: "default", Generation: tt.status.ObservedGeneration},
+				Status:     tt.status,
+			}
+			r.recordStatusEvents(&database, tt.previousPhase, tt.previousGeneration)
+			close(recorder.Events)
+
+			var got []string
+			for event := range recorder.Events {
+				got = append(got, event)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("events %q, want %q", got, tt.want)
+			}
+			for i := range got {
+				if !strings.HasPrefix(got[i], tt.want[i]) {
+					t.Errorf("event %q, want %q", got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
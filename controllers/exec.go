@@ -0,0 +1,97 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+// mariadbClientCommand runs the SQL read from stdin as root, using the
+// password the server container was started with.
+var mariadbClientCommand = []string{"sh", "-c", `exec mariadb --user=root --password="$MARIADB_ROOT_PASSWORD" --batch --skip-column-names`}
+
+// execInPod runs command in a container of the pod and returns its stdout.
+// The stderr output is part of the returned error when the command fails.
+func execInPod(ctx context.Context, config *rest.Config, pod corev1.Pod, container string, command []string, stdin io.Reader) (string, error) {
+	var stdout bytes.Buffer
+	err := streamInPod(ctx, config, pod, container, command, stdin, &stdout)
+	return stdout.String(), err
+}
+
+// streamInPod runs command in a container of the pod writing its stdout to
+// stdout as it comes, for outputs too large to be kept in memory.
+func streamInPod(ctx context.Context, config *rest.Config, pod corev1.Pod, container string, command []string, stdin io.Reader, stdout io.Writer) error {
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return err
+	}
+
+	req := clientset.CoreV1().RESTClient().Post().
+		Resource("pods").
+		Namespace(pod.Namespace).
+		Name(pod.Name).
+		SubResource("exec").
+		VersionedParams(&corev1.PodExecOptions{
+			Container: container,
+			Command:   command,
+			Stdin:     stdin != nil,
+			Stdout:    true,
+			Stderr:    true,
+		}, scheme.ParameterCodec)
+
+	executor, err := remotecommand.NewSPDYExecutor(config, "POST", req.URL())
+	if err != nil {
+		return err
+	}
+
+	var stderr bytes.Buffer
+	done := make(chan error, 1)
+	go func() {
+		done <- executor.Stream(remotecommand.StreamOptions{Stdin: stdin, Stdout: stdout, Stderr: &stderr})
+	}()
+
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	if err != nil {
+		return fmt.Errorf("%s in %s/%s: %w: %s", strings.Join(command, " "), pod.Name, container, err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// execSQL runs statements with the mariadb client of the server container
+// and returns the tab separated result rows.
+func (r *MariaDBReconciler) execSQL(ctx context.Context, pod corev1.Pod, sql string) (string, error) {
+	return execInPod(ctx, r.Config, pod, "mariadb", mariadbClientCommand, strings.NewReader(sql))
+}
+
+// quoteSQL returns s as a SQL string literal.
+func quoteSQL(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s) + "'"
+}
@@ -0,0 +1,257 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	mariak8gv1beta1 "github.com/mariadb/mariadb.org-tools/mariadb-operator/api/v1beta1"
+)
+
+const exporterUser = "mariadb-exporter"
+
+// defaultMonitoringNamespace is where the Prometheus scraping the exporter
+// runs when the metrics spec doesn't select its pods
+const defaultMonitoringNamespace = "monitoring"
+
+var serviceMonitorGVK = schema.GroupVersionKind{Group: "monitoring.coreos.com", Version: "v1", Kind: "ServiceMonitor"}
+
+func metricsEnabled(database mariak8gv1beta1.MariaDB) bool {
+	return database.Spec.Metrics != nil && database.Spec.Metrics.Enabled
+}
+
+func metricsPort(database mariak8gv1beta1.MariaDB) int32 {
+	if database.Spec.Metrics == nil || database.Spec.Metrics.Port == 0 {
+		return 9104
+	}
+	return database.Spec.Metrics.Port
+}
+
+func exporterSecretName(database mariak8gv1beta1.MariaDB) string {
+	return database.Name + "-metrics-exporter"
+}
+
+func serviceMonitorName(database mariak8gv1beta1.MariaDB) string {
+	return database.Name + "-metrics"
+}
+
+func exporterContainer(database mariak8gv1beta1.MariaDB) corev1.Container {
+	image := database.Spec.Metrics.Image
+	if image == "" {
+		image = "prom/mysqld-exporter:v0.14.0"
+	}
+	port := metricsPort(database)
+
+	return corev1.Container{
+		Name:  "metrics-exporter",
+		Image: image,
+		Args:  []string{fmt.Sprintf("--web.listen-address=:%d", port)},
+		Env: []corev1.EnvVar{
+			{Name: "DATA_SOURCE_NAME", ValueFrom: &corev1.EnvVarSource{
+				SecretKeyRef: &corev1.SecretKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: exporterSecretName(database)},
+					Key:                  "dsn",
+				},
+			}},
+		},
+		Ports: []corev1.ContainerPort{
+			{ContainerPort: port, Name: "metrics", Protocol: "TCP"},
+		},
+	}
+}
+
+// randomPassword returns 128 random bits, hex encoded so it never needs quoting.
+func randomPassword() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// ensureExporterSecret creates the credentials of the exporter user once,
+// the password is generated and kept for the lifetime of the instance.
+func (r *MariaDBReconciler) ensureExporterSecret(ctx context.Context, database mariak8gv1beta1.MariaDB) (string, error) {
+	var secret corev1.Secret
+	err := r.Get(ctx, types.NamespacedName{Namespace: database.Namespace, Name: exporterSecretName(database)}, &secret)
+	if err == nil {
+		return string(secret.Data["password"]), nil
+	}
+	if ignoreNotFound(err) != nil {
+		return "", err
+	}
+
+	password, err := randomPassword()
+	if err != nil {
+		return "", err
+	}
+
+	secret = corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      exporterSecretName(database),
+			Namespace: database.Namespace,
+		},
+		StringData: map[string]string{
+			"username": exporterUser,
+			"password": password,
+			"dsn":      fmt.Sprintf("%s:%s@(127.0.0.1:%d)/", exporterUser, password, mariadbPort(database)),
+		},
+	}
+	if err := ctrl.SetControllerReference(&database, &secret, r.Scheme); err != nil {
+		return "", err
+	}
+	if err := r.Create(ctx, &secret); err != nil {
+		return "", err
+	}
+	return password, nil
+}
+
+// reconcileExporter creates the exporter user on every ready pod, and the
+// ServiceMonitor when the Prometheus Operator CRDs are installed.
+func (r *MariaDBReconciler) reconcileExporter(ctx context.Context, database *mariak8gv1beta1.MariaDB, password string) error {
+	if !metricsEnabled(*database) {
+		if err := r.dropExporterUser(ctx, database); err != nil {
+			return err
+		}
+		if err := r.Delete(ctx, &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: exporterSecretName(*database), Namespace: database.Namespace}}); ignoreNotFound(err) != nil {
+			return err
+		}
+		return r.deleteServiceMonitor(ctx, *database)
+	}
+
+	pods, err := r.instancePods(ctx, *database)
+	if err != nil {
+		return err
+	}
+
+	// the user is local to each server, forget about pods that are gone
+	var userPods []string
+	for _, pod := range pods {
+		if containsString(database.Status.ExporterUserPods, pod.Name) {
+			userPods = append(userPods, pod.Name)
+		}
+	}
+	database.Status.ExporterUserPods = userPods
+
+	sql := fmt.Sprintf(`CREATE USER IF NOT EXISTS '%[1]s'@'127.0.0.1' IDENTIFIED BY '%[2]s' WITH MAX_USER_CONNECTIONS 3;
+ALTER USER '%[1]s'@'127.0.0.1' IDENTIFIED BY '%[2]s';
+GRANT PROCESS, REPLICATION CLIENT, SELECT ON *.* TO '%[1]s'@'127.0.0.1';
+`, exporterUser, password)
+	for _, pod := range pods {
+		if !podReady(pod) || containsString(database.Status.ExporterUserPods, pod.Name) {
+			continue
+		}
+		if _, err := r.execSQL(ctx, pod, sql); err != nil {
+			return err
+		}
+		database.Status.ExporterUserPods = append(database.Status.ExporterUserPods, pod.Name)
+	}
+
+	installed, err := r.serviceMonitorInstalled()
+	if err != nil || !installed {
+		return err
+	}
+	sm := r.desiredServiceMonitor(*database)
+	if err := ctrl.SetControllerReference(database, sm, r.Scheme); err != nil {
+		return err
+	}
+	applyOpts := []client.PatchOption{client.ForceOwnership, client.FieldOwner("mariadb-controller")}
+	return r.Patch(ctx, sm, client.Apply, applyOpts...)
+}
+
+// dropExporterUser removes the exporter user once metrics are disabled. The
+// user is kept in the data directory, so it is dropped from every ready pod,
+// and retried while none is ready.
+func (r *MariaDBReconciler) dropExporterUser(ctx context.Context, database *mariak8gv1beta1.MariaDB) error {
+	if database.Status.ExporterUserPods == nil {
+		return nil
+	}
+	pods, err := r.instancePods(ctx, *database)
+	if err != nil {
+		return err
+	}
+	dropped := false
+	for _, pod := range pods {
+		if !podReady(pod) {
+			continue
+		}
+		if _, err := r.execSQL(ctx, pod, fmt.Sprintf("DROP USER IF EXISTS '%s'@'127.0.0.1';\n", exporterUser)); err != nil {
+			return err
+		}
+		dropped = true
+	}
+	if dropped {
+		database.Status.ExporterUserPods = nil
+	}
+	return nil
+}
+
+// serviceMonitorInstalled looks the ServiceMonitor kind up at runtime, so the
+// operator works on clusters without the Prometheus Operator.
+func (r *MariaDBReconciler) serviceMonitorInstalled() (bool, error) {
+	_, err := r.RESTMapper().RESTMapping(serviceMonitorGVK.GroupKind(), serviceMonitorGVK.Version)
+	if meta.IsNoMatchError(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (r *MariaDBReconciler) desiredServiceMonitor(database mariak8gv1beta1.MariaDB) *unstructured.Unstructured {
+	sm := &unstructured.Unstructured{}
+	sm.SetGroupVersionKind(serviceMonitorGVK)
+	sm.SetName(serviceMonitorName(database))
+	sm.SetNamespace(database.Namespace)
+	sm.SetLabels(database.Spec.Metrics.ServiceMonitorLabels)
+	sm.Object["spec"] = map[string]interface{}{
+		"selector": map[string]interface{}{
+			"matchLabels": map[string]interface{}{"mariadb": database.Name},
+		},
+		"endpoints": []interface{}{
+			map[string]interface{}{"port": "metrics"},
+		},
+	}
+	return sm
+}
+
+func (r *MariaDBReconciler) deleteServiceMonitor(ctx context.Context, database mariak8gv1beta1.MariaDB) error {
+	installed, err := r.serviceMonitorInstalled()
+	if err != nil || !installed {
+		return err
+	}
+	sm := &unstructured.Unstructured{}
+	sm.SetGroupVersionKind(serviceMonitorGVK)
+	sm.SetName(serviceMonitorName(database))
+	sm.SetNamespace(database.Namespace)
+	return ignoreNotFound(r.Delete(ctx, sm))
+}
+
+func metricsServicePort(database mariak8gv1beta1.MariaDB) corev1.ServicePort {
+	return corev1.ServicePort{Name: "metrics", Port: metricsPort(database), Protocol: "TCP", TargetPort: intstr.FromString("metrics")}
+}
@@ -0,0 +1,150 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	mariak8gv1beta1 "github.com/mariadb/mariadb.org-tools/mariadb-operator/api/v1beta1"
+)
+
+func TestExporterContainer(t *testing.T) {
+	tests := []struct {
+		name      string
+		metrics   mariak8gv1beta1.MetricsSpec
+		wantImage string
+		wantPort  int32
+	}{
+		{
+			name:      "defaults",
+			metrics:   mariak8gv1beta1.MetricsSpec{Enabled: true},
+			wantImage: "prom/mysqld-exporter:v0.14.0",
+			wantPort:  9104,
+		},
+		{
+			name:      "image and port",
+			metrics:   mariak8gv1beta1.MetricsSpec{Enabled: true, Image: "registry.local/mysqld-exporter:v0.15.0", Port: 9200},
+			wantImage: "registry.local/mysqld-exporter:v0.15.0",
+			wantPort:  9200,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			metrics := tt.metrics
+			database := mariak8gv1beta1.MariaDB{
+				ObjectMeta: metav1.ObjectMeta{Name: "shop", Namespace: "default"},
+				Spec:       mariak8gv1beta1.MariaDBSpec{Metrics: &metrics},
+			}
+			container := exporterContainer(database)
+
+			if container.Image != tt.wantImage {
+				t.Errorf("image %q, want %q", container.Image, tt.wantImage)
+			}
+			if len(container.Ports) != 1 || container.Ports[0].ContainerPort != tt.wantPort || container.Ports[0].Name != "metrics" {
+				t.Errorf("ports %v, want metrics on %d", container.Ports, tt.wantPort)
+			}
+			// the credentials stay in the Secret of the exporter user
+			dsn := container.Env[0]
+			if dsn.Name != "DATA_SOURCE_NAME" || dsn.Value != "" || dsn.ValueFrom == nil || dsn.ValueFrom.SecretKeyRef.Name != "shop-metrics-exporter" {
+				t.Errorf("DATA_SOURCE_NAME = %+v", dsn)
+			}
+
+			port := metricsServicePort(database)
+			if port.Port != tt.wantPort || port.TargetPort.StrVal != "metrics" {
+				t.Errorf("service port %+v", port)
+			}
+		})
+	}
+}
+
+func TestDesiredServiceMonitor(t *testing.T) {
+	database := mariak8gv1beta1.MariaDB{
+		ObjectMeta: metav1.ObjectMeta{Name: "shop", Namespace: "default"},
+		Spec: mariak8gv1beta1.MariaDBSpec{Metrics: &mariak8gv1beta1.MetricsSpec{
+			Enabled:              true,
+			ServiceMonitorLabels: map[string]string{"release": "prometheus"},
+		}},
+	}
+	r := &MariaDBReconciler{}
+	sm := r.desiredServiceMonitor(database)
+
+	if sm.GroupVersionKind() != serviceMonitorGVK {
+		t.Errorf("kind %v", sm.GroupVersionKind())
+	}
+	if sm.GetName() != "shop-metrics" || sm.GetNamespace() != "default" {
+		t.Errorf("service monitor is %s/%s", sm.GetNamespace(), sm.GetName())
+	}
+	if !reflect.DeepEqual(sm.GetLabels(), map[string]string{"release": "prometheus"}) {
+		t.Errorf("labels %v", sm.GetLabels())
+	}
+	spec := sm.Object["spec"].(map[string]interface{})
+	selector := spec["selector"].(map[string]interface{})["matchLabels"]
+	if !reflect.DeepEqual(selector, map[string]interface{}{"mariadb": "shop"}) {
+		t.Errorf("selector %v", selector)
+	}
+	if !reflect.DeepEqual(spec["endpoints"], []interface{}{map[string]interface{}{"port": "metrics"}}) {
+		t.Errorf("endpoints %v", spec["endpoints"])
+	}
+}
+
+func TestEnsureExporterSecret(t *testing.T) {
+	database := mariak8gv1beta1.MariaDB{
+		ObjectMeta: metav1.ObjectMeta{Name: "shop", Namespace: "default", UID: "1234"},
+		Spec:       mariak8gv1beta1.MariaDBSpec{Metrics: &mariak8gv1beta1.MetricsSpec{Enabled: true}},
+	}
+	c := fake.NewClientBuilder().WithScheme(testScheme(t)).Build()
+	r := &MariaDBReconciler{Client: c, Scheme: testScheme(t)}
+
+	password, err := r.ensureExporterSecret(context.Background(), database)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(password) != 32 {
+		t.Errorf("password %q", password)
+	}
+
+	var secret corev1.Secret
+	if err := c.Get(context.Background(), types.NamespacedName{Namespace: "default", Name: "shop-metrics-exporter"}, &secret); err != nil {
+		t.Fatal(err)
+	}
+	if owners := secret.OwnerReferences; len(owners) != 1 || owners[0].UID != database.UID {
+		t.Errorf("secret is owned by %v", owners)
+	}
+	if got, want := secret.StringData["dsn"], "mariadb-exporter:"+password+"@(127.0.0.1:3306)/"; got != want {
+		t.Errorf("dsn %q, want %q", got, want)
+	}
+
+	// the fake client doesn't turn stringData into data like the API server
+	secret.Data = map[string][]byte{"password": []byte(password)}
+	if err := c.Update(context.Background(), &secret); err != nil {
+		t.Fatal(err)
+	}
+	again, err := r.ensureExporterSecret(context.Background(), database)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again != password {
+		t.Errorf("password changed from %q to %q", password, again)
+	}
+}
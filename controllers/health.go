@@ -0,0 +1,210 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	mariak8gv1beta1 "github.com/mariadb/mariadb.org-tools/mariadb-operator/api/v1beta1"
+)
+
+// healthSQL reads the server statistics as a single tab separated row
+const healthSQL = `SELECT VERSION(), @@max_connections,
+  (SELECT VARIABLE_VALUE FROM information_schema.GLOBAL_STATUS WHERE VARIABLE_NAME = 'UPTIME'),
+  (SELECT VARIABLE_VALUE FROM information_schema.GLOBAL_STATUS WHERE VARIABLE_NAME = 'THREADS_CONNECTED'),
+  (SELECT VARIABLE_VALUE FROM information_schema.GLOBAL_STATUS WHERE VARIABLE_NAME = 'INNODB_BUFFER_POOL_READ_REQUESTS'),
+  (SELECT VARIABLE_VALUE FROM information_schema.GLOBAL_STATUS WHERE VARIABLE_NAME = 'INNODB_BUFFER_POOL_READS');`
+
+// healthCheckSpec returns the health check settings, with the CRD defaults
+// for the fields left unset.
+func healthCheckSpec(database mariak8gv1beta1.MariaDB) mariak8gv1beta1.HealthCheckSpec {
+	spec := mariak8gv1beta1.HealthCheckSpec{
+		Interval:                &metav1.Duration{Duration: time.Minute},
+		MaxConnectionsPercent:   90,
+		MaxDataUsagePercent:     85,
+		MinBufferPoolHitPercent: 90,
+	}
+	if hc := database.Spec.HealthCheck; hc != nil {
+		if hc.Interval != nil && hc.Interval.Duration > 0 {
+			spec.Interval = hc.Interval
+		}
+		if hc.MaxConnectionsPercent > 0 {
+			spec.MaxConnectionsPercent = hc.MaxConnectionsPercent
+		}
+		if hc.MaxDataUsagePercent > 0 {
+			spec.MaxDataUsagePercent = hc.MaxDataUsagePercent
+		}
+		spec.MinBufferPoolHitPercent = hc.MinBufferPoolHitPercent
+	}
+	return spec
+}
+
+// healthCheckWait is how long until the next health check is due, the
+// statistics are collected at most once per interval.
+func healthCheckWait(database mariak8gv1beta1.MariaDB) time.Duration {
+	interval := healthCheckSpec(database).Interval.Duration
+	health := database.Status.Health
+	if health == nil || health.CheckTime == nil {
+		return 0
+	}
+	if wait := interval - time.Since(health.CheckTime.Time); wait > 0 && wait <= interval {
+		return wait
+	}
+	return 0
+}
+
+// healthRequeue is when to come back for the next health check, a whole
+// interval when no check was made as no pod was ready.
+func healthRequeue(database mariak8gv1beta1.MariaDB) time.Duration {
+	if wait := healthCheckWait(database); wait > 0 {
+		return wait
+	}
+	return healthCheckSpec(database).Interval.Duration
+}
+
+// reconcileHealth collects the server statistics from the oldest ready pod into
+// the status and sets the Degraded condition from the thresholds. The
+// status is left as is while no pod is ready.
+func (r *MariaDBReconciler) reconcileHealth(ctx context.Context, database *mariak8gv1beta1.MariaDB) error {
+	if healthCheckWait(*database) > 0 {
+		return nil
+	}
+	pods, err := r.instancePods(ctx, *database)
+	if err != nil {
+		return err
+	}
+	pod := oldestReadyPod(pods)
+	if pod == nil {
+		return nil
+	}
+
+	health, err := r.podHealth(ctx, *database, *pod)
+	if err != nil {
+		return err
+	}
+	database.Status.Health = health
+	setDegradedCondition(database, healthCheckSpec(*database), *health)
+	return nil
+}
+
+func (r *MariaDBReconciler) podHealth(ctx context.Context, database mariak8gv1beta1.MariaDB, pod corev1.Pod) (*mariak8gv1beta1.HealthStatus, error) {
+	out, err := r.execSQL(ctx, pod, healthSQL)
+	if err != nil {
+		return nil, err
+	}
+	fields := strings.Split(strings.TrimSpace(out), "\t")
+	if len(fields) != 6 {
+		return nil, fmt.Errorf("unexpected health statistics from %s: %q", pod.Name, out)
+	}
+	values := make([]int64, 5)
+	for i, field := range fields[1:] {
+		if values[i], err = strconv.ParseInt(field, 10, 64); err != nil {
+			return nil, fmt.Errorf("unexpected health statistics from %s: %q", pod.Name, out)
+		}
+	}
+
+	now := metav1.Now()
+	health := &mariak8gv1beta1.HealthStatus{
+		Pod:            pod.Name,
+		Version:        fields[0],
+		MaxConnections: values[0],
+		UptimeSeconds:  values[1],
+		Connections:    values[2],
+		CheckTime:      &now,
+	}
+	if readRequests, reads := values[3], values[4]; readRequests > 0 {
+		health.BufferPoolHitRatio = strconv.FormatFloat(100*(1-float64(reads)/float64(readRequests)), 'f', 2, 64)
+	}
+
+	used, size, err := r.dataDirUsage(ctx, database, pod)
+	if err != nil {
+		return nil, err
+	}
+	health.DataUsed = &used
+	health.DataCapacity = &size
+	for _, volume := range database.Status.Storage {
+		if volume.Name == dataVolumeClaimName(database) && volume.Capacity != nil {
+			capacity := volume.Capacity.DeepCopy()
+			health.DataCapacity = &capacity
+			break
+		}
+	}
+	return health, nil
+}
+
+// dataDirUsage returns the used and total size of the file system holding
+// the data directory, as reported by df in the server container.
+func (r *MariaDBReconciler) dataDirUsage(ctx context.Context, database mariak8gv1beta1.MariaDB, pod corev1.Pod) (resource.Quantity, resource.Quantity, error) {
+	out, err := execInPod(ctx, r.Config, pod, "mariadb", []string{"df", "-Pk", dataDir(database)}, nil)
+	if err != nil {
+		return resource.Quantity{}, resource.Quantity{}, err
+	}
+	// Filesystem 1024-blocks Used Available Capacity Mounted on
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	fields := strings.Fields(lines[len(lines)-1])
+	if len(lines) < 2 || len(fields) < 3 {
+		return resource.Quantity{}, resource.Quantity{}, fmt.Errorf("unexpected df output from %s: %q", pod.Name, out)
+	}
+	total, errTotal := strconv.ParseInt(fields[1], 10, 64)
+	used, errUsed := strconv.ParseInt(fields[2], 10, 64)
+	if errTotal != nil || errUsed != nil {
+		return resource.Quantity{}, resource.Quantity{}, fmt.Errorf("unexpected df output from %s: %q", pod.Name, out)
+	}
+	return *resource.NewQuantity(used*1024, resource.BinarySI), *resource.NewQuantity(total*1024, resource.BinarySI), nil
+}
+
+// setDegradedCondition sets Degraded with every threshold crossed by health.
+func setDegradedCondition(database *mariak8gv1beta1.MariaDB, spec mariak8gv1beta1.HealthCheckSpec, health mariak8gv1beta1.HealthStatus) {
+	var reasons, messages []string
+
+	if health.MaxConnections > 0 && health.Connections*100 >= health.MaxConnections*int64(spec.MaxConnectionsPercent) {
+		reasons = append(reasons, "TooManyConnections")
+		messages = append(messages, fmt.Sprintf("%d of %d connections in use", health.Connections, health.MaxConnections))
+	}
+	if health.DataUsed != nil && health.DataCapacity != nil && !health.DataCapacity.IsZero() &&
+		health.DataUsed.Value()*100 >= health.DataCapacity.Value()*int64(spec.MaxDataUsagePercent) {
+		reasons = append(reasons, "DataVolumeFull")
+		messages = append(messages, fmt.Sprintf("%s of %s data volume in use", health.DataUsed.String(), health.DataCapacity.String()))
+	}
+	if ratio, err := strconv.ParseFloat(health.BufferPoolHitRatio, 64); err == nil && ratio < float64(spec.MinBufferPoolHitPercent) {
+		reasons = append(reasons, "LowBufferPoolHitRatio")
+		messages = append(messages, fmt.Sprintf("InnoDB buffer pool hit ratio is %s%%", health.BufferPoolHitRatio))
+	}
+
+	condition := metav1.Condition{
+		Type:               mariak8gv1beta1.ConditionDegraded,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: database.Generation,
+		Reason:             "Healthy",
+		Message:            "all health checks are within their thresholds",
+	}
+	if len(reasons) > 0 {
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = strings.Join(reasons, "And")
+		condition.Message = strings.Join(messages, ", ")
+	}
+	meta.SetStatusCondition(&database.Status.Conditions, condition)
+}
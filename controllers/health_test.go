@@ -0,0 +1,142 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	mariak8gv1beta1 "github.com/mariadb/mariadb.org-tools/mariadb-operator/api/v1beta1"
+)
+
+func TestHealthCheckSpec(t *testing.T) {
+	tests := []struct {
+		name        string
+		healthCheck *mariak8gv1beta1.HealthCheckSpec
+		want        mariak8gv1beta1.HealthCheckSpec
+	}{
+		{
+			name: "defaults",
+			want: mariak8gv1beta1.HealthCheckSpec{Interval: &metav1.Duration{Duration: time.Minute}, MaxConnectionsPercent: 90, MaxDataUsagePercent: 85, MinBufferPoolHitPercent: 90},
+		},
+		{
+			name:        "thresholds",
+			healthCheck: &mariak8gv1beta1.HealthCheckSpec{Interval: &metav1.Duration{Duration: 5 * time.Minute}, MaxConnectionsPercent: 80, MaxDataUsagePercent: 70},
+			// a hit ratio of 0 disables the buffer pool check
+			want: mariak8gv1beta1.HealthCheckSpec{Interval: &metav1.Duration{Duration: 5 * time.Minute}, MaxConnectionsPercent: 80, MaxDataUsagePercent: 70},
+		},
+		{
+			name:        "zero interval",
+			healthCheck: &mariak8gv1beta1.HealthCheckSpec{Interval: &metav1.Duration{}, MinBufferPoolHitPercent: 95},
+			want:        mariak8gv1beta1.HealthCheckSpec{Interval: &metav1.Duration{Duration: time.Minute}, MaxConnectionsPercent: 90, MaxDataUsagePercent: 85, MinBufferPoolHitPercent: 95},
+		},
+	}
+	for _, tt := range tests {
+		database := mariak8gv1beta1.MariaDB{Spec: mariak8gv1beta1.MariaDBSpec{HealthCheck: tt.healthCheck}}
+		got := healthCheckSpec(database)
+		if got.Interval.Duration != tt.want.Interval.Duration || got.MaxConnectionsPercent != tt.want.MaxConnectionsPercent ||
+			got.MaxDataUsagePercent != tt.want.MaxDataUsagePercent || got.MinBufferPoolHitPercent != tt.want.MinBufferPoolHitPercent {
+			t.Errorf("%s: healthCheckSpec() = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestHealthCheckWait(t *testing.T) {
+	checked := func(ago time.Duration) *mariak8gv1beta1.HealthStatus {
+		at := metav1.NewTime(time.Now().Add(-ago))
+		return &mariak8gv1beta1.HealthStatus{CheckTime: &at}
+	}
+	tests := []struct {
+		name        string
+		health      *mariak8gv1beta1.HealthStatus
+		wantWait    bool
+		wantRequeue time.Duration
+	}{
+		{name: "never checked", wantRequeue: time.Minute},
+		{name: "checked recently", health: checked(20 * time.Second), wantWait: true},
+		{name: "check due", health: checked(2 * time.Minute), wantRequeue: time.Minute},
+		// a clock going backwards doesn't hold the checks for longer than an interval
+		{name: "checked in the future", health: checked(-time.Hour), wantRequeue: time.Minute},
+	}
+	for _, tt := range tests {
+		database := mariak8gv1beta1.MariaDB{Status: mariak8gv1beta1.MariaDBStatus{Health: tt.health}}
+		wait := healthCheckWait(database)
+		if (wait > 0) != tt.wantWait || wait > time.Minute {
+			t.Errorf("%s: healthCheckWait() = %v", tt.name, wait)
+		}
+		requeue := healthRequeue(database)
+		if tt.wantWait && (requeue > wait || requeue < wait-time.Second) || !tt.wantWait && requeue != tt.wantRequeue {
+			t.Errorf("%s: healthRequeue() = %v", tt.name, requeue)
+		}
+	}
+}
+
+func TestSetDegradedCondition(t *testing.T) {
+	quantity := func(s string) *resource.Quantity {
+		q := resource.MustParse(s)
+		return &q
+	}
+	healthy := mariak8gv1beta1.HealthStatus{
+		MaxConnections:     100,
+		Connections:        10,
+		DataUsed:           quantity("1Gi"),
+		DataCapacity:       quantity("10Gi"),
+		BufferPoolHitRatio: "99.50",
+	}
+
+	tests := []struct {
+		name       string
+		health     func(*mariak8gv1beta1.HealthStatus)
+		wantStatus metav1.ConditionStatus
+		wantReason string
+	}{
+		{name: "healthy", health: func(*mariak8gv1beta1.HealthStatus) {}, wantStatus: metav1.ConditionFalse, wantReason: "Healthy"},
+		{name: "connections", health: func(h *mariak8gv1beta1.HealthStatus) { h.Connections = 95 }, wantStatus: metav1.ConditionTrue, wantReason: "TooManyConnections"},
+		{name: "data volume", health: func(h *mariak8gv1beta1.HealthStatus) { h.DataUsed = quantity("9Gi") }, wantStatus: metav1.ConditionTrue, wantReason: "DataVolumeFull"},
+		{name: "buffer pool", health: func(h *mariak8gv1beta1.HealthStatus) { h.BufferPoolHitRatio = "80.00" }, wantStatus: metav1.ConditionTrue, wantReason: "LowBufferPoolHitRatio"},
+		{name: "no buffer pool reads yet", health: func(h *mariak8gv1beta1.HealthStatus) { h.BufferPoolHitRatio = "" }, wantStatus: metav1.ConditionFalse, wantReason: "Healthy"},
+		{
+			name: "several thresholds",
+			health: func(h *mariak8gv1beta1.HealthStatus) {
+				h.Connections = 100
+				h.DataUsed = quantity("10Gi")
+			},
+			wantStatus: metav1.ConditionTrue,
+			wantReason: "TooManyConnectionsAndDataVolumeFull",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			health := healthy
+			tt.health(&health)
+			database := mariak8gv1beta1.MariaDB{ObjectMeta: metav1.ObjectMeta{Generation: 3}}
+			setDegradedCondition(&database, healthCheckSpec(database), health)
+
+			condition := meta.FindStatusCondition(database.Status.Conditions, mariak8gv1beta1.ConditionDegraded)
+			if condition == nil {
+				t.Fatal("no Degraded condition")
+			}
+			if condition.Status != tt.wantStatus || condition.Reason != tt.wantReason || condition.ObservedGeneration != 3 {
+				t.Errorf("condition %s %s (generation %d): %s", condition.Status, condition.Reason, condition.ObservedGeneration, condition.Message)
+			}
+		})
+	}
+}
@@ -0,0 +1,174 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	mariak8gv1beta1 "github.com/mariadb/mariadb.org-tools/mariadb-operator/api/v1beta1"
+)
+
+// initScriptsDir is where the image entrypoint looks for scripts to run,
+// in the alphabetical order of their file names.
+const initScriptsDir = "/docker-entrypoint-initdb.d"
+
+var initScriptExtensions = []string{".sql", ".sql.gz", ".sh"}
+
+// initScriptKey returns the key and the name of the ConfigMap or Secret
+// holding the script.
+func initScriptKey(script mariak8gv1beta1.InitScript) (key, name string) {
+	if ref := script.ConfigMapKeyRef; ref != nil {
+		return ref.Key, ref.Name
+	}
+	return script.SecretKeyRef.Key, script.SecretKeyRef.Name
+}
+
+// initScriptFile prefixes the key with the position of the script, so the
+// entrypoint runs the scripts in the order of the spec.
+func initScriptFile(i int, script mariak8gv1beta1.InitScript) string {
+	key, _ := initScriptKey(script)
+	return fmt.Sprintf("%02d-%s", i, key)
+}
+
+// validateInitScripts requires a storage size with scripts. The instance is
+// only initialized once, an emptyDir would be initialized again without its
+// scripts whenever its pod is replaced.
+func validateInitScripts(database mariak8gv1beta1.MariaDB) error {
+	if len(database.Spec.InitScripts) > 0 && database.Spec.Storage.Size == "" {
+		return invalidSpecError("init scripts need a storage size, they would run again on the emptyDir of every new pod")
+	}
+	for i, script := range database.Spec.InitScripts {
+		if (script.ConfigMapKeyRef == nil) == (script.SecretKeyRef == nil) {
+			return invalidSpecError(fmt.Sprintf("init script %d must set one of configMapKeyRef and secretKeyRef", i))
+		}
+		key, _ := initScriptKey(script)
+		supported := false
+		for _, ext := range initScriptExtensions {
+			supported = supported || strings.HasSuffix(key, ext)
+		}
+		if !supported {
+			return invalidSpecError(fmt.Sprintf("init script %s must end in one of %s", key, strings.Join(initScriptExtensions, ", ")))
+		}
+	}
+	return nil
+}
+
+// initScriptVolumes mounts each script in the entrypoint directory with a
+// subPath, the directory can't be a projection of several sources.
+func initScriptVolumes(database mariak8gv1beta1.MariaDB) ([]corev1.Volume, []corev1.VolumeMount) {
+	var volumes []corev1.Volume
+	var mounts []corev1.VolumeMount
+	for i, script := range database.Spec.InitScripts {
+		name := fmt.Sprintf("init-script-%02d", i)
+		file := initScriptFile(i, script)
+		key, _ := initScriptKey(script)
+		items := []corev1.KeyToPath{{Key: key, Path: file}}
+
+		volume := corev1.Volume{Name: name}
+		if ref := script.ConfigMapKeyRef; ref != nil {
+			volume.ConfigMap = &corev1.ConfigMapVolumeSource{LocalObjectReference: ref.LocalObjectReference, Items: items, Optional: ref.Optional}
+		} else {
+			ref := script.SecretKeyRef
+			volume.Secret = &corev1.SecretVolumeSource{SecretName: ref.Name, Items: items, Optional: ref.Optional}
+		}
+		volumes = append(volumes, volume)
+		mounts = append(mounts, corev1.VolumeMount{Name: name, MountPath: initScriptsDir + "/" + file, SubPath: file, ReadOnly: true})
+	}
+	return volumes, mounts
+}
+
+// initScriptContent reads a script from its ConfigMap or Secret, empty when
+// either is missing.
+func (r *MariaDBReconciler) initScriptContent(ctx context.Context, namespace string, script mariak8gv1beta1.InitScript) ([]byte, error) {
+	key, name := initScriptKey(script)
+	if script.ConfigMapKeyRef != nil {
+		var cm corev1.ConfigMap
+		err := r.Get(ctx, types.NamespacedName{Namespace: namespace, Name: name}, &cm)
+		if data, ok := cm.BinaryData[key]; ok {
+			return data, nil
+		}
+		return []byte(cm.Data[key]), ignoreNotFound(err)
+	}
+	var secret corev1.Secret
+	err := r.Get(ctx, types.NamespacedName{Namespace: namespace, Name: name}, &secret)
+	return secret.Data[key], ignoreNotFound(err)
+}
+
+// initScriptsHash hashes the position, the source and the content of every
+// script, empty when there are none.
+func (r *MariaDBReconciler) initScriptsHash(ctx context.Context, database mariak8gv1beta1.MariaDB) (string, error) {
+	if len(database.Spec.InitScripts) == 0 {
+		return "", nil
+	}
+	h := sha256.New()
+	for i, script := range database.Spec.InitScripts {
+		content, err := r.initScriptContent(ctx, database.Namespace, script)
+		if err != nil {
+			return "", err
+		}
+		_, name := initScriptKey(script)
+		fmt.Fprintf(h, "%s\x00%s\x00", initScriptFile(i, script), name)
+		h.Write(content)
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil)[:8]), nil
+}
+
+// reconcileInitialization marks the instance initialized once its first
+// server is up, the entrypoint ran the scripts by then. Scripts edited later
+// are flagged, they only run again on an empty data directory.
+func (r *MariaDBReconciler) reconcileInitialization(ctx context.Context, database *mariak8gv1beta1.MariaDB, rolledOut bool) error {
+	hash, err := r.initScriptsHash(ctx, *database)
+	if err != nil {
+		return err
+	}
+
+	status := database.Status.Initialization
+	if status == nil && database.Status.ReadyTime != nil {
+		// ready before initialization was tracked, without any script run
+		status = &mariak8gv1beta1.InitializationStatus{Completed: true}
+		database.Status.Initialization = status
+	}
+	if status == nil || !status.Completed {
+		if !rolledOut {
+			return nil
+		}
+		now := metav1.Now()
+		database.Status.Initialization = &mariak8gv1beta1.InitializationStatus{
+			Completed:      true,
+			CompletionTime: &now,
+			ScriptsHash:    hash,
+		}
+		return nil
+	}
+
+	changed := hash != status.ScriptsHash
+	if changed && !status.ScriptsChanged {
+		r.Recorder.Event(database, corev1.EventTypeWarning, EventReasonInitScriptsChanged,
+			"init scripts changed after initialization, they are not run again on an initialized data directory")
+	}
+	status.ScriptsChanged = changed
+	return nil
+}
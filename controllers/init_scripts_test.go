@@ -0,0 +1,157 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	mariak8gv1beta1 "github.com/mariadb/mariadb.org-tools/mariadb-operator/api/v1beta1"
+)
+
+func TestValidateInitScripts(t *testing.T) {
+	configMap := func(key string) mariak8gv1beta1.InitScript {
+		return mariak8gv1beta1.InitScript{ConfigMapKeyRef: &corev1.ConfigMapKeySelector{
+			LocalObjectReference: corev1.LocalObjectReference{Name: "scripts"}, Key: key,
+		}}
+	}
+	secret := func(key string) mariak8gv1beta1.InitScript {
+		return mariak8gv1beta1.InitScript{SecretKeyRef: &corev1.SecretKeySelector{
+			LocalObjectReference: corev1.LocalObjectReference{Name: "scripts"}, Key: key,
+		}}
+	}
+	both := configMap("users.sql")
+	both.SecretKeyRef = secret("users.sql").SecretKeyRef
+
+	tests := []struct {
+		name     string
+		scripts  []mariak8gv1beta1.InitScript
+		emptyDir bool
+		wantErr  bool
+	}{
+		{name: "no scripts"},
+		{name: "no scripts on an emptyDir", emptyDir: true},
+		// the scripts would run again with every new pod
+		{name: "emptyDir", scripts: []mariak8gv1beta1.InitScript{configMap("schema.sql")}, emptyDir: true, wantErr: true},
+		{name: "supported extensions", scripts: []mariak8gv1beta1.InitScript{configMap("schema.sql"), configMap("data.sql.gz"), secret("users.sh")}},
+		{name: "unsupported extension", scripts: []mariak8gv1beta1.InitScript{configMap("schema.sql"), secret("users.txt")}, wantErr: true},
+		{name: "extension in the middle", scripts: []mariak8gv1beta1.InitScript{configMap("schema.sql.bak")}, wantErr: true},
+		{name: "no source", scripts: []mariak8gv1beta1.InitScript{{}}, wantErr: true},
+		{name: "both sources", scripts: []mariak8gv1beta1.InitScript{both}, wantErr: true},
+	}
+	for _, tt := range tests {
+		database := mariak8gv1beta1.MariaDB{Spec: mariak8gv1beta1.MariaDBSpec{InitScripts: tt.scripts}}
+		if !tt.emptyDir {
+			database.Spec.Storage.Size = "1Gi"
+		}
+		err := validateInitScripts(database)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: validateInitScripts() = %v, want error: %v", tt.name, err, tt.wantErr)
+		}
+		if _, ok := err.(invalidSpecError); err != nil && !ok {
+			t.Errorf("%s: error %v isn't an invalid spec", tt.name, err)
+		}
+	}
+}
+
+func TestInitScriptFile(t *testing.T) {
+	scripts := []mariak8gv1beta1.InitScript{
+		{ConfigMapKeyRef: &corev1.ConfigMapKeySelector{Key: "schema.sql"}},
+		{SecretKeyRef: &corev1.SecretKeySelector{Key: "users.sh"}},
+	}
+	// the entrypoint runs the scripts in the alphabetical order of the files
+	for i, want := range []string{"00-schema.sql", "01-users.sh"} {
+		if got := initScriptFile(i, scripts[i]); got != want {
+			t.Errorf("initScriptFile(%d) = %q, want %q", i, got, want)
+		}
+	}
+}
+
+func TestReconcileInitialization(t *testing.T) {
+	scripts := corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "scripts", Namespace: "default"},
+		Data:       map[string]string{"schema.sql": "CREATE TABLE t (id INT);"},
+	}
+	script := mariak8gv1beta1.InitScript{ConfigMapKeyRef: &corev1.ConfigMapKeySelector{
+		LocalObjectReference: corev1.LocalObjectReference{Name: "scripts"}, Key: "schema.sql",
+	}}
+	now := metav1.Now()
+
+	tests := []struct {
+		name            string
+		status          mariak8gv1beta1.MariaDBStatus
+		rolledOut       bool
+		wantInitialized bool
+		wantChanged     bool
+	}{
+		{
+			name: "new instance not rolled out",
+		},
+		{
+			name:            "new instance rolled out",
+			rolledOut:       true,
+			wantInitialized: true,
+		},
+		{
+			name:            "scripts added to an instance ready before",
+			status:          mariak8gv1beta1.MariaDBStatus{ReadyTime: &now},
+			rolledOut:       true,
+			wantInitialized: true,
+			wantChanged:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := record.NewFakeRecorder(10)
+			r := &MariaDBReconciler{
+				Client:   fake.NewClientBuilder().WithScheme(testScheme(t)).WithObjects(scripts.DeepCopy()).Build(),
+				Recorder: recorder,
+			}
+			database := mariak8gv1beta1.MariaDB{
+				ObjectMeta: metav1.ObjectMeta{Name: "shop", Namespace: "default"},
+				Spec:       mariak8gv1beta1.MariaDBSpec{InitScripts: []mariak8gv1beta1.InitScript{script}},
+				Status:     tt.status,
+			}
+			if err := r.reconcileInitialization(context.Background(), &database, tt.rolledOut); err != nil {
+				t.Fatal(err)
+			}
+
+			if initialized(database) != tt.wantInitialized {
+				t.Fatalf("initialized = %v, want %v", initialized(database), tt.wantInitialized)
+			}
+			status := database.Status.Initialization
+			if status == nil {
+				return
+			}
+			if status.ScriptsChanged != tt.wantChanged {
+				t.Errorf("scripts changed = %v, want %v", status.ScriptsChanged, tt.wantChanged)
+			}
+			// the scripts only ran on instances initialized by this operator
+			if ran := status.CompletionTime != nil; ran == tt.wantChanged {
+				t.Errorf("completion time = %v", status.CompletionTime)
+			}
+			if events := len(recorder.Events); (events > 0) != tt.wantChanged {
+				t.Errorf("%d events recorded", events)
+			}
+		})
+	}
+}
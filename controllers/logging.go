@@ -0,0 +1,188 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	mariak8gv1beta1 "github.com/mariadb/mariadb.org-tools/mariadb-operator/api/v1beta1"
+)
+
+// logDir is shared by the server and the sidecars tailing its log files
+const logDir = "/var/log/mysql"
+
+// defaultMaxLogFileSize is the size logs are truncated at when the spec doesn't set one
+const defaultMaxLogFileSize = "100Mi"
+
+// logSidecarScript streams a log file and truncates it in place once it is
+// bigger than LOG_MAX_SIZE bytes. The server appends to its logs, so it
+// goes on writing from the start of the truncated file.
+const logSidecarScript = `trap 'exit 0' TERM
+tail -n+1 -F "$LOG_FILE" &
+while sleep 10; do
+  if [ "$(stat -c %s "$LOG_FILE" 2>/dev/null || echo 0)" -gt "$LOG_MAX_SIZE" ]; then
+    : > "$LOG_FILE"
+  fi
+done`
+
+// serverLog is a log file written by the server and the sidecar streaming it
+type serverLog struct {
+	sidecar string
+	file    string
+	// rotatedByServer logs are rotated by the server, the sidecar only streams them
+	rotatedByServer bool
+}
+
+var (
+	errorLog   = serverLog{sidecar: "error-log", file: logDir + "/error.log"}
+	slowLog    = serverLog{sidecar: "slow-log", file: logDir + "/slow.log"}
+	generalLog = serverLog{sidecar: "general-log", file: logDir + "/general.log"}
+)
+
+func maxLogFileSize(database mariak8gv1beta1.MariaDB) (resource.Quantity, error) {
+	size := defaultMaxLogFileSize
+	if database.Spec.Logging != nil && database.Spec.Logging.MaxFileSize != "" {
+		size = database.Spec.Logging.MaxFileSize
+	}
+	quantity, err := resource.ParseQuantity(size)
+	if err != nil || quantity.Sign() <= 0 {
+		return quantity, invalidSpecError(fmt.Sprintf("invalid logging maxFileSize %q", size))
+	}
+	return quantity, nil
+}
+
+func validateLogging(database mariak8gv1beta1.MariaDB) error {
+	_, err := maxLogFileSize(database)
+	return err
+}
+
+// logVolumeSizeLimit bounds the volume of the log files. Truncated logs can
+// grow past the maximum size between two checks of the sidecar, so each one
+// is given twice that size.
+func logVolumeSizeLimit(database mariak8gv1beta1.MariaDB) *resource.Quantity {
+	// validated before the reconcile gets here
+	max, _ := maxLogFileSize(database)
+	total := int64(0)
+	for _, log := range loggedFiles(database) {
+		if log.rotatedByServer {
+			total += auditFilesSize(database)
+		} else {
+			total += 2 * max.Value()
+		}
+	}
+	return resource.NewQuantity(total, resource.BinarySI)
+}
+
+func slowQueryLogEnabled(logging *mariak8gv1beta1.LoggingSpec) bool {
+	return logging.SlowQueryLog != nil && logging.SlowQueryLog.Enabled
+}
+
+// loggingArgs are the server options writing the selected logs to files.
+func loggingArgs(database mariak8gv1beta1.MariaDB) []string {
+	logging := database.Spec.Logging
+	if logging == nil {
+		return nil
+	}
+
+	var args []string
+	if logging.ErrorLog {
+		args = append(args, "--log-error="+errorLog.file)
+	}
+	if slowQueryLogEnabled(logging) {
+		slow := logging.SlowQueryLog
+		args = append(args, "--slow-query-log=ON", "--slow-query-log-file="+slowLog.file)
+		if slow.LongQueryTime != "" {
+			args = append(args, "--long-query-time="+slow.LongQueryTime)
+		}
+		if slow.MinExaminedRowLimit != nil {
+			args = append(args, fmt.Sprintf("--min-examined-row-limit=%d", *slow.MinExaminedRowLimit))
+		}
+		if slow.LogQueriesNotUsingIndexes {
+			args = append(args, "--log-queries-not-using-indexes=ON")
+		}
+	}
+	if logging.GeneralLog {
+		args = append(args, "--general-log=ON", "--general-log-file="+generalLog.file)
+	}
+	return args
+}
+
+// loggedFiles lists the log files written by the server.
+func loggedFiles(database mariak8gv1beta1.MariaDB) []serverLog {
+	var logs []serverLog
+	if auditToFile(database) {
+		logs = append(logs, auditLog)
+	}
+
+	logging := database.Spec.Logging
+	if logging == nil {
+		return logs
+	}
+
+	if logging.ErrorLog {
+		logs = append(logs, errorLog)
+	}
+	if slowQueryLogEnabled(logging) {
+		logs = append(logs, slowLog)
+	}
+	if logging.GeneralLog {
+		logs = append(logs, generalLog)
+	}
+	return logs
+}
+
+// logSidecars stream each log file to the stdout of their own container.
+func logSidecars(database mariak8gv1beta1.MariaDB) []corev1.Container {
+	image := "busybox:1.34"
+	if database.Spec.Logging != nil && database.Spec.Logging.SidecarImage != "" {
+		image = database.Spec.Logging.SidecarImage
+	}
+
+	max, _ := maxLogFileSize(database)
+
+	var containers []corev1.Container
+	for _, log := range loggedFiles(database) {
+		if log.rotatedByServer {
+			containers = append(containers, corev1.Container{
+				Name:    log.sidecar,
+				Image:   image,
+				Command: []string{"tail", "-n+1", "-F", log.file},
+				VolumeMounts: []corev1.VolumeMount{
+					{Name: "logs", MountPath: logDir, ReadOnly: true},
+				},
+			})
+			continue
+		}
+		containers = append(containers, corev1.Container{
+			Name:    log.sidecar,
+			Image:   image,
+			Command: []string{"sh", "-c", logSidecarScript},
+			Env: []corev1.EnvVar{
+				{Name: "LOG_FILE", Value: log.file},
+				{Name: "LOG_MAX_SIZE", Value: strconv.FormatInt(max.Value(), 10)},
+			},
+			VolumeMounts: []corev1.VolumeMount{
+				{Name: "logs", MountPath: logDir},
+			},
+		})
+	}
+	return containers
+}
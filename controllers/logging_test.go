@@ -0,0 +1,156 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"reflect"
+	"testing"
+
+	mariak8gv1beta1 "github.com/mariadb/mariadb.org-tools/mariadb-operator/api/v1beta1"
+)
+
+func TestLoggingArgs(t *testing.T) {
+	rows := int64(100)
+	tests := []struct {
+		name    string
+		logging *mariak8gv1beta1.LoggingSpec
+		want    []string
+	}{
+		{name: "no logging"},
+		{name: "nothing enabled", logging: &mariak8gv1beta1.LoggingSpec{SlowQueryLog: &mariak8gv1beta1.SlowQueryLogSpec{LongQueryTime: "2"}}},
+		{
+			name:    "error log",
+			logging: &mariak8gv1beta1.LoggingSpec{ErrorLog: true},
+			want:    []string{"--log-error=/var/log/mysql/error.log"},
+		},
+		{
+			name: "slow query log with thresholds",
+			logging: &mariak8gv1beta1.LoggingSpec{SlowQueryLog: &mariak8gv1beta1.SlowQueryLogSpec{
+				Enabled:                   true,
+				LongQueryTime:             "0.5",
+				MinExaminedRowLimit:       &rows,
+				LogQueriesNotUsingIndexes: true,
+			}},
+			want: []string{
+				"--slow-query-log=ON", "--slow-query-log-file=/var/log/mysql/slow.log",
+				"--long-query-time=0.5", "--min-examined-row-limit=100", "--log-queries-not-using-indexes=ON",
+			},
+		},
+		{
+			name:    "general log",
+			logging: &mariak8gv1beta1.LoggingSpec{GeneralLog: true},
+			want:    []string{"--general-log=ON", "--general-log-file=/var/log/mysql/general.log"},
+		},
+	}
+	for _, tt := range tests {
+		database := mariak8gv1beta1.MariaDB{Spec: mariak8gv1beta1.MariaDBSpec{Logging: tt.logging}}
+		if got := loggingArgs(database); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: loggingArgs() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestLogSidecars(t *testing.T) {
+	tests := []struct {
+		name      string
+		logging   mariak8gv1beta1.LoggingSpec
+		want      []string
+		wantImage string
+		wantMax   string
+		wantLimit string
+	}{
+		{
+			name:      "error log",
+			logging:   mariak8gv1beta1.LoggingSpec{ErrorLog: true},
+			want:      []string{"error-log"},
+			wantImage: "busybox:1.34",
+			wantMax:   "104857600",
+			wantLimit: "200Mi",
+		},
+		{
+			name: "all logs",
+			logging: mariak8gv1beta1.LoggingSpec{
+				ErrorLog:     true,
+				SlowQueryLog: &mariak8gv1beta1.SlowQueryLogSpec{Enabled: true},
+				GeneralLog:   true,
+				SidecarImage: "registry.local/busybox:1.35",
+				MaxFileSize:  "10Mi",
+			},
+			want:      []string{"error-log", "slow-log", "general-log"},
+			wantImage: "registry.local/busybox:1.35",
+			wantMax:   "10485760",
+			wantLimit: "60Mi",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logging := tt.logging
+			database := mariak8gv1beta1.MariaDB{Spec: mariak8gv1beta1.MariaDBSpec{Logging: &logging}}
+			if err := validateLogging(database); err != nil {
+				t.Fatal(err)
+			}
+
+			var names []string
+			for _, container := range logSidecars(database) {
+				names = append(names, container.Name)
+				if container.Image != tt.wantImage {
+					t.Errorf("%s image %q, want %q", container.Name, container.Image, tt.wantImage)
+				}
+				env := map[string]string{}
+				for _, e := range container.Env {
+					env[e.Name] = e.Value
+				}
+				if env["LOG_MAX_SIZE"] != tt.wantMax {
+					t.Errorf("%s truncates at %s bytes, want %s", container.Name, env["LOG_MAX_SIZE"], tt.wantMax)
+				}
+				if len(container.VolumeMounts) != 1 || container.VolumeMounts[0].MountPath != logDir {
+					t.Errorf("%s mounts %v", container.Name, container.VolumeMounts)
+				}
+			}
+			if !reflect.DeepEqual(names, tt.want) {
+				t.Errorf("sidecars %v, want %v", names, tt.want)
+			}
+			if got := logVolumeSizeLimit(database); got.String() != tt.wantLimit {
+				t.Errorf("log volume limited to %s, want %s", got.String(), tt.wantLimit)
+			}
+		})
+	}
+}
+
+func TestValidateLogging(t *testing.T) {
+	tests := []struct {
+		name    string
+		logging *mariak8gv1beta1.LoggingSpec
+		wantErr bool
+	}{
+		{name: "no logging"},
+		{name: "default size", logging: &mariak8gv1beta1.LoggingSpec{ErrorLog: true}},
+		{name: "size", logging: &mariak8gv1beta1.LoggingSpec{ErrorLog: true, MaxFileSize: "1Gi"}},
+		{name: "invalid size", logging: &mariak8gv1beta1.LoggingSpec{ErrorLog: true, MaxFileSize: "big"}, wantErr: true},
+		{name: "zero size", logging: &mariak8gv1beta1.LoggingSpec{ErrorLog: true, MaxFileSize: "0"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		database := mariak8gv1beta1.MariaDB{Spec: mariak8gv1beta1.MariaDBSpec{Logging: tt.logging}}
+		err := validateLogging(database)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: validateLogging() = %v, want error: %v", tt.name, err, tt.wantErr)
+		}
+		if _, ok := err.(invalidSpecError); err != nil && !ok {
+			t.Errorf("%s: error %v isn't an invalid spec", tt.name, err)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	mariak8gv1beta1 "github.com/mariadb/mariadb.org-tools/mariadb-operator/api/v1beta1"
+)
+
+const (
+	// podTemplateHashAnnotation records on the Deployment the pod template last applied
+	podTemplateHashAnnotation = "mariadb.org/pod-template-hash"
+	// applyNowAnnotation applies staged changes without waiting for the maintenance window
+	applyNowAnnotation = "mariadb.org/apply-now"
+)
+
+func podTemplateHash(template corev1.PodTemplateSpec) (string, error) {
+	data, err := json.Marshal(template)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8]), nil
+}
+
+// clockTime parses the HH:MM times of maintenance windows.
+func clockTime(value string) (int, int, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, 0, invalidSpecError(fmt.Sprintf("invalid maintenance window time %q", value))
+	}
+	return t.Hour(), t.Minute(), nil
+}
+
+func windowDay(window mariak8gv1beta1.MaintenanceWindow, day time.Weekday) bool {
+	if len(window.Days) == 0 {
+		return true
+	}
+	for _, d := range window.Days {
+		if string(d) == day.String() {
+			return true
+		}
+	}
+	return false
+}
+
+// maintenanceWindow tells if now is within one of the windows, and else
+// returns when the next one opens.
+func maintenanceWindow(spec mariak8gv1beta1.MaintenanceWindowSpec, now time.Time) (bool, time.Time, error) {
+	location, err := time.LoadLocation(spec.TimeZone)
+	if err != nil {
+		return false, time.Time{}, invalidSpecError(fmt.Sprintf("invalid maintenance window time zone %q: %v", spec.TimeZone, err))
+	}
+	now = now.In(location)
+
+	var next time.Time
+	for _, window := range spec.Windows {
+		startHour, startMinute, err := clockTime(window.Start)
+		if err != nil {
+			return false, time.Time{}, err
+		}
+		endHour, endMinute, err := clockTime(window.End)
+		if err != nil {
+			return false, time.Time{}, err
+		}
+		endDay := 0
+		if endHour*60+endMinute <= startHour*60+startMinute {
+			endDay = 1
+		}
+
+		// a window opened yesterday may still be open
+		for day := -1; day <= 7; day++ {
+			date := now.AddDate(0, 0, day)
+			opens := time.Date(date.Year(), date.Month(), date.Day(), startHour, startMinute, 0, 0, location)
+			closes := time.Date(date.Year(), date.Month(), date.Day()+endDay, endHour, endMinute, 0, 0, location)
+			if !windowDay(window, opens.Weekday()) {
+				continue
+			}
+			if !now.Before(opens) && now.Before(closes) {
+				return true, opens, nil
+			}
+			if opens.After(now) && (next.IsZero() || opens.Before(next)) {
+				next = opens
+			}
+		}
+	}
+	return false, next, nil
+}
+
+// stagePodChanges records the hash of the desired pod template on the
+// deployment. Outside the maintenance window, a changed template is replaced
+// by the live one so the pods aren't restarted, and the staged changes are
+// reported in the status. It returns how long until the window opens while
+// changes are staged.
+func (r *MariaDBReconciler) stagePodChanges(ctx context.Context, database *mariak8gv1beta1.MariaDB, deployment *appsv1.Deployment) (time.Duration, error) {
+	hash, err := podTemplateHash(deployment.Spec.Template)
+	if err != nil {
+		return 0, err
+	}
+	if deployment.Annotations == nil {
+		deployment.Annotations = map[string]string{}
+	}
+	deployment.Annotations[podTemplateHashAnnotation] = hash
+
+	spec := database.Spec.MaintenanceWindow
+	if spec == nil || database.Annotations[applyNowAnnotation] == "true" {
+		database.Status.PendingChanges = nil
+		return 0, nil
+	}
+
+	var live appsv1.Deployment
+	err = r.Get(ctx, types.NamespacedName{Namespace: database.Namespace, Name: deploymentName(*database)}, &live)
+	if err != nil {
+		// a new instance has no pods to disrupt
+		database.Status.PendingChanges = nil
+		return 0, ignoreNotFound(err)
+	}
+	applied, ok := live.Annotations[podTemplateHashAnnotation]
+	if !ok || applied == hash {
+		database.Status.PendingChanges = nil
+		return 0, nil
+	}
+
+	now := time.Now()
+	open, next, err := maintenanceWindow(*spec, now)
+	if err != nil || open {
+		database.Status.PendingChanges = nil
+		return 0, err
+	}
+
+	deployment.Spec.Template = live.Spec.Template
+	deployment.Annotations[podTemplateHashAnnotation] = applied
+
+	pending := database.Status.PendingChanges
+	if pending == nil || pending.TemplateHash != hash {
+		since := metav1.NewTime(now)
+		pending = &mariak8gv1beta1.PendingChangesStatus{TemplateHash: hash, Since: &since}
+		r.Recorder.Eventf(database, corev1.EventTypeNormal, EventReasonChangesStaged,
+			"pod changes staged until the maintenance window opening at %s", next.Format(time.RFC3339))
+	}
+	nextWindow := metav1.NewTime(next)
+	pending.NextWindow = &nextWindow
+	database.Status.PendingChanges = pending
+	return next.Sub(now), nil
+}
+
+// clearApplyNow removes the apply-now annotation once the changes it let
+// through are applied, so later changes wait for the window again. A copy is
+// patched, the in-memory status of the reconcile is kept for its update.
+func (r *MariaDBReconciler) clearApplyNow(ctx context.Context, database *mariak8gv1beta1.MariaDB) error {
+	if _, ok := database.Annotations[applyNowAnnotation]; !ok {
+		return nil
+	}
+	patched := database.DeepCopy()
+	patch := client.MergeFrom(database.DeepCopy())
+	delete(patched.Annotations, applyNowAnnotation)
+	if err := r.Patch(ctx, patched, patch); err != nil {
+		return err
+	}
+	database.Annotations = patched.Annotations
+	database.ResourceVersion = patched.ResourceVersion
+	return nil
+}
@@ -0,0 +1,158 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	mariak8gv1beta1 "github.com/mariadb/mariadb.org-tools/mariadb-operator/api/v1beta1"
+)
+
+func TestClockTime(t *testing.T) {
+	tests := []struct {
+		value        string
+		hour, minute int
+		wantErr      bool
+	}{
+		{value: "00:00"},
+		{value: "02:30", hour: 2, minute: 30},
+		{value: "23:59", hour: 23, minute: 59},
+		{value: "24:00", wantErr: true},
+		{value: "12:60", wantErr: true},
+		{value: "noon", wantErr: true},
+		{value: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		hour, minute, err := clockTime(tt.value)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("clockTime(%q) error = %v, want error: %v", tt.value, err, tt.wantErr)
+			continue
+		}
+		if _, ok := err.(invalidSpecError); err != nil && !ok {
+			t.Errorf("clockTime(%q) error %v isn't an invalid spec", tt.value, err)
+		}
+		if hour != tt.hour || minute != tt.minute {
+			t.Errorf("clockTime(%q) = %02d:%02d, want %02d:%02d", tt.value, hour, minute, tt.hour, tt.minute)
+		}
+	}
+}
+
+func TestMaintenanceWindow(t *testing.T) {
+	// October 19 2021 is a Tuesday
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2021, time.October, day, hour, minute, 0, 0, time.UTC)
+	}
+	nightly := []mariak8gv1beta1.MaintenanceWindow{{Start: "02:00", End: "04:00"}}
+	overnight := []mariak8gv1beta1.MaintenanceWindow{{Start: "23:00", End: "01:00"}}
+
+	tests := []struct {
+		name     string
+		spec     mariak8gv1beta1.MaintenanceWindowSpec
+		now      time.Time
+		wantOpen bool
+		wantTime time.Time
+		wantErr  bool
+	}{
+		{
+			name:     "within the window",
+			spec:     mariak8gv1beta1.MaintenanceWindowSpec{Windows: nightly},
+			now:      at(19, 3, 0),
+			wantOpen: true,
+			wantTime: at(19, 2, 0),
+		},
+		{
+			name:     "at the opening",
+			spec:     mariak8gv1beta1.MaintenanceWindowSpec{Windows: nightly},
+			now:      at(19, 2, 0),
+			wantOpen: true,
+			wantTime: at(19, 2, 0),
+		},
+		{
+			name:     "at the closing",
+			spec:     mariak8gv1beta1.MaintenanceWindowSpec{Windows: nightly},
+			now:      at(19, 4, 0),
+			wantTime: at(20, 2, 0),
+		},
+		{
+			name:     "overnight window opened the day before",
+			spec:     mariak8gv1beta1.MaintenanceWindowSpec{Windows: overnight},
+			now:      at(20, 0, 30),
+			wantOpen: true,
+			wantTime: at(19, 23, 0),
+		},
+		{
+			name: "overnight window opened on a listed day",
+			spec: mariak8gv1beta1.MaintenanceWindowSpec{Windows: []mariak8gv1beta1.MaintenanceWindow{
+				{Days: []mariak8gv1beta1.Weekday{"Monday"}, Start: "23:00", End: "01:00"},
+			}},
+			now:      at(19, 0, 30),
+			wantOpen: true,
+			wantTime: at(18, 23, 0),
+		},
+		{
+			name: "next listed day",
+			spec: mariak8gv1beta1.MaintenanceWindowSpec{Windows: []mariak8gv1beta1.MaintenanceWindow{
+				{Days: []mariak8gv1beta1.Weekday{"Saturday"}, Start: "02:00", End: "04:00"},
+			}},
+			now:      at(19, 3, 0),
+			wantTime: at(23, 2, 0),
+		},
+		{
+			name: "earliest of several windows",
+			spec: mariak8gv1beta1.MaintenanceWindowSpec{Windows: []mariak8gv1beta1.MaintenanceWindow{
+				{Days: []mariak8gv1beta1.Weekday{"Saturday"}, Start: "02:00", End: "04:00"},
+				{Days: []mariak8gv1beta1.Weekday{"Thursday"}, Start: "12:00", End: "13:00"},
+			}},
+			now:      at(19, 3, 0),
+			wantTime: at(21, 12, 0),
+		},
+		{
+			name:     "time zone",
+			spec:     mariak8gv1beta1.MaintenanceWindowSpec{TimeZone: "Europe/Helsinki", Windows: nightly},
+			now:      at(19, 0, 0),
+			wantOpen: true,
+			wantTime: at(18, 23, 0),
+		},
+		{
+			name:    "unknown time zone",
+			spec:    mariak8gv1beta1.MaintenanceWindowSpec{TimeZone: "Mars/Olympus_Mons", Windows: nightly},
+			now:     at(19, 3, 0),
+			wantErr: true,
+		},
+		{
+			name:    "invalid time",
+			spec:    mariak8gv1beta1.MaintenanceWindowSpec{Windows: []mariak8gv1beta1.MaintenanceWindow{{Start: "02:00", End: "4am"}}},
+			now:     at(19, 3, 0),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			open, next, err := maintenanceWindow(tt.spec, tt.now)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, want error: %v", err, tt.wantErr)
+			}
+			if open != tt.wantOpen {
+				t.Errorf("open = %v, want %v", open, tt.wantOpen)
+			}
+			if !next.Equal(tt.wantTime) {
+				t.Errorf("window time = %s, want %s", next, tt.wantTime)
+			}
+		})
+	}
+}
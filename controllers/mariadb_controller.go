@@ -18,17 +18,29 @@ package controllers
 
 import (
 	"context"
+	"time"
 
 	"github.com/go-logr/logr"
 	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
-	mariak8gv1alpha1 "github.com/mariadb/mariadb.org-tools/mariadb-operator/api/v1alpha1"
+	mariak8gv1beta1 "github.com/mariadb/mariadb.org-tools/mariadb-operator/api/v1beta1"
 )
 
 func ignoreNotFound(err error) error {
@@ -38,78 +50,345 @@ func ignoreNotFound(err error) error {
 	return err
 }
 
+// invalidSpecError is a problem with the MariaDB spec that retrying won't solve
+type invalidSpecError string
+
+func (e invalidSpecError) Error() string {
+	return string(e)
+}
+
+func ignoreAlreadyExists(err error) error {
+	if errors.IsAlreadyExists(err) {
+		return nil
+	}
+	return err
+}
+
 // MariaDBReconciler reconciles a MariaDB object
 type MariaDBReconciler struct {
 	client.Client
 	Scheme *runtime.Scheme
 	Log    logr.Logger
+
+	// Config is used to exec into the instance pods
+	Config *rest.Config
+
+	// Recorder records lifecycle events on the MariaDB objects
+	Recorder record.EventRecorder
+
+	// OperatorNamespace is where the operator runs, used to admit it in network policies
+	OperatorNamespace string
+
+	// APIReader reads cluster scoped objects without caching them, as the
+	// operator may only be allowed to get them when watching some namespaces
+	APIReader client.Reader
+
+	// WatchNamespaces are the namespaces the manager watches, all of them
+	// when empty
+	WatchNamespaces []string
+
+	// ConnectionSecretNamespaces selects the namespaces allowed to receive
+	// copies of connection Secrets, none when nil
+	ConnectionSecretNamespaces labels.Selector
+
+	// CloneSourceNamespaces selects the namespaces instances of other
+	// namespaces can be cloned from, none when nil
+	CloneSourceNamespaces labels.Selector
+
+	// BackupImage is the manager image, run by the dump backup Jobs and the
+	// restores of dump backups, which fail when it is empty
+	BackupImage string
 }
 
+// progressRequeue is how often version upgrades and volume resizes are checked
+const progressRequeue = 10 * time.Second
+
 //+kubebuilder:rbac:groups=mariak8g.mariadb.org,resources=mariadbs,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=mariak8g.mariadb.org,resources=mariadbs/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=mariak8g.mariadb.org,resources=mariadbs/finalizers,verbs=update
+//+kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=core,resources=services,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=networking.k8s.io,resources=networkpolicies,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch
+//+kubebuilder:rbac:groups=core,resources=pods/exec,verbs=create
+//+kubebuilder:rbac:groups=core,resources=persistentvolumeclaims,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=storage.k8s.io,resources=storageclasses,verbs=get;list;watch
+//+kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=core,resources=configmaps,verbs=get;list;watch
+//+kubebuilder:rbac:groups=monitoring.coreos.com,resources=servicemonitors,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=core,resources=events,verbs=create;patch
+//+kubebuilder:rbac:groups=core,resources=namespaces,verbs=get;list;watch
+//+kubebuilder:rbac:groups=snapshot.storage.k8s.io,resources=volumesnapshots,verbs=get;list;watch;create;delete
+//+kubebuilder:rbac:groups=batch,resources=jobs,verbs=get;list;watch;create;delete
 
-func (r *MariaDBReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+func (r *MariaDBReconciler) Reconcile(ctx context.Context, req ctrl.Request) (_ ctrl.Result, reconcileErr error) {
 
 	log := r.Log.WithValues("MariaDB: ", req.NamespacedName)
 
-	var app mariak8gv1alpha1.MariaDB // fetch resource
+	var app mariak8gv1beta1.MariaDB // fetch resource
 	log.Info("Reconciling MariaDB kind", "mariadb", app.Name)
 
 	if err := r.Get(ctx, req.NamespacedName, &app); err != nil {
 		// it might be not found if this is a delete request
 		if ignoreNotFound(err) == nil {
+			deleteInstanceMetrics(req.Namespace, req.Name)
 			log.Info("Reconciled MariaDB kind after delete")
 			return ctrl.Result{}, nil
 		}
 		log.Error(err, "unable to fetch MariaDB")
 		return ctrl.Result{}, err
 	}
+	if deleting, err := r.reconcileConnectionSecretsFinalizer(ctx, &app); err != nil || deleting {
+		return ctrl.Result{}, r.recordError(app, stageApply, err)
+	}
+
+	previousPhase := app.Status.DbState
+	previousGeneration := app.Status.ObservedGeneration
+	defer func() {
+		recordInstanceMetrics(app)
+		// every successful reconcile saved the status, a failed one is
+		// retried and would repeat the events
+		if reconcileErr == nil {
+			r.recordStatusEvents(&app, previousPhase, previousGeneration)
+		}
+	}()
+
+	if reason := pauseReason(app); reason != "" {
+		r.setPausedCondition(&app, reason)
+		if err := r.scaleDownPaused(ctx, app); err != nil {
+			return ctrl.Result{}, r.recordError(app, stageApply, err)
+		}
+		if err := r.reconcileHealth(ctx, &app); err != nil {
+			log.Error(err, "unable to check the server health")
+		}
+		if err := r.Status().Update(ctx, &app); err != nil {
+			return ctrl.Result{}, r.recordError(app, stageStatusUpdate, err)
+		}
+		log.Info("Reconciliation of MariaDB kind is paused", "mariadb", app.Name)
+		return ctrl.Result{RequeueAfter: healthRequeue(app)}, nil
+	}
+	r.setPausedCondition(&app, "")
+
+	if app.Status.CurrentVersion != "" {
+		if err := validateVersionChange(app.Status.CurrentVersion, mariadbVersion(app)); err != nil {
+			return r.failReconcile(ctx, &app, err)
+		}
+	}
+
+	if err := validateReplicas(app); err != nil {
+		return r.failReconcile(ctx, &app, err)
+	}
+	if err := validateInitScripts(app); err != nil {
+		return r.failReconcile(ctx, &app, err)
+	}
+	if err := validateLogging(app); err != nil {
+		return r.failReconcile(ctx, &app, err)
+	}
+	if err := validateBackup(app); err != nil {
+		return r.failReconcile(ctx, &app, err)
+	}
+
+	resizing, err := r.reconcileStorage(ctx, &app)
+	if _, ok := err.(invalidSpecError); ok {
+		return r.failReconcile(ctx, &app, err)
+	}
+	if err != nil {
+		return ctrl.Result{}, r.recordError(app, stageApply, err)
+	}
+
+	exporterPassword := ""
+	if metricsEnabled(app) {
+		exporterPassword, err = r.ensureExporterSecret(ctx, app)
+		if err != nil {
+			return ctrl.Result{}, r.recordError(app, stageApply, err)
+		}
+	}
 
 	deployment, err := r.desiredDeployment(app)
 	if err == nil {
-		app.Status.DbState = mariak8gv1alpha1.RunningStatusPhase
+		app.Status.DbState = mariak8gv1beta1.RunningStatusPhase
 		app.Status.ShowState = string(app.Status.DbState)
 	}
 
 	// return if there is an error during deployment start
 	if err != nil {
-		return ctrl.Result{}, err
+		return ctrl.Result{}, r.recordError(app, stageBuild, err)
+	}
+
+	// the source is only needed until the clone is initialized
+	var cloneSource *mariak8gv1beta1.MariaDB
+	if cloneSpec(app) != nil && !initialized(app) {
+		cloneSource, err = r.cloneSource(ctx, app)
+		if _, ok := err.(invalidSpecError); ok {
+			return r.failReconcile(ctx, &app, err)
+		}
+		if err != nil {
+			return ctrl.Result{}, r.recordError(app, stageApply, err)
+		}
+		if err := r.ensureCloneCredentials(ctx, app, *cloneSource); err != nil {
+			return ctrl.Result{}, r.recordError(app, stageApply, err)
+		}
+	}
+	if cloneSpec(app) != nil {
+		addCloneContainer(app, &deployment)
+	}
+	if err := r.addRestoreContainer(app, &deployment.Spec.Template.Spec); err != nil {
+		return ctrl.Result{}, r.recordError(app, stageBuild, err)
+	}
+
+	windowWait, err := r.stagePodChanges(ctx, &app, &deployment)
+	if _, ok := err.(invalidSpecError); ok {
+		return r.failReconcile(ctx, &app, err)
+	}
+	if err != nil {
+		return ctrl.Result{}, r.recordError(app, stageApply, err)
 	}
 
 	svc, err := r.desiredService(app)
 	// return if there is an error during service start
 	if err != nil {
-		return ctrl.Result{}, err
+		return ctrl.Result{}, r.recordError(app, stageBuild, err)
 	}
 
 	applyOpts := []client.PatchOption{client.ForceOwnership, client.FieldOwner("mariadb-controller")}
 
-	err = r.Patch(ctx, &deployment, client.Apply, applyOpts...)
+	err = r.applyOwned(ctx, app, &deployment, applyOpts...)
 	if err != nil {
-		return ctrl.Result{}, err
+		return ctrl.Result{}, r.recordError(app, stageApply, err)
+	}
+	if err := r.clearApplyNow(ctx, &app); err != nil {
+		return ctrl.Result{}, r.recordError(app, stageApply, err)
 	}
 
-	err = r.Patch(ctx, &svc, client.Apply, applyOpts...)
+	err = r.applyOwned(ctx, app, &svc, applyOpts...)
 	if err != nil {
-		return ctrl.Result{}, err
+		return ctrl.Result{}, r.recordError(app, stageApply, err)
+	}
+
+	connection, err := r.desiredConnectionSecret(app)
+	if err != nil {
+		return ctrl.Result{}, r.recordError(app, stageBuild, err)
+	}
+	if err := r.Patch(ctx, &connection, client.Apply, applyOpts...); err != nil {
+		return ctrl.Result{}, r.recordError(app, stageApply, err)
+	}
+	app.Status.Binding = &corev1.LocalObjectReference{Name: connection.Name}
+	err = r.reconcileConnectionSecrets(ctx, &app, connection, applyOpts...)
+	if _, ok := err.(invalidSpecError); ok {
+		return r.failReconcile(ctx, &app, err)
+	}
+	if err != nil {
+		return ctrl.Result{}, r.recordError(app, stageApply, err)
+	}
+
+	app.Status.ObservedGeneration = app.Generation
+
+	if app.Spec.NetworkPolicy != nil && app.Spec.NetworkPolicy.Enabled {
+		np, err := r.desiredNetworkPolicy(app)
+		if err != nil {
+			return ctrl.Result{}, r.recordError(app, stageBuild, err)
+		}
+		err = r.Patch(ctx, &np, client.Apply, applyOpts...)
+		if err != nil {
+			return ctrl.Result{}, r.recordError(app, stageApply, err)
+		}
+	} else {
+		np := networkingv1.NetworkPolicy{
+			ObjectMeta: metav1.ObjectMeta{Name: networkPolicyName(app), Namespace: app.Namespace},
+		}
+		if err := r.Delete(ctx, &np); ignoreNotFound(err) != nil {
+			return ctrl.Result{}, r.recordError(app, stageApply, err)
+		}
+	}
+
+	if err := r.reconcileReplicas(ctx, &app, deployment); err != nil {
+		return ctrl.Result{}, r.recordError(app, stageApply, err)
+	}
+
+	cloned, err := r.reconcileClone(ctx, &app, cloneSource, deploymentRolledOut(deployment))
+	if err != nil {
+		return ctrl.Result{}, r.recordError(app, stageApply, err)
+	}
+
+	upgrading, err := r.reconcileUpgrade(ctx, &app, deployment)
+	if err != nil {
+		return ctrl.Result{}, r.recordError(app, stageApply, err)
+	}
+
+	if err := r.reconcileExporter(ctx, &app, exporterPassword); err != nil {
+		return ctrl.Result{}, r.recordError(app, stageApply, err)
+	}
+
+	if err := r.reconcileMaxScale(ctx, &app, applyOpts...); err != nil {
+		return ctrl.Result{}, r.recordError(app, stageApply, err)
+	}
+
+	app.Status.AuditPluginActive, err = r.auditPluginActive(ctx, app)
+	if err != nil {
+		log.Error(err, "unable to check the audit plugin")
+	}
+
+	if err := r.reconcileHealth(ctx, &app); err != nil {
+		log.Error(err, "unable to check the server health")
+	}
+
+	backupWait, err := r.reconcileBackup(ctx, &app)
+	if err != nil {
+		log.Error(err, "unable to reconcile the backups")
+	}
+
+	if err := r.reconcileInitialization(ctx, &app, cloned && deploymentRolledOut(deployment)); err != nil {
+		log.Error(err, "unable to check the init scripts")
+	}
+
+	if app.Status.ReadyTime == nil && deploymentRolledOut(deployment) {
+		now := metav1.Now()
+		app.Status.ReadyTime = &now
 	}
 
 	if err := r.Status().Update(ctx, &app); err != nil {
 		log.Error(err, "unable to update the variable status")
-		return ctrl.Result{}, err
+		return ctrl.Result{}, r.recordError(app, stageStatusUpdate, err)
 	}
 
 	log.Info("Reconciled MariaDB kind", "mariadb", app.Name, "status", app.Status)
 
-	return ctrl.Result{}, nil
+	if upgrading || resizing || !cloned {
+		return ctrl.Result{RequeueAfter: progressRequeue}, nil
+	}
+	// come back for the next health check, when the maintenance window opens
+	// or for the next backup
+	requeue := healthRequeue(app)
+	for _, wait := range []time.Duration{windowWait, backupWait} {
+		if wait > 0 && wait < requeue {
+			requeue = wait
+		}
+	}
+	return ctrl.Result{RequeueAfter: requeue}, nil
+}
+
+// failReconcile puts the instance in the error state with the reason, without retrying.
+func (r *MariaDBReconciler) failReconcile(ctx context.Context, app *mariak8gv1beta1.MariaDB, err error) (ctrl.Result, error) {
+	app.Status.DbState = mariak8gv1beta1.ErrorStatusPhase
+	app.Status.ShowState = string(app.Status.DbState)
+	app.Status.LastMessage = err.Error()
+	r.Recorder.Event(app, corev1.EventTypeWarning, EventReasonInvalidSpec, err.Error())
+	return ctrl.Result{}, r.recordError(*app, stageStatusUpdate, r.Status().Update(ctx, app))
 }
 
 // SetupWithManager sets up the controller with the Manager.
-func (r *MariaDBReconciler) SetupWithManager(mgr ctrl.Manager) error {
+func (r *MariaDBReconciler) SetupWithManager(mgr ctrl.Manager, options controller.Options) error {
 	return ctrl.NewControllerManagedBy(mgr).
-		For(&mariak8gv1alpha1.MariaDB{}).
+		WithOptions(options).
+		// status updates don't change the generation, the pause and apply-now
+		// annotations do not either
+		For(&mariak8gv1beta1.MariaDB{}, builder.WithPredicates(predicate.Or(predicate.GenerationChangedPredicate{}, predicate.AnnotationChangedPredicate{}))).
 		Owns(&corev1.Service{}).
 		Owns(&appsv1.Deployment{}).
+		Owns(&networkingv1.NetworkPolicy{}).
+		Owns(&corev1.PersistentVolumeClaim{}).
+		Owns(&corev1.Secret{}).
+		Owns(&batchv1.Job{}).
+		Watches(&source.Kind{Type: &corev1.Secret{}}, handler.EnqueueRequestsFromMapFunc(connectionSecretCopy)).
 		Complete(r)
 }
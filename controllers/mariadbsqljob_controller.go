@@ -0,0 +1,461 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/go-logr/logr"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	mariak8gv1alpha1 "github.com/mariadb/mariadb.org-tools/mariadb-operator/api/v1alpha1"
+	mariak8gv1beta1 "github.com/mariadb/mariadb.org-tools/mariadb-operator/api/v1beta1"
+)
+
+const (
+	sqlJobLabel     = "mariadbsqljob"
+	sqlJobScriptKey = "job.sql"
+	sqlJobScriptDir = "/sql"
+	// sqlJobPasswordKey is the key of the root password in the credentials Secret of a job
+	sqlJobPasswordKey = "password"
+	// sqlHashAnnotation records on a one-shot Job the SQL it runs
+	sqlHashAnnotation = "mariadb.org/sql-hash"
+
+	// sqlJobRequeue is how often a job waiting for its instance or dependencies is retried
+	sqlJobRequeue = 15 * time.Second
+)
+
+// sqlJobCommand runs the script with the mariadb client and keeps the tail
+// of its output as the container termination message. The client reads the
+// password from MYSQL_PWD, so it doesn't show in the process arguments.
+const sqlJobCommand = `mariadb --host="$MARIADB_HOST" --port="$MARIADB_PORT" --user=root "$MARIADB_DATABASE" < ` +
+	sqlJobScriptDir + "/" + sqlJobScriptKey + ` > /tmp/output 2>&1; rc=$?; tail -c 2048 /tmp/output | tee /dev/termination-log; exit $rc`
+
+// MariaDBSQLJobReconciler reconciles a MariaDBSQLJob object
+type MariaDBSQLJobReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+	Log    logr.Logger
+}
+
+//+kubebuilder:rbac:groups=mariak8g.mariadb.org,resources=mariadbsqljobs,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=mariak8g.mariadb.org,resources=mariadbsqljobs/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=mariak8g.mariadb.org,resources=mariadbsqljobs/finalizers,verbs=update
+//+kubebuilder:rbac:groups=batch,resources=jobs;cronjobs,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=core,resources=configmaps,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch
+
+func (r *MariaDBSQLJobReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+
+	log := r.Log.WithValues("MariaDBSQLJob: ", req.NamespacedName)
+
+	var sqlJob mariak8gv1alpha1.MariaDBSQLJob
+	if err := r.Get(ctx, req.NamespacedName, &sqlJob); err != nil {
+		if ignoreNotFound(err) == nil {
+			log.Info("Reconciled MariaDBSQLJob kind after delete")
+			return ctrl.Result{}, nil
+		}
+		log.Error(err, "unable to fetch MariaDBSQLJob")
+		return ctrl.Result{}, err
+	}
+
+	var database mariak8gv1beta1.MariaDB
+	dbKey := types.NamespacedName{Namespace: sqlJob.Namespace, Name: sqlJob.Spec.MariaDBRef.Name}
+	if err := r.Get(ctx, dbKey, &database); err != nil {
+		if ignoreNotFound(err) != nil {
+			return ctrl.Result{}, err
+		}
+		return r.pending(ctx, &sqlJob, fmt.Sprintf("MariaDB %q not found", dbKey.Name))
+	}
+
+	for _, dep := range sqlJob.Spec.DependsOn {
+		var other mariak8gv1alpha1.MariaDBSQLJob
+		if err := r.Get(ctx, types.NamespacedName{Namespace: sqlJob.Namespace, Name: dep.Name}, &other); err != nil {
+			if ignoreNotFound(err) != nil {
+				return ctrl.Result{}, err
+			}
+			return r.pending(ctx, &sqlJob, fmt.Sprintf("waiting for MariaDBSQLJob %q to be created", dep.Name))
+		}
+		if other.Status.Phase != mariak8gv1alpha1.SucceededSQLJobPhase {
+			return r.pending(ctx, &sqlJob, fmt.Sprintf("waiting for MariaDBSQLJob %q to succeed", dep.Name))
+		}
+	}
+
+	if sqlJob.Spec.SQL == "" && sqlJob.Spec.SQLConfigMapKeyRef == nil {
+		sqlJob.Status.Phase = mariak8gv1alpha1.FailedSQLJobPhase
+		sqlJob.Status.LastMessage = "one of sql or sqlConfigMapKeyRef has to be set"
+		return ctrl.Result{}, r.Status().Update(ctx, &sqlJob)
+	}
+
+	scriptVolume, err := r.scriptVolume(ctx, sqlJob)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	if err := r.applyCredentials(ctx, sqlJob, database); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	template := desiredSQLJobTemplate(sqlJob, database, scriptVolume)
+
+	if sqlJob.Spec.Schedule == "" {
+		// the job was scheduled before, its runs are done by the one-shot Job now
+		cronJob := batchv1.CronJob{ObjectMeta: metav1.ObjectMeta{Name: sqlJobName(sqlJob), Namespace: sqlJob.Namespace}}
+		if err := r.Delete(ctx, &cronJob, client.PropagationPolicy(metav1.DeletePropagationBackground)); ignoreNotFound(err) != nil {
+			return ctrl.Result{}, err
+		}
+
+		job := desiredSQLJob(sqlJob, template)
+		if err := ctrl.SetControllerReference(&sqlJob, &job, r.Scheme); err != nil {
+			return ctrl.Result{}, err
+		}
+		rerun, err := r.ensureOneShotJob(ctx, job)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		if rerun {
+			// created again once the deletion of the previous run is seen
+			return r.pending(ctx, &sqlJob, "the SQL changed, running it again")
+		}
+	} else {
+		// the job was one-shot before, its last run would be reported as the newest one
+		job := batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: sqlJobName(sqlJob), Namespace: sqlJob.Namespace}}
+		if err := r.Delete(ctx, &job, client.PropagationPolicy(metav1.DeletePropagationBackground)); ignoreNotFound(err) != nil {
+			return ctrl.Result{}, err
+		}
+
+		cronJob := desiredSQLCronJob(sqlJob, template)
+		if err := ctrl.SetControllerReference(&sqlJob, &cronJob, r.Scheme); err != nil {
+			return ctrl.Result{}, err
+		}
+		applyOpts := []client.PatchOption{client.ForceOwnership, client.FieldOwner("mariadbsqljob-controller")}
+		if err := r.Patch(ctx, &cronJob, client.Apply, applyOpts...); err != nil {
+			return ctrl.Result{}, err
+		}
+		sqlJob.Status.LastScheduleTime = cronJob.Status.LastScheduleTime
+	}
+
+	if err := r.updateRunStatus(ctx, &sqlJob); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	if err := r.Status().Update(ctx, &sqlJob); err != nil {
+		log.Error(err, "unable to update the variable status")
+		return ctrl.Result{}, err
+	}
+
+	log.Info("Reconciled MariaDBSQLJob kind", "mariadbsqljob", sqlJob.Name, "status", sqlJob.Status)
+
+	return ctrl.Result{}, nil
+}
+
+func sqlJobName(sqlJob mariak8gv1alpha1.MariaDBSQLJob) string {
+	return sqlJob.Name + "-sqljob"
+}
+
+func sqlJobCredentialsName(sqlJob mariak8gv1alpha1.MariaDBSQLJob) string {
+	return sqlJob.Name + "-sqljob-credentials"
+}
+
+// desiredSQLJob is the Job of a one-shot job, annotated with the hash of its SQL.
+func desiredSQLJob(sqlJob mariak8gv1alpha1.MariaDBSQLJob, template batchv1.JobTemplateSpec) batchv1.Job {
+	return batchv1.Job{
+		TypeMeta: metav1.TypeMeta{APIVersion: batchv1.SchemeGroupVersion.String(), Kind: "Job"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        sqlJobName(sqlJob),
+			Namespace:   sqlJob.Namespace,
+			Labels:      template.Labels,
+			Annotations: map[string]string{sqlHashAnnotation: sqlHash(sqlJob)},
+		},
+		Spec: template.Spec,
+	}
+}
+
+// desiredSQLCronJob is the CronJob of a scheduled job, runs never overlap.
+func desiredSQLCronJob(sqlJob mariak8gv1alpha1.MariaDBSQLJob, template batchv1.JobTemplateSpec) batchv1.CronJob {
+	return batchv1.CronJob{
+		TypeMeta: metav1.TypeMeta{APIVersion: batchv1.SchemeGroupVersion.String(), Kind: "CronJob"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      sqlJobName(sqlJob),
+			Namespace: sqlJob.Namespace,
+		},
+		Spec: batchv1.CronJobSpec{
+			Schedule:          sqlJob.Spec.Schedule,
+			ConcurrencyPolicy: batchv1.ForbidConcurrent,
+			JobTemplate:       template,
+		},
+	}
+}
+
+// sqlHash identifies the SQL of a job. Only the reference to a ConfigMap is
+// hashed, edits of the ConfigMap itself don't run the job again.
+func sqlHash(sqlJob mariak8gv1alpha1.MariaDBSQLJob) string {
+	source := "sql:" + sqlJob.Spec.SQL
+	if ref := sqlJob.Spec.SQLConfigMapKeyRef; sqlJob.Spec.SQL == "" && ref != nil {
+		source = "configMap:" + ref.Name + "/" + ref.Key
+	}
+	sum := sha256.Sum256([]byte(source))
+	return hex.EncodeToString(sum[:8])
+}
+
+// ensureOneShotJob creates the Job of a one-shot job. The pod template of a
+// Job is immutable, so when the SQL changed the previous run is deleted, even
+// when it is still running, and true is returned. Jobs created before the
+// hash was recorded are adopted as they are rather than run again.
+func (r *MariaDBSQLJobReconciler) ensureOneShotJob(ctx context.Context, job batchv1.Job) (bool, error) {
+	var live batchv1.Job
+	err := r.Get(ctx, types.NamespacedName{Namespace: job.Namespace, Name: job.Name}, &live)
+	if ignoreNotFound(err) != nil {
+		return false, err
+	}
+	if err != nil {
+		return false, ignoreAlreadyExists(r.Create(ctx, &job))
+	}
+
+	hash := job.Annotations[sqlHashAnnotation]
+	switch live.Annotations[sqlHashAnnotation] {
+	case hash:
+		return false, nil
+	case "":
+		patch := client.MergeFrom(live.DeepCopy())
+		if live.Annotations == nil {
+			live.Annotations = map[string]string{}
+		}
+		live.Annotations[sqlHashAnnotation] = hash
+		return false, r.Patch(ctx, &live, patch)
+	}
+	err = r.Delete(ctx, &live, client.PropagationPolicy(metav1.DeletePropagationBackground))
+	return true, ignoreNotFound(err)
+}
+
+// pending records why the job can't run yet and retries later.
+func (r *MariaDBSQLJobReconciler) pending(ctx context.Context, sqlJob *mariak8gv1alpha1.MariaDBSQLJob, msg string) (ctrl.Result, error) {
+	sqlJob.Status.Phase = mariak8gv1alpha1.PendingSQLJobPhase
+	sqlJob.Status.LastMessage = msg
+	if err := r.Status().Update(ctx, sqlJob); err != nil {
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{RequeueAfter: sqlJobRequeue}, nil
+}
+
+// scriptVolume returns the volume exposing the SQL as sqlJobScriptKey, creating
+// an owned ConfigMap for inline SQL.
+func (r *MariaDBSQLJobReconciler) scriptVolume(ctx context.Context, sqlJob mariak8gv1alpha1.MariaDBSQLJob) (corev1.Volume, error) {
+	volume := corev1.Volume{Name: "sql"}
+
+	if sqlJob.Spec.SQL == "" {
+		ref := sqlJob.Spec.SQLConfigMapKeyRef
+		volume.ConfigMap = &corev1.ConfigMapVolumeSource{
+			LocalObjectReference: ref.LocalObjectReference,
+			Items:                []corev1.KeyToPath{{Key: ref.Key, Path: sqlJobScriptKey}},
+		}
+		return volume, nil
+	}
+
+	cm := corev1.ConfigMap{
+		TypeMeta: metav1.TypeMeta{APIVersion: corev1.SchemeGroupVersion.String(), Kind: "ConfigMap"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      sqlJob.Name + "-sqljob-script",
+			Namespace: sqlJob.Namespace,
+		},
+		Data: map[string]string{sqlJobScriptKey: sqlJob.Spec.SQL},
+	}
+	if err := ctrl.SetControllerReference(&sqlJob, &cm, r.Scheme); err != nil {
+		return volume, err
+	}
+	applyOpts := []client.PatchOption{client.ForceOwnership, client.FieldOwner("mariadbsqljob-controller")}
+	if err := r.Patch(ctx, &cm, client.Apply, applyOpts...); err != nil {
+		return volume, err
+	}
+
+	volume.ConfigMap = &corev1.ConfigMapVolumeSource{LocalObjectReference: corev1.LocalObjectReference{Name: cm.Name}}
+	return volume, nil
+}
+
+// applyCredentials keeps an owned Secret with the root password of the
+// instance, read by the job pods into MYSQL_PWD.
+func (r *MariaDBSQLJobReconciler) applyCredentials(ctx context.Context, sqlJob mariak8gv1alpha1.MariaDBSQLJob, database mariak8gv1beta1.MariaDB) error {
+	secret := corev1.Secret{
+		TypeMeta: metav1.TypeMeta{APIVersion: corev1.SchemeGroupVersion.String(), Kind: "Secret"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      sqlJobCredentialsName(sqlJob),
+			Namespace: sqlJob.Namespace,
+		},
+		StringData: map[string]string{sqlJobPasswordKey: database.Spec.Credentials.RootPassword},
+	}
+	if err := ctrl.SetControllerReference(&sqlJob, &secret, r.Scheme); err != nil {
+		return err
+	}
+	applyOpts := []client.PatchOption{client.ForceOwnership, client.FieldOwner("mariadbsqljob-controller")}
+	return r.Patch(ctx, &secret, client.Apply, applyOpts...)
+}
+
+func desiredSQLJobTemplate(sqlJob mariak8gv1alpha1.MariaDBSQLJob, database mariak8gv1beta1.MariaDB, scriptVolume corev1.Volume) batchv1.JobTemplateSpec {
+	dbName := sqlJob.Spec.Database
+	if dbName == "" {
+		dbName = database.Spec.Database
+	}
+
+	labels := map[string]string{sqlJobLabel: sqlJob.Name}
+
+	return batchv1.JobTemplateSpec{
+		ObjectMeta: metav1.ObjectMeta{Labels: labels},
+		Spec: batchv1.JobSpec{
+			BackoffLimit: sqlJob.Spec.BackoffLimit,
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec: corev1.PodSpec{
+					RestartPolicy: corev1.RestartPolicyNever,
+					Containers: []corev1.Container{
+						{
+							Name:    "sql",
+							Image:   mariadbImage(database),
+							Command: []string{"sh", "-c", sqlJobCommand},
+							Env: []corev1.EnvVar{
+								{Name: "MARIADB_HOST", Value: serviceHost(database)},
+								{Name: "MARIADB_PORT", Value: strconv.Itoa(int(mariadbPort(database)))},
+								{Name: "MARIADB_DATABASE", Value: dbName},
+								{Name: "MYSQL_PWD", ValueFrom: &corev1.EnvVarSource{
+									SecretKeyRef: &corev1.SecretKeySelector{
+										LocalObjectReference: corev1.LocalObjectReference{Name: sqlJobCredentialsName(sqlJob)},
+										Key:                  sqlJobPasswordKey,
+									},
+								}},
+							},
+							VolumeMounts: []corev1.VolumeMount{
+								{Name: scriptVolume.Name, MountPath: sqlJobScriptDir, ReadOnly: true},
+							},
+						},
+					},
+					Volumes: []corev1.Volume{scriptVolume},
+				},
+			},
+		},
+	}
+}
+
+// updateRunStatus derives the phase and output from the newest Job run.
+func (r *MariaDBSQLJobReconciler) updateRunStatus(ctx context.Context, sqlJob *mariak8gv1alpha1.MariaDBSQLJob) error {
+	var jobs batchv1.JobList
+	if err := r.List(ctx, &jobs, client.InNamespace(sqlJob.Namespace), client.MatchingLabels{sqlJobLabel: sqlJob.Name}); err != nil {
+		return err
+	}
+
+	if len(jobs.Items) == 0 {
+		if sqlJob.Spec.Schedule != "" {
+			sqlJob.Status.Phase = mariak8gv1alpha1.ScheduledSQLJobPhase
+			sqlJob.Status.LastMessage = "waiting for the first scheduled run"
+		} else {
+			sqlJob.Status.Phase = mariak8gv1alpha1.PendingSQLJobPhase
+		}
+		return nil
+	}
+
+	sort.Slice(jobs.Items, func(i, j int) bool {
+		return jobs.Items[j].CreationTimestamp.Before(&jobs.Items[i].CreationTimestamp)
+	})
+	job := jobs.Items[0]
+
+	switch {
+	case jobConditionTrue(job, batchv1.JobComplete):
+		sqlJob.Status.Phase = mariak8gv1alpha1.SucceededSQLJobPhase
+		sqlJob.Status.LastMessage = "job " + job.Name + " succeeded"
+		sqlJob.Status.CompletionTime = job.Status.CompletionTime
+	case jobConditionTrue(job, batchv1.JobFailed):
+		sqlJob.Status.Phase = mariak8gv1alpha1.FailedSQLJobPhase
+		sqlJob.Status.LastMessage = "job " + job.Name + " failed"
+	default:
+		sqlJob.Status.Phase = mariak8gv1alpha1.RunningSQLJobPhase
+		sqlJob.Status.LastMessage = "job " + job.Name + " is running"
+	}
+
+	output, err := r.jobOutput(ctx, job)
+	if err != nil {
+		return err
+	}
+	if output != "" {
+		sqlJob.Status.Output = output
+	}
+	return nil
+}
+
+// jobOutput returns the termination message of the newest finished pod of the job.
+func (r *MariaDBSQLJobReconciler) jobOutput(ctx context.Context, job batchv1.Job) (string, error) {
+	var pods corev1.PodList
+	if err := r.List(ctx, &pods, client.InNamespace(job.Namespace), client.MatchingLabels{"job-name": job.Name}); err != nil {
+		return "", err
+	}
+
+	sort.Slice(pods.Items, func(i, j int) bool {
+		return pods.Items[j].CreationTimestamp.Before(&pods.Items[i].CreationTimestamp)
+	})
+	for _, pod := range pods.Items {
+		for _, cs := range pod.Status.ContainerStatuses {
+			if cs.Name == "sql" && cs.State.Terminated != nil {
+				return cs.State.Terminated.Message, nil
+			}
+		}
+	}
+	return "", nil
+}
+
+func jobConditionTrue(job batchv1.Job, condType batchv1.JobConditionType) bool {
+	for _, c := range job.Status.Conditions {
+		if c.Type == condType && c.Status == corev1.ConditionTrue {
+			return true
+		}
+	}
+	return false
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *MariaDBSQLJobReconciler) SetupWithManager(mgr ctrl.Manager, options controller.Options) error {
+	// scheduled runs are owned by the CronJob, so jobs are mapped back through their label
+	jobToSQLJob := handler.EnqueueRequestsFromMapFunc(func(obj client.Object) []reconcile.Request {
+		name, ok := obj.GetLabels()[sqlJobLabel]
+		if !ok {
+			return nil
+		}
+		return []reconcile.Request{{NamespacedName: types.NamespacedName{Namespace: obj.GetNamespace(), Name: name}}}
+	})
+
+	return ctrl.NewControllerManagedBy(mgr).
+		WithOptions(options).
+		For(&mariak8gv1alpha1.MariaDBSQLJob{}).
+		Owns(&batchv1.CronJob{}).
+		Owns(&corev1.ConfigMap{}).
+		Owns(&corev1.Secret{}).
+		Watches(&source.Kind{Type: &batchv1.Job{}}, jobToSQLJob).
+		Complete(r)
+}
@@ -0,0 +1,213 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	mariak8gv1alpha1 "github.com/mariadb/mariadb.org-tools/mariadb-operator/api/v1alpha1"
+	mariak8gv1beta1 "github.com/mariadb/mariadb.org-tools/mariadb-operator/api/v1beta1"
+)
+
+func TestDesiredSQLJobTemplate(t *testing.T) {
+	database := mariak8gv1beta1.MariaDB{
+		ObjectMeta: metav1.ObjectMeta{Name: "shop", Namespace: "default"},
+		Spec: mariak8gv1beta1.MariaDBSpec{
+			Database:    "orders",
+			Service:     mariak8gv1beta1.ServiceSpec{Port: 3307},
+			Credentials: mariak8gv1beta1.CredentialsSpec{RootPassword: "secret"},
+		},
+	}
+
+	tests := []struct {
+		name         string
+		database     string
+		wantDatabase string
+	}{
+		{name: "database of the instance", wantDatabase: "orders"},
+		{name: "database of the job", database: "reports", wantDatabase: "reports"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sqlJob := mariak8gv1alpha1.MariaDBSQLJob{
+				ObjectMeta: metav1.ObjectMeta{Name: "migrate", Namespace: "default"},
+				Spec:       mariak8gv1alpha1.MariaDBSQLJobSpec{Database: tt.database},
+			}
+			template := desiredSQLJobTemplate(sqlJob, database, corev1.Volume{Name: "sql"})
+
+			if template.Labels[sqlJobLabel] != "migrate" {
+				t.Errorf("template labels %v", template.Labels)
+			}
+			container := template.Spec.Template.Spec.Containers[0]
+			env := map[string]corev1.EnvVar{}
+			for _, e := range container.Env {
+				env[e.Name] = e
+				if e.Value == "secret" {
+					t.Errorf("%s holds the root password in the pod template", e.Name)
+				}
+			}
+			if strings.Contains(strings.Join(container.Command, " "), "--password") {
+				t.Errorf("the password is passed in the arguments of %v", container.Command)
+			}
+			pwd := env["MYSQL_PWD"].ValueFrom
+			if pwd == nil || pwd.SecretKeyRef == nil || pwd.SecretKeyRef.Name != "migrate-sqljob-credentials" || pwd.SecretKeyRef.Key != sqlJobPasswordKey {
+				t.Errorf("MYSQL_PWD = %+v, want the credentials Secret", env["MYSQL_PWD"])
+			}
+			if got := env["MARIADB_HOST"].Value; got != "shop-server-service.default.svc" {
+				t.Errorf("MARIADB_HOST = %q", got)
+			}
+			if got := env["MARIADB_PORT"].Value; got != "3307" {
+				t.Errorf("MARIADB_PORT = %q", got)
+			}
+			if got := env["MARIADB_DATABASE"].Value; got != tt.wantDatabase {
+				t.Errorf("MARIADB_DATABASE = %q, want %q", got, tt.wantDatabase)
+			}
+		})
+	}
+}
+
+func TestDesiredSQLJob(t *testing.T) {
+	sqlJob := mariak8gv1alpha1.MariaDBSQLJob{
+		ObjectMeta: metav1.ObjectMeta{Name: "migrate", Namespace: "default"},
+		Spec:       mariak8gv1alpha1.MariaDBSQLJobSpec{SQL: "SELECT 1;", Schedule: "0 3 * * *"},
+	}
+	template := batchv1.JobTemplateSpec{ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{sqlJobLabel: "migrate"}}}
+
+	job := desiredSQLJob(sqlJob, template)
+	if job.Name != "migrate-sqljob" || job.Namespace != "default" {
+		t.Errorf("job is %s/%s", job.Namespace, job.Name)
+	}
+	if job.Labels[sqlJobLabel] != "migrate" {
+		t.Errorf("job labels %v, its runs are mapped back through them", job.Labels)
+	}
+	if got := job.Annotations[sqlHashAnnotation]; got != sqlHash(sqlJob) {
+		t.Errorf("hash annotation = %q, want %q", got, sqlHash(sqlJob))
+	}
+
+	cronJob := desiredSQLCronJob(sqlJob, template)
+	if cronJob.Name != "migrate-sqljob" || cronJob.Namespace != "default" {
+		t.Errorf("cron job is %s/%s", cronJob.Namespace, cronJob.Name)
+	}
+	if cronJob.Spec.Schedule != "0 3 * * *" || cronJob.Spec.ConcurrencyPolicy != batchv1.ForbidConcurrent {
+		t.Errorf("cron job schedule %q, concurrency %q", cronJob.Spec.Schedule, cronJob.Spec.ConcurrencyPolicy)
+	}
+	if cronJob.Spec.JobTemplate.Labels[sqlJobLabel] != "migrate" {
+		t.Errorf("job template labels %v", cronJob.Spec.JobTemplate.Labels)
+	}
+}
+
+func TestSQLHash(t *testing.T) {
+	ref := func(name, key string) *corev1.ConfigMapKeySelector {
+		return &corev1.ConfigMapKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: name}, Key: key}
+	}
+	base := mariak8gv1alpha1.MariaDBSQLJobSpec{SQL: "SELECT 1;"}
+
+	tests := []struct {
+		name     string
+		spec     mariak8gv1alpha1.MariaDBSQLJobSpec
+		wantSame bool
+	}{
+		{name: "same SQL", spec: mariak8gv1alpha1.MariaDBSQLJobSpec{SQL: "SELECT 1;"}, wantSame: true},
+		{name: "schedule ignored", spec: mariak8gv1alpha1.MariaDBSQLJobSpec{SQL: "SELECT 1;", Schedule: "@daily"}, wantSame: true},
+		{name: "inline SQL wins over the ConfigMap", spec: mariak8gv1alpha1.MariaDBSQLJobSpec{SQL: "SELECT 1;", SQLConfigMapKeyRef: ref("sql", "job.sql")}, wantSame: true},
+		{name: "other SQL", spec: mariak8gv1alpha1.MariaDBSQLJobSpec{SQL: "SELECT 2;"}},
+		{name: "ConfigMap", spec: mariak8gv1alpha1.MariaDBSQLJobSpec{SQLConfigMapKeyRef: ref("sql", "job.sql")}},
+	}
+	want := sqlHash(mariak8gv1alpha1.MariaDBSQLJob{Spec: base})
+	for _, tt := range tests {
+		got := sqlHash(mariak8gv1alpha1.MariaDBSQLJob{Spec: tt.spec})
+		if (got == want) != tt.wantSame {
+			t.Errorf("%s: hash %s, hash of %q %s", tt.name, got, base.SQL, want)
+		}
+	}
+
+	configMap := sqlHash(mariak8gv1alpha1.MariaDBSQLJob{Spec: mariak8gv1alpha1.MariaDBSQLJobSpec{SQLConfigMapKeyRef: ref("sql", "job.sql")}})
+	otherKey := sqlHash(mariak8gv1alpha1.MariaDBSQLJob{Spec: mariak8gv1alpha1.MariaDBSQLJobSpec{SQLConfigMapKeyRef: ref("sql", "other.sql")}})
+	if configMap == otherKey {
+		t.Errorf("keys of the same ConfigMap have the same hash %s", configMap)
+	}
+}
+
+func TestEnsureOneShotJob(t *testing.T) {
+	sqlJob := mariak8gv1alpha1.MariaDBSQLJob{
+		ObjectMeta: metav1.ObjectMeta{Name: "migrate", Namespace: "default"},
+		Spec:       mariak8gv1alpha1.MariaDBSQLJobSpec{SQL: "SELECT 1;"},
+	}
+	job := desiredSQLJob(sqlJob, batchv1.JobTemplateSpec{})
+	live := func(hash string) *batchv1.Job {
+		live := job.DeepCopy()
+		live.Annotations = nil
+		if hash != "" {
+			live.Annotations = map[string]string{sqlHashAnnotation: hash}
+		}
+		return live
+	}
+
+	tests := []struct {
+		name      string
+		live      *batchv1.Job
+		wantRerun bool
+		wantHash  string
+	}{
+		{name: "first run", wantHash: sqlHash(sqlJob)},
+		{name: "same SQL", live: live(sqlHash(sqlJob)), wantHash: sqlHash(sqlJob)},
+		{name: "run before the hash was recorded", live: live(""), wantHash: sqlHash(sqlJob)},
+		{name: "SQL changed", live: live("0123456789abcdef"), wantRerun: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			builder := fake.NewClientBuilder().WithScheme(testScheme(t))
+			if tt.live != nil {
+				builder = builder.WithObjects(tt.live)
+			}
+			c := builder.Build()
+			r := &MariaDBSQLJobReconciler{Client: c}
+
+			rerun, err := r.ensureOneShotJob(context.Background(), *job.DeepCopy())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rerun != tt.wantRerun {
+				t.Errorf("rerun = %v, want %v", rerun, tt.wantRerun)
+			}
+
+			var got batchv1.Job
+			err = c.Get(context.Background(), types.NamespacedName{Namespace: job.Namespace, Name: job.Name}, &got)
+			if tt.wantRerun {
+				if !errors.IsNotFound(err) {
+					t.Errorf("previous run not deleted: %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if hash := got.Annotations[sqlHashAnnotation]; hash != tt.wantHash {
+				t.Errorf("hash annotation = %q, want %q", hash, tt.wantHash)
+			}
+		})
+	}
+}
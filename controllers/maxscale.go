@@ -0,0 +1,424 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	mariak8gv1beta1 "github.com/mariadb/mariadb.org-tools/mariadb-operator/api/v1beta1"
+)
+
+const (
+	maxscaleUser      = "maxscale"
+	maxscaleConfigDir = "/etc/maxscale-operator"
+	// maxscaleConfigHashAnnotation restarts the MaxScale pods when the
+	// configuration changes, MaxScale only reads it on start
+	maxscaleConfigHashAnnotation = "mariadb.org/maxscale-config-hash"
+)
+
+func maxscaleEnabled(database mariak8gv1beta1.MariaDB) bool {
+	return database.Spec.MaxScale != nil && database.Spec.MaxScale.Enabled
+}
+
+func maxscalePort(database mariak8gv1beta1.MariaDB) int32 {
+	if database.Spec.MaxScale == nil || database.Spec.MaxScale.Port == 0 {
+		return 3306
+	}
+	return database.Spec.MaxScale.Port
+}
+
+func maxscaleName(database mariak8gv1beta1.MariaDB) string {
+	return database.Name + "-maxscale"
+}
+
+func maxscaleSecretName(database mariak8gv1beta1.MariaDB) string {
+	return maxscaleName(database) + "-user"
+}
+
+func maxscaleConfigName(database mariak8gv1beta1.MariaDB) string {
+	return maxscaleName(database) + "-config"
+}
+
+func maxscaleServiceName(database mariak8gv1beta1.MariaDB) string {
+	return maxscaleName(database) + "-service"
+}
+
+func maxscaleNetworkPolicyName(database mariak8gv1beta1.MariaDB) string {
+	return maxscaleName(database) + "-networkpolicy"
+}
+
+// maxscaleLabels select the MaxScale pods, they must not carry the mariadb
+// label or the instance Service would route to them.
+func maxscaleLabels(database mariak8gv1beta1.MariaDB) map[string]string {
+	return map[string]string{"mariadb-maxscale": database.Name}
+}
+
+// ensureMaxScaleSecret creates the credentials MaxScale uses to monitor the
+// servers and to authenticate clients, generated once like the exporter ones.
+func (r *MariaDBReconciler) ensureMaxScaleSecret(ctx context.Context, database mariak8gv1beta1.MariaDB) (string, error) {
+	var secret corev1.Secret
+	err := r.Get(ctx, types.NamespacedName{Namespace: database.Namespace, Name: maxscaleSecretName(database)}, &secret)
+	if err == nil {
+		return string(secret.Data["password"]), nil
+	}
+	if ignoreNotFound(err) != nil {
+		return "", err
+	}
+
+	password, err := randomPassword()
+	if err != nil {
+		return "", err
+	}
+
+	secret = corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      maxscaleSecretName(database),
+			Namespace: database.Namespace,
+		},
+		StringData: map[string]string{
+			"username": maxscaleUser,
+			"password": password,
+		},
+	}
+	if err := ctrl.SetControllerReference(&database, &secret, r.Scheme); err != nil {
+		return "", err
+	}
+	if err := r.Create(ctx, &secret); err != nil {
+		return "", err
+	}
+	return password, nil
+}
+
+// maxscaleUserSQL creates the MaxScale user with what the monitor and the
+// readwritesplit router need to read.
+func maxscaleUserSQL(password string) string {
+	return fmt.Sprintf(`CREATE USER IF NOT EXISTS '%[1]s'@'%%' IDENTIFIED BY %[2]s;
+ALTER USER '%[1]s'@'%%' IDENTIFIED BY %[2]s;
+GRANT SELECT ON mysql.* TO '%[1]s'@'%%';
+GRANT SHOW DATABASES, PROCESS, REPLICATION CLIENT, RELOAD, SUPER ON *.* TO '%[1]s'@'%%';
+`, maxscaleUser, quoteSQL(password))
+}
+
+// reconcileMaxScale creates the MaxScale user on every ready pod, then renders
+// the configuration with one server per ready pod, the Deployment and the
+// Service of the proxy.
+func (r *MariaDBReconciler) reconcileMaxScale(ctx context.Context, database *mariak8gv1beta1.MariaDB, opts ...client.PatchOption) error {
+	if !maxscaleEnabled(*database) {
+		if err := r.dropMaxScaleUser(ctx, database); err != nil {
+			return err
+		}
+		return r.deleteMaxScale(ctx, *database)
+	}
+
+	password, err := r.ensureMaxScaleSecret(ctx, *database)
+	if err != nil {
+		return err
+	}
+
+	pods, err := r.instancePods(ctx, *database)
+	if err != nil {
+		return err
+	}
+
+	var userPods []string
+	for _, pod := range pods {
+		if containsString(database.Status.MaxScaleUserPods, pod.Name) {
+			userPods = append(userPods, pod.Name)
+		}
+	}
+	database.Status.MaxScaleUserPods = userPods
+
+	sql := maxscaleUserSQL(password)
+	var servers []corev1.Pod
+	for _, pod := range pods {
+		if !podReady(pod) || pod.Status.PodIP == "" {
+			continue
+		}
+		if !containsString(database.Status.MaxScaleUserPods, pod.Name) {
+			if _, err := r.execSQL(ctx, pod, sql); err != nil {
+				return err
+			}
+			database.Status.MaxScaleUserPods = append(database.Status.MaxScaleUserPods, pod.Name)
+		}
+		servers = append(servers, pod)
+	}
+
+	svc, err := r.desiredMaxScaleService(*database)
+	if err != nil {
+		return err
+	}
+	if err := r.Patch(ctx, &svc, client.Apply, opts...); err != nil {
+		return err
+	}
+	if err := r.reconcileMaxScaleNetworkPolicy(ctx, *database, opts...); err != nil {
+		return err
+	}
+
+	// keep the last configuration while no server is ready
+	if len(servers) == 0 {
+		return nil
+	}
+
+	config, err := r.desiredMaxScaleConfig(*database, servers, password)
+	if err != nil {
+		return err
+	}
+	if err := r.Patch(ctx, &config, client.Apply, opts...); err != nil {
+		return err
+	}
+
+	deployment, err := r.desiredMaxScaleDeployment(*database, config.Data["maxscale.cnf"])
+	if err != nil {
+		return err
+	}
+	return r.Patch(ctx, &deployment, client.Apply, opts...)
+}
+
+// maxscaleConfig renders maxscale.cnf, servers are sorted by pod name so the
+// configuration only changes when the set of ready pods does.
+func maxscaleConfig(database mariak8gv1beta1.MariaDB, servers []corev1.Pod, password string) string {
+	sort.Slice(servers, func(i, j int) bool { return servers[i].Name < servers[j].Name })
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "[maxscale]\nthreads=auto\nadmin_host=127.0.0.1\n\n")
+
+	var names []string
+	for _, pod := range servers {
+		name := "server-" + pod.Name
+		names = append(names, name)
+		fmt.Fprintf(&b, "[%s]\ntype=server\naddress=%s\nport=%d\nprotocol=MariaDBBackend\n\n", name, pod.Status.PodIP, mariadbPort(database))
+	}
+	serverList := strings.Join(names, ",")
+
+	monitor := database.Spec.MaxScale.Monitor
+	if monitor == "" {
+		monitor = "mariadbmon"
+	}
+	fmt.Fprintf(&b, "[monitor]\ntype=monitor\nmodule=%s\nservers=%s\nuser=%s\npassword=%s\nmonitor_interval=2000ms\n\n",
+		monitor, serverList, maxscaleUser, password)
+	fmt.Fprintf(&b, "[read-write-service]\ntype=service\nrouter=readwritesplit\nservers=%s\nuser=%s\npassword=%s\n\n",
+		serverList, maxscaleUser, password)
+	fmt.Fprintf(&b, "[read-write-listener]\ntype=listener\nservice=read-write-service\nprotocol=MariaDBClient\nport=%d\n",
+		maxscalePort(database))
+	return b.String()
+}
+
+func (r *MariaDBReconciler) desiredMaxScaleConfig(database mariak8gv1beta1.MariaDB, servers []corev1.Pod, password string) (corev1.Secret, error) {
+	secret := corev1.Secret{
+		TypeMeta: metav1.TypeMeta{APIVersion: corev1.SchemeGroupVersion.String(), Kind: "Secret"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      maxscaleConfigName(database),
+			Namespace: database.Namespace,
+		},
+		Data: map[string][]byte{"maxscale.cnf": []byte(maxscaleConfig(database, servers, password))},
+	}
+	if err := ctrl.SetControllerReference(&database, &secret, r.Scheme); err != nil {
+		return secret, err
+	}
+	return secret, nil
+}
+
+func (r *MariaDBReconciler) desiredMaxScaleDeployment(database mariak8gv1beta1.MariaDB, config []byte) (appsv1.Deployment, error) {
+	spec := database.Spec.MaxScale
+	image := spec.Image
+	if image == "" {
+		image = "mariadb/maxscale:6.2"
+	}
+	replicas := spec.Replicas
+	if replicas == nil {
+		one := int32(1)
+		replicas = &one
+	}
+	sum := sha256.Sum256(config)
+
+	depl := appsv1.Deployment{
+		TypeMeta: metav1.TypeMeta{APIVersion: appsv1.SchemeGroupVersion.String(), Kind: "Deployment"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      maxscaleName(database),
+			Namespace: database.Namespace,
+		},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: replicas,
+			Selector: &metav1.LabelSelector{MatchLabels: maxscaleLabels(database)},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels:      maxscaleLabels(database),
+					Annotations: map[string]string{maxscaleConfigHashAnnotation: hex.EncodeToString(sum[:8])},
+				},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{
+						{
+							Name:    "maxscale",
+							Image:   image,
+							Command: []string{"maxscale"},
+							Args: []string{
+								"--nodaemon",
+								"--user=maxscale",
+								"--log=stdout",
+								"--config=" + maxscaleConfigDir + "/maxscale.cnf",
+							},
+							Ports: []corev1.ContainerPort{
+								{ContainerPort: maxscalePort(database), Name: "maxscale", Protocol: "TCP"},
+							},
+							VolumeMounts: []corev1.VolumeMount{
+								{Name: "config", MountPath: maxscaleConfigDir, ReadOnly: true},
+							},
+							ReadinessProbe: &corev1.Probe{
+								Handler: corev1.Handler{
+									TCPSocket: &corev1.TCPSocketAction{Port: intstr.FromString("maxscale")},
+								},
+								InitialDelaySeconds: 5,
+								PeriodSeconds:       10,
+							},
+						},
+					},
+					Volumes: []corev1.Volume{
+						{Name: "config", VolumeSource: corev1.VolumeSource{
+							Secret: &corev1.SecretVolumeSource{SecretName: maxscaleConfigName(database)},
+						}},
+					},
+				},
+			},
+		},
+	}
+
+	if err := ctrl.SetControllerReference(&database, &depl, r.Scheme); err != nil {
+		return depl, err
+	}
+	return depl, nil
+}
+
+func (r *MariaDBReconciler) desiredMaxScaleService(database mariak8gv1beta1.MariaDB) (corev1.Service, error) {
+	svc := corev1.Service{
+		TypeMeta: metav1.TypeMeta{APIVersion: corev1.SchemeGroupVersion.String(), Kind: "Service"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      maxscaleServiceName(database),
+			Namespace: database.Namespace,
+			Labels:    maxscaleLabels(database),
+		},
+		Spec: corev1.ServiceSpec{
+			Ports: []corev1.ServicePort{
+				{Name: "maxscale", Port: maxscalePort(database), Protocol: "TCP", TargetPort: intstr.FromString("maxscale")},
+			},
+			Selector: maxscaleLabels(database),
+			Type:     corev1.ServiceTypeClusterIP,
+		},
+	}
+
+	if err := ctrl.SetControllerReference(&database, &svc, r.Scheme); err != nil {
+		return svc, err
+	}
+	return svc, nil
+}
+
+// reconcileMaxScaleNetworkPolicy restricts the clients of the proxy like
+// the ones of the servers, as it forwards them to the servers.
+func (r *MariaDBReconciler) reconcileMaxScaleNetworkPolicy(ctx context.Context, database mariak8gv1beta1.MariaDB, opts ...client.PatchOption) error {
+	if database.Spec.NetworkPolicy == nil || !database.Spec.NetworkPolicy.Enabled {
+		np := networkingv1.NetworkPolicy{ObjectMeta: metav1.ObjectMeta{Name: maxscaleNetworkPolicyName(database), Namespace: database.Namespace}}
+		return ignoreNotFound(r.Delete(ctx, &np))
+	}
+	np, err := r.desiredMaxScaleNetworkPolicy(database)
+	if err != nil {
+		return err
+	}
+	return r.Patch(ctx, &np, client.Apply, opts...)
+}
+
+func (r *MariaDBReconciler) desiredMaxScaleNetworkPolicy(database mariak8gv1beta1.MariaDB) (networkingv1.NetworkPolicy, error) {
+	tcp := corev1.ProtocolTCP
+	port := intstr.FromString("maxscale")
+	ports := []networkingv1.NetworkPolicyPort{{Protocol: &tcp, Port: &port}}
+
+	np := networkingv1.NetworkPolicy{
+		TypeMeta: metav1.TypeMeta{APIVersion: networkingv1.SchemeGroupVersion.String(), Kind: "NetworkPolicy"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      maxscaleNetworkPolicyName(database),
+			Namespace: database.Namespace,
+		},
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{MatchLabels: maxscaleLabels(database)},
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress},
+			Ingress:     clientIngressRules(*database.Spec.NetworkPolicy, ports),
+		},
+	}
+
+	if err := ctrl.SetControllerReference(&database, &np, r.Scheme); err != nil {
+		return np, err
+	}
+	return np, nil
+}
+
+// dropMaxScaleUser removes the MaxScale user once MaxScale is disabled. It
+// is dropped like the exporter user, from every ready pod and retried while
+// none is ready.
+func (r *MariaDBReconciler) dropMaxScaleUser(ctx context.Context, database *mariak8gv1beta1.MariaDB) error {
+	if database.Status.MaxScaleUserPods == nil {
+		return nil
+	}
+	pods, err := r.instancePods(ctx, *database)
+	if err != nil {
+		return err
+	}
+	dropped := false
+	for _, pod := range pods {
+		if !podReady(pod) {
+			continue
+		}
+		if _, err := r.execSQL(ctx, pod, fmt.Sprintf("DROP USER IF EXISTS '%s'@'%%';\n", maxscaleUser)); err != nil {
+			return err
+		}
+		dropped = true
+	}
+	if dropped {
+		database.Status.MaxScaleUserPods = nil
+	}
+	return nil
+}
+
+func (r *MariaDBReconciler) deleteMaxScale(ctx context.Context, database mariak8gv1beta1.MariaDB) error {
+	meta := func(name string) metav1.ObjectMeta {
+		return metav1.ObjectMeta{Name: name, Namespace: database.Namespace}
+	}
+	objects := []client.Object{
+		&appsv1.Deployment{ObjectMeta: meta(maxscaleName(database))},
+		&corev1.Service{ObjectMeta: meta(maxscaleServiceName(database))},
+		&networkingv1.NetworkPolicy{ObjectMeta: meta(maxscaleNetworkPolicyName(database))},
+		&corev1.Secret{ObjectMeta: meta(maxscaleConfigName(database))},
+		&corev1.Secret{ObjectMeta: meta(maxscaleSecretName(database))},
+	}
+	for _, obj := range objects {
+		if err := r.Delete(ctx, obj); ignoreNotFound(err) != nil {
+			return err
+		}
+	}
+	return nil
+}
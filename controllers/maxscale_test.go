@@ -0,0 +1,98 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	mariak8gv1beta1 "github.com/mariadb/mariadb.org-tools/mariadb-operator/api/v1beta1"
+)
+
+func TestMaxScaleConfig(t *testing.T) {
+	pod := func(name, ip string) corev1.Pod {
+		return corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: name}, Status: corev1.PodStatus{PodIP: ip}}
+	}
+
+	tests := []struct {
+		name    string
+		spec    mariak8gv1beta1.MariaDBSpec
+		servers []corev1.Pod
+		want    []string
+	}{
+		{
+			name:    "defaults",
+			spec:    mariak8gv1beta1.MariaDBSpec{MaxScale: &mariak8gv1beta1.MaxScaleSpec{Enabled: true}},
+			servers: []corev1.Pod{pod("shop-b", "10.0.0.2"), pod("shop-a", "10.0.0.1")},
+			want: []string{
+				"[server-shop-a]\ntype=server\naddress=10.0.0.1\nport=3306\nprotocol=MariaDBBackend\n\n[server-shop-b]\ntype=server\naddress=10.0.0.2\n",
+				"[monitor]\ntype=monitor\nmodule=mariadbmon\nservers=server-shop-a,server-shop-b\nuser=maxscale\npassword=secret\n",
+				"[read-write-service]\ntype=service\nrouter=readwritesplit\nservers=server-shop-a,server-shop-b\nuser=maxscale\npassword=secret\n",
+				"[read-write-listener]\ntype=listener\nservice=read-write-service\nprotocol=MariaDBClient\nport=3306\n",
+			},
+		},
+		{
+			name: "monitor and ports",
+			spec: mariak8gv1beta1.MariaDBSpec{
+				Service:  mariak8gv1beta1.ServiceSpec{Port: 3307},
+				MaxScale: &mariak8gv1beta1.MaxScaleSpec{Enabled: true, Monitor: "galeramon", Port: 4006},
+			},
+			servers: []corev1.Pod{pod("shop-a", "10.0.0.1")},
+			want: []string{
+				"address=10.0.0.1\nport=3307\n",
+				"module=galeramon\nservers=server-shop-a\n",
+				"protocol=MariaDBClient\nport=4006\n",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			database := mariak8gv1beta1.MariaDB{ObjectMeta: metav1.ObjectMeta{Name: "shop"}, Spec: tt.spec}
+			config := maxscaleConfig(database, tt.servers, "secret")
+			if !strings.HasPrefix(config, "[maxscale]\n") {
+				t.Errorf("config doesn't start with the maxscale section:\n%s", config)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(config, want) {
+					t.Errorf("config doesn't contain %q:\n%s", want, config)
+				}
+			}
+		})
+	}
+}
+
+func TestMaxScaleUserSQL(t *testing.T) {
+	tests := []struct {
+		password string
+		want     string
+	}{
+		{password: "Zm9vYmFy", want: `IDENTIFIED BY 'Zm9vYmFy';`},
+		{password: `it's\`, want: `IDENTIFIED BY 'it\'s\\';`},
+	}
+	for _, tt := range tests {
+		sql := maxscaleUserSQL(tt.password)
+		if strings.Count(sql, tt.want) != 2 {
+			t.Errorf("maxscaleUserSQL(%q) = %s, want the password as %s", tt.password, sql, tt.want)
+		}
+		if !strings.HasPrefix(sql, "CREATE USER IF NOT EXISTS 'maxscale'@'%' ") {
+			t.Errorf("maxscaleUserSQL() creates %s", strings.SplitN(sql, "\n", 2)[0])
+		}
+	}
+}
@@ -0,0 +1,126 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	mariak8gv1beta1 "github.com/mariadb/mariadb.org-tools/mariadb-operator/api/v1beta1"
+)
+
+// Reconcile stages reported by the reconcile errors metric
+const (
+	stageBuild        = "build"
+	stageApply        = "apply"
+	stageStatusUpdate = "status_update"
+)
+
+var statusPhases = []mariak8gv1beta1.StatusPhase{
+	mariak8gv1beta1.RunningStatusPhase,
+	mariak8gv1beta1.BootstrapingStatusPhase,
+	mariak8gv1beta1.ErrorStatusPhase,
+}
+
+var (
+	instancePhase = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "mariadb_operator_instance_phase",
+		Help: "Phase of each MariaDB instance, 1 for the phase it is in and 0 for the others",
+	}, []string{"namespace", "name", "phase"})
+
+	reconcileErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "mariadb_operator_reconcile_errors_total",
+		Help: "Reconcile errors of each MariaDB instance by stage (build, apply, status_update)",
+	}, []string{"namespace", "name", "stage"})
+
+	timeToReady = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "mariadb_operator_time_to_ready_seconds",
+		Help: "Seconds between the creation of a MariaDB instance and all its replicas first being ready",
+	}, []string{"namespace", "name"})
+
+	driftCorrections = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "mariadb_operator_drift_corrections_total",
+		Help: "Edits made outside of the operator reverted on the owned objects of each MariaDB instance, by kind",
+	}, []string{"namespace", "name", "kind"})
+
+	backupResults = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "mariadb_operator_backups_total",
+		Help: "Backups of each MariaDB instance by result (success, failure)",
+	}, []string{"namespace", "name", "result"})
+
+	lastSuccessfulBackup = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "mariadb_operator_last_successful_backup_timestamp_seconds",
+		Help: "Unix time of the last successful backup of each MariaDB instance",
+	}, []string{"namespace", "name"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(instancePhase, reconcileErrors, timeToReady, driftCorrections, backupResults, lastSuccessfulBackup)
+}
+
+// recordError counts err against the reconcile stage it happened in and returns it.
+func (r *MariaDBReconciler) recordError(database mariak8gv1beta1.MariaDB, stage string, err error) error {
+	if err != nil {
+		reconcileErrors.WithLabelValues(database.Namespace, database.Name, stage).Inc()
+		if stage == stageApply {
+			r.Recorder.Event(&database, corev1.EventTypeWarning, EventReasonApplyFailed, err.Error())
+		}
+	}
+	return err
+}
+
+func recordInstanceMetrics(database mariak8gv1beta1.MariaDB) {
+	for _, phase := range statusPhases {
+		value := 0.0
+		if database.Status.DbState == phase {
+			value = 1
+		}
+		instancePhase.WithLabelValues(database.Namespace, database.Name, string(phase)).Set(value)
+	}
+
+	// start the drift and backup series at zero, so they exist before the first event
+	for _, kind := range driftCheckedKinds {
+		driftCorrections.WithLabelValues(database.Namespace, database.Name, kind)
+	}
+	for _, result := range []string{"success", "failure"} {
+		backupResults.WithLabelValues(database.Namespace, database.Name, result)
+	}
+
+	if database.Status.ReadyTime != nil {
+		ready := database.Status.ReadyTime.Sub(database.CreationTimestamp.Time)
+		timeToReady.WithLabelValues(database.Namespace, database.Name).Set(ready.Seconds())
+	}
+}
+
+// deleteInstanceMetrics drops the series of a deleted instance.
+func deleteInstanceMetrics(namespace, name string) {
+	for _, phase := range statusPhases {
+		instancePhase.DeleteLabelValues(namespace, name, string(phase))
+	}
+	for _, stage := range []string{stageBuild, stageApply, stageStatusUpdate} {
+		reconcileErrors.DeleteLabelValues(namespace, name, stage)
+	}
+	for _, kind := range driftCheckedKinds {
+		driftCorrections.DeleteLabelValues(namespace, name, kind)
+	}
+	for _, result := range []string{"success", "failure"} {
+		backupResults.DeleteLabelValues(namespace, name, result)
+	}
+	timeToReady.DeleteLabelValues(namespace, name)
+	lastSuccessfulBackup.DeleteLabelValues(namespace, name)
+}
@@ -0,0 +1,147 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+
+	mariak8gv1beta1 "github.com/mariadb/mariadb.org-tools/mariadb-operator/api/v1beta1"
+)
+
+func TestRecordInstanceMetrics(t *testing.T) {
+	created := metav1.NewTime(time.Date(2021, 10, 1, 12, 0, 0, 0, time.UTC))
+	ready := metav1.NewTime(created.Add(90 * time.Second))
+
+	tests := []struct {
+		name          string
+		phase         mariak8gv1beta1.StatusPhase
+		readyTime     *metav1.Time
+		wantTimeReady bool
+	}{
+		{name: "bootstrapping", phase: mariak8gv1beta1.BootstrapingStatusPhase},
+		{name: "running", phase: mariak8gv1beta1.RunningStatusPhase, readyTime: &ready, wantTimeReady: true},
+		{name: "error", phase: mariak8gv1beta1.ErrorStatusPhase, readyTime: &ready, wantTimeReady: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			database := mariak8gv1beta1.MariaDB{
+				ObjectMeta: metav1.ObjectMeta{Name: "metrics-" + tt.name, Namespace: "default", CreationTimestamp: created},
+				Status:     mariak8gv1beta1.MariaDBStatus{DbState: tt.phase, ReadyTime: tt.readyTime},
+			}
+			defer deleteInstanceMetrics(database.Namespace, database.Name)
+			recordInstanceMetrics(database)
+
+			for _, phase := range statusPhases {
+				want := 0.0
+				if phase == tt.phase {
+					want = 1
+				}
+				if got := testutil.ToFloat64(instancePhase.WithLabelValues("default", database.Name, string(phase))); got != want {
+					t.Errorf("phase %s = %v, want %v", phase, got, want)
+				}
+			}
+			// the backup series exist at zero before the first backup
+			for _, result := range []string{"success", "failure"} {
+				if got := testutil.ToFloat64(backupResults.WithLabelValues("default", database.Name, result)); got != 0 {
+					t.Errorf("%s backups = %v", result, got)
+				}
+			}
+			series := testutil.CollectAndCount(timeToReady)
+			deleteInstanceMetrics(database.Namespace, database.Name)
+			if tt.wantTimeReady {
+				if series != 1 {
+					t.Errorf("%d time to ready series", series)
+				}
+				recordInstanceMetrics(database)
+				if got := testutil.ToFloat64(timeToReady.WithLabelValues("default", database.Name)); got != 90 {
+					t.Errorf("time to ready = %v, want 90", got)
+				}
+			} else if series != 0 {
+				t.Errorf("time to ready recorded before the instance was ready")
+			}
+		})
+	}
+}
+
+func TestDeleteInstanceMetrics(t *testing.T) {
+	ready := metav1.Now()
+	database := mariak8gv1beta1.MariaDB{
+		ObjectMeta: metav1.ObjectMeta{Name: "deleted", Namespace: "default"},
+		Status:     mariak8gv1beta1.MariaDBStatus{ReadyTime: &ready},
+	}
+	collectors := map[string]prometheus.Collector{
+		"phase":       instancePhase,
+		"errors":      reconcileErrors,
+		"timeToReady": timeToReady,
+		"backups":     backupResults,
+	}
+	before := map[string]int{}
+	for name, collector := range collectors {
+		before[name] = testutil.CollectAndCount(collector)
+	}
+
+	r := &MariaDBReconciler{Recorder: record.NewFakeRecorder(10)}
+	recordInstanceMetrics(database)
+	_ = r.recordError(database, stageApply, errors.New("conflict"))
+	deleteInstanceMetrics(database.Namespace, database.Name)
+
+	for name, collector := range collectors {
+		if got := testutil.CollectAndCount(collector); got != before[name] {
+			t.Errorf("%s has %d series left, %d before the instance", name, got, before[name])
+		}
+	}
+}
+
+func TestRecordError(t *testing.T) {
+	tests := []struct {
+		name      string
+		stage     string
+		err       error
+		wantCount float64
+		wantEvent bool
+	}{
+		{name: "no error", stage: stageApply},
+		{name: "build error", stage: stageBuild, err: errors.New("invalid"), wantCount: 1},
+		{name: "apply error", stage: stageApply, err: errors.New("conflict"), wantCount: 1, wantEvent: true},
+		{name: "status update error", stage: stageStatusUpdate, err: errors.New("conflict"), wantCount: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			database := mariak8gv1beta1.MariaDB{ObjectMeta: metav1.ObjectMeta{Name: "errors", Namespace: "default"}}
+			defer deleteInstanceMetrics(database.Namespace, database.Name)
+			recorder := record.NewFakeRecorder(10)
+			r := &MariaDBReconciler{Recorder: recorder}
+
+			if err := r.recordError(database, tt.stage, tt.err); err != tt.err {
+				t.Errorf("recordError() = %v, want %v", err, tt.err)
+			}
+			if got := testutil.ToFloat64(reconcileErrors.WithLabelValues("default", "errors", tt.stage)); got != tt.wantCount {
+				t.Errorf("%s errors = %v, want %v", tt.stage, got, tt.wantCount)
+			}
+			if events := len(recorder.Events); (events > 0) != tt.wantEvent {
+				t.Errorf("%d events recorded", events)
+			}
+		})
+	}
+}
@@ -0,0 +1,92 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	mariak8gv1beta1 "github.com/mariadb/mariadb.org-tools/mariadb-operator/api/v1beta1"
+)
+
+// pausedAnnotation suspends reconciliation like spec.paused, without editing the spec
+const pausedAnnotation = "mariadb.org/paused"
+
+// pauseReason is the reason of the Paused condition, empty when the instance isn't paused.
+func pauseReason(database mariak8gv1beta1.MariaDB) string {
+	if database.Spec.Paused {
+		return "PausedBySpec"
+	}
+	if database.Annotations[pausedAnnotation] == "true" {
+		return "PausedByAnnotation"
+	}
+	return ""
+}
+
+// setPausedCondition sets the Paused condition, recording an event when the
+// instance gets paused or resumed.
+func (r *MariaDBReconciler) setPausedCondition(database *mariak8gv1beta1.MariaDB, reason string) {
+	wasPaused := meta.IsStatusConditionTrue(database.Status.Conditions, mariak8gv1beta1.ConditionPaused)
+
+	condition := metav1.Condition{
+		Type:               mariak8gv1beta1.ConditionPaused,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: database.Generation,
+		Reason:             "Reconciling",
+		Message:            "the spec is applied to the owned objects",
+	}
+	if reason != "" {
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = reason
+		condition.Message = "reconciliation is suspended, owned objects aren't modified"
+	}
+	meta.SetStatusCondition(&database.Status.Conditions, condition)
+
+	if reason != "" && !wasPaused {
+		r.Recorder.Event(database, corev1.EventTypeNormal, EventReasonPaused, condition.Message)
+	} else if reason == "" && wasPaused {
+		r.Recorder.Event(database, corev1.EventTypeNormal, EventReasonResumed, "reconciliation resumed")
+	}
+}
+
+// scaleDownPaused scales the deployment of a paused instance to zero when
+// asked to. Only the replicas are patched, the next apply after resuming
+// restores them. The applied hash is dropped with them, so that apply
+// doesn't report the scale down as drift.
+func (r *MariaDBReconciler) scaleDownPaused(ctx context.Context, database mariak8gv1beta1.MariaDB) error {
+	if !database.Spec.ScaleDownWhenPaused {
+		return nil
+	}
+
+	var deployment appsv1.Deployment
+	err := r.Get(ctx, types.NamespacedName{Namespace: database.Namespace, Name: deploymentName(database)}, &deployment)
+	if err != nil || (deployment.Spec.Replicas != nil && *deployment.Spec.Replicas == 0) {
+		return ignoreNotFound(err)
+	}
+
+	patch := client.MergeFrom(deployment.DeepCopy())
+	zero := int32(0)
+	deployment.Spec.Replicas = &zero
+	delete(deployment.Annotations, appliedHashAnnotation)
+	return r.Patch(ctx, &deployment, patch)
+}
@@ -0,0 +1,172 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	mariak8gv1beta1 "github.com/mariadb/mariadb.org-tools/mariadb-operator/api/v1beta1"
+)
+
+func TestPauseReason(t *testing.T) {
+	tests := []struct {
+		name        string
+		paused      bool
+		annotations map[string]string
+		want        string
+	}{
+		{name: "not paused"},
+		{name: "spec", paused: true, want: "PausedBySpec"},
+		{name: "annotation", annotations: map[string]string{pausedAnnotation: "true"}, want: "PausedByAnnotation"},
+		{name: "annotation not true", annotations: map[string]string{pausedAnnotation: "yes"}},
+		{name: "both", paused: true, annotations: map[string]string{pausedAnnotation: "true"}, want: "PausedBySpec"},
+	}
+	for _, tt := range tests {
+		database := mariak8gv1beta1.MariaDB{
+			ObjectMeta: metav1.ObjectMeta{Annotations: tt.annotations},
+			Spec:       mariak8gv1beta1.MariaDBSpec{Paused: tt.paused},
+		}
+		if got := pauseReason(database); got != tt.want {
+			t.Errorf("%s: pauseReason() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSetPausedCondition(t *testing.T) {
+	tests := []struct {
+		name       string
+		wasPaused  bool
+		reason     string
+		wantStatus metav1.ConditionStatus
+		wantEvent  string
+	}{
+		{name: "paused", reason: "PausedBySpec", wantStatus: metav1.ConditionTrue, wantEvent: "Normal Paused"},
+		{name: "still paused", wasPaused: true, reason: "PausedBySpec", wantStatus: metav1.ConditionTrue},
+		{name: "resumed", wasPaused: true, wantStatus: metav1.ConditionFalse, wantEvent: "Normal Resumed"},
+		{name: "never paused", wantStatus: metav1.ConditionFalse},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := record.NewFakeRecorder(10)
+			r := &MariaDBReconciler{Recorder: recorder}
+			database := mariak8gv1beta1.MariaDB{ObjectMeta: metav1.ObjectMeta{Generation: 2}}
+			if tt.wasPaused {
+				meta.SetStatusCondition(&database.Status.Conditions, metav1.Condition{
+					Type: mariak8gv1beta1.ConditionPaused, Status: metav1.ConditionTrue, Reason: "PausedBySpec",
+				})
+			}
+			r.setPausedCondition(&database, tt.reason)
+			close(recorder.Events)
+
+			condition := meta.FindStatusCondition(database.Status.Conditions, mariak8gv1beta1.ConditionPaused)
+			if condition == nil || condition.Status != tt.wantStatus || condition.ObservedGeneration != 2 {
+				t.Fatalf("Paused condition %+v", condition)
+			}
+			if tt.reason != "" && condition.Reason != tt.reason {
+				t.Errorf("reason %q, want %q", condition.Reason, tt.reason)
+			}
+			var events []string
+			for event := range recorder.Events {
+				events = append(events, event)
+			}
+			if tt.wantEvent == "" && len(events) > 0 || tt.wantEvent != "" && (len(events) != 1 || !strings.HasPrefix(events[0], tt.wantEvent)) {
+				t.Errorf("events %q, want %q", events, tt.wantEvent)
+			}
+		})
+	}
+}
+
+func TestReconcilePaused(t *testing.T) {
+	replicas := int32(1)
+	tests := []struct {
+		name         string
+		scaleDown    bool
+		wantReplicas int32
+	}{
+		{name: "paused", wantReplicas: 1},
+		{name: "scaled down", scaleDown: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			database := &mariak8gv1beta1.MariaDB{
+				ObjectMeta: metav1.ObjectMeta{Name: "shop", Namespace: "default", Generation: 2},
+				Spec: mariak8gv1beta1.MariaDBSpec{
+					Image:               "mariadb:10.6",
+					Replicas:            &replicas,
+					Paused:              true,
+					ScaleDownWhenPaused: tt.scaleDown,
+				},
+			}
+			deployment := &appsv1.Deployment{
+				ObjectMeta: metav1.ObjectMeta{Name: deploymentName(*database), Namespace: "default"},
+				Spec: appsv1.DeploymentSpec{
+					Replicas: &replicas,
+					Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{
+						Containers: []corev1.Container{{Name: "mariadb", Image: "mariadb:10.5"}},
+					}},
+				},
+			}
+			c := fake.NewClientBuilder().WithScheme(testScheme(t)).WithObjects(database, deployment).Build()
+			recorder := record.NewFakeRecorder(10)
+			r := &MariaDBReconciler{Client: c, Scheme: testScheme(t), Log: logr.Discard(), Recorder: recorder}
+
+			key := types.NamespacedName{Namespace: "default", Name: "shop"}
+			result, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: key})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.RequeueAfter <= 0 {
+				t.Errorf("paused instance isn't requeued for its health checks")
+			}
+
+			// the image change waits for the resume, only the replicas are scaled down
+			var got appsv1.Deployment
+			if err := c.Get(context.Background(), types.NamespacedName{Namespace: "default", Name: deployment.Name}, &got); err != nil {
+				t.Fatal(err)
+			}
+			if image := got.Spec.Template.Spec.Containers[0].Image; image != "mariadb:10.5" {
+				t.Errorf("paused deployment updated to %s", image)
+			}
+			if *got.Spec.Replicas != tt.wantReplicas {
+				t.Errorf("deployment replicas %d, want %d", *got.Spec.Replicas, tt.wantReplicas)
+			}
+			var service corev1.Service
+			if err := c.Get(context.Background(), types.NamespacedName{Namespace: "default", Name: serviceName(*database)}, &service); ignoreNotFound(err) != nil || err == nil {
+				t.Errorf("service of a paused instance created: %v", err)
+			}
+
+			if err := c.Get(context.Background(), key, database); err != nil {
+				t.Fatal(err)
+			}
+			if !meta.IsStatusConditionTrue(database.Status.Conditions, mariak8gv1beta1.ConditionPaused) {
+				t.Errorf("conditions %+v", database.Status.Conditions)
+			}
+		})
+	}
+}
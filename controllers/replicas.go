@@ -0,0 +1,68 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+
+	mariak8gv1beta1 "github.com/mariadb/mariadb.org-tools/mariadb-operator/api/v1beta1"
+)
+
+// oldestReadyPod picks the pod statistics are read from and commands are run
+// on, so the choice only changes when that pod stops being ready. It returns
+// nil when no pod is ready.
+func oldestReadyPod(pods []corev1.Pod) *corev1.Pod {
+	var oldest *corev1.Pod
+	for i := range pods {
+		pod := &pods[i]
+		if !podReady(*pod) {
+			continue
+		}
+		if oldest == nil || pod.CreationTimestamp.Before(&oldest.CreationTimestamp) ||
+			(pod.CreationTimestamp.Equal(&oldest.CreationTimestamp) && pod.Name < oldest.Name) {
+			oldest = pod
+		}
+	}
+	return oldest
+}
+
+// reconcileReplicas reports the replicas of the deployment for the scale
+// subresource and the printer columns, along with the pod statistics are
+// read from.
+func (r *MariaDBReconciler) reconcileReplicas(ctx context.Context, database *mariak8gv1beta1.MariaDB, deployment appsv1.Deployment) error {
+	status := &database.Status
+	current := deployment.Status.Replicas
+	status.CurrentReplicas = &current
+	if database.Spec.Replicas != nil {
+		status.DesiredReplicas = *database.Spec.Replicas
+	}
+	status.ReadyReplicas = deployment.Status.ReadyReplicas
+	status.Selector = labels.SelectorFromSet(map[string]string{"mariadb": database.Name}).String()
+
+	pods, err := r.instancePods(ctx, *database)
+	if err != nil {
+		return err
+	}
+	if pod := oldestReadyPod(pods); pod != nil {
+		status.StatsPod = pod.Name
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	mariak8gv1beta1 "github.com/mariadb/mariadb.org-tools/mariadb-operator/api/v1beta1"
+)
+
+// testPod is a running pod of the shop instance, created minutes ago.
+func testPod(name string, minutes int, ready bool) corev1.Pod {
+	status := corev1.ConditionFalse
+	if ready {
+		status = corev1.ConditionTrue
+	}
+	return corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              name,
+			Namespace:         "default",
+			Labels:            map[string]string{"mariadb": "shop"},
+			CreationTimestamp: metav1.NewTime(time.Date(2021, 10, 1, 12, 0, 0, 0, time.UTC).Add(-time.Duration(minutes) * time.Minute)),
+		},
+		Status: corev1.PodStatus{
+			Phase:      corev1.PodRunning,
+			Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: status}},
+		},
+	}
+}
+
+func TestOldestReadyPod(t *testing.T) {
+	tests := []struct {
+		name string
+		pods []corev1.Pod
+		want string
+	}{
+		{name: "no pods"},
+		{name: "none ready", pods: []corev1.Pod{testPod("shop-a", 5, false)}},
+		{name: "single pod", pods: []corev1.Pod{testPod("shop-a", 5, true)}, want: "shop-a"},
+		{
+			// the new pod of a rollout doesn't take over before the old one is gone
+			name: "rollout",
+			pods: []corev1.Pod{testPod("shop-new", 1, true), testPod("shop-old", 60, true)},
+			want: "shop-old",
+		},
+		{
+			name: "oldest not ready",
+			pods: []corev1.Pod{testPod("shop-new", 1, true), testPod("shop-old", 60, false)},
+			want: "shop-new",
+		},
+		{
+			name: "same age",
+			pods: []corev1.Pod{testPod("shop-b", 5, true), testPod("shop-a", 5, true)},
+			want: "shop-a",
+		},
+	}
+	for _, tt := range tests {
+		got := ""
+		if pod := oldestReadyPod(tt.pods); pod != nil {
+			got = pod.Name
+		}
+		if got != tt.want {
+			t.Errorf("%s: oldestReadyPod() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestReconcileReplicas(t *testing.T) {
+	one := int32(1)
+	tests := []struct {
+		name         string
+		replicas     *int32
+		deployment   appsv1.DeploymentStatus
+		pods         []corev1.Pod
+		wantDesired  int32
+		wantCurrent  int32
+		wantReady    int32
+		wantStatsPod string
+	}{
+		{
+			name:        "starting",
+			replicas:    &one,
+			deployment:  appsv1.DeploymentStatus{Replicas: 1},
+			pods:        []corev1.Pod{testPod("shop-a", 0, false)},
+			wantDesired: 1,
+			wantCurrent: 1,
+		},
+		{
+			name:         "running",
+			replicas:     &one,
+			deployment:   appsv1.DeploymentStatus{Replicas: 1, ReadyReplicas: 1},
+			pods:         []corev1.Pod{testPod("shop-a", 5, true)},
+			wantDesired:  1,
+			wantCurrent:  1,
+			wantReady:    1,
+			wantStatsPod: "shop-a",
+		},
+		{
+			name:         "replicas left to the deployment",
+			deployment:   appsv1.DeploymentStatus{Replicas: 1, ReadyReplicas: 1},
+			pods:         []corev1.Pod{testPod("shop-a", 5, true)},
+			wantCurrent:  1,
+			wantReady:    1,
+			wantStatsPod: "shop-a",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var objects []client.Object
+			for i := range tt.pods {
+				objects = append(objects, &tt.pods[i])
+			}
+			r := &MariaDBReconciler{Client: fake.NewClientBuilder().WithScheme(testScheme(t)).WithObjects(objects...).Build()}
+			database := mariak8gv1beta1.MariaDB{
+				ObjectMeta: metav1.ObjectMeta{Name: "shop", Namespace: "default"},
+				Spec:       mariak8gv1beta1.MariaDBSpec{Replicas: tt.replicas},
+			}
+			if err := r.reconcileReplicas(context.Background(), &database, appsv1.Deployment{Status: tt.deployment}); err != nil {
+				t.Fatal(err)
+			}
+
+			status := database.Status
+			if status.DesiredReplicas != tt.wantDesired || status.CurrentReplicas == nil || *status.CurrentReplicas != tt.wantCurrent || status.ReadyReplicas != tt.wantReady {
+				t.Errorf("replicas desired %d, current %v, ready %d", status.DesiredReplicas, status.CurrentReplicas, status.ReadyReplicas)
+			}
+			if status.Selector != "mariadb=shop" {
+				t.Errorf("selector %q", status.Selector)
+			}
+			if status.StatsPod != tt.wantStatsPod {
+				t.Errorf("stats pod %q, want %q", status.StatsPod, tt.wantStatsPod)
+			}
+		})
+	}
+}
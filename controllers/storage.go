@@ -0,0 +1,129 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	mariak8gv1beta1 "github.com/mariadb/mariadb.org-tools/mariadb-operator/api/v1beta1"
+)
+
+// reconcileStorage creates the data PersistentVolumeClaim of the instance and
+// grows every claim of the instance to the storage size. It returns true while
+// a volume is still being resized.
+func (r *MariaDBReconciler) reconcileStorage(ctx context.Context, database *mariak8gv1beta1.MariaDB) (bool, error) {
+	if database.Spec.Storage.Size == "" {
+		database.Status.Storage = nil
+		return false, nil
+	}
+	size, err := resource.ParseQuantity(database.Spec.Storage.Size)
+	if err != nil {
+		return false, invalidSpecError(fmt.Sprintf("invalid storage size %q: %v", database.Spec.Storage.Size, err))
+	}
+
+	var existing corev1.PersistentVolumeClaim
+	err = r.Get(ctx, types.NamespacedName{Namespace: database.Namespace, Name: dataVolumeClaimName(*database)}, &existing)
+	if ignoreNotFound(err) != nil {
+		return false, err
+	}
+	if err != nil {
+		pvc, err := r.desiredPersistentVolumeClaim(*database, size)
+		if err != nil {
+			return false, err
+		}
+		// the data source is immutable, it only provisions a new claim
+		pvc.Spec.DataSource = volumeSnapshotDataSource(*database)
+		if err := r.Create(ctx, &pvc); err != nil {
+			return false, err
+		}
+	} else if class := database.Spec.Storage.StorageClassName; class != nil &&
+		(existing.Spec.StorageClassName == nil || *existing.Spec.StorageClassName != *class) {
+		return false, invalidSpecError(fmt.Sprintf("storageClassName of %s can't be changed", existing.Name))
+	}
+
+	var pvcs corev1.PersistentVolumeClaimList
+	if err := r.List(ctx, &pvcs, client.InNamespace(database.Namespace), client.MatchingLabels{"mariadb": database.Name}); err != nil {
+		return false, err
+	}
+
+	resizing := false
+	database.Status.Storage = nil
+	for i := range pvcs.Items {
+		pvc := &pvcs.Items[i]
+		requested := pvc.Spec.Resources.Requests[corev1.ResourceStorage]
+
+		switch size.Cmp(requested) {
+		case -1:
+			return false, invalidSpecError(fmt.Sprintf("storage size %s is smaller than the %s of %s, volumes can't shrink",
+				size.String(), requested.String(), pvc.Name))
+		case 1:
+			if err := r.expandVolumeClaim(ctx, pvc, size); err != nil {
+				return false, err
+			}
+			requested = size
+		}
+
+		volume := mariak8gv1beta1.VolumeStatus{Name: pvc.Name, Requested: &requested}
+		if capacity, ok := pvc.Status.Capacity[corev1.ResourceStorage]; ok {
+			volume.Capacity = &capacity
+			if capacity.Cmp(requested) < 0 {
+				volume.ResizeStatus = "Resizing"
+			}
+		}
+		for _, c := range pvc.Status.Conditions {
+			if c.Status == corev1.ConditionTrue && (c.Type == corev1.PersistentVolumeClaimResizing || c.Type == corev1.PersistentVolumeClaimFileSystemResizePending) {
+				volume.ResizeStatus = string(c.Type)
+			}
+		}
+		if volume.ResizeStatus != "" {
+			resizing = true
+		}
+		database.Status.Storage = append(database.Status.Storage, volume)
+	}
+
+	return resizing, nil
+}
+
+// expandVolumeClaim requests a bigger size for the claim, if its storage class allows it.
+func (r *MariaDBReconciler) expandVolumeClaim(ctx context.Context, pvc *corev1.PersistentVolumeClaim, size resource.Quantity) error {
+	if pvc.Spec.StorageClassName == nil || *pvc.Spec.StorageClassName == "" {
+		return invalidSpecError(fmt.Sprintf("%s has no storage class, it can't be expanded", pvc.Name))
+	}
+	var sc storagev1.StorageClass
+	err := r.APIReader.Get(ctx, types.NamespacedName{Name: *pvc.Spec.StorageClassName}, &sc)
+	if errors.IsForbidden(err) {
+		// with namespace only permissions, let the API server check the expansion
+		log := r.Log.WithValues("PersistentVolumeClaim", client.ObjectKeyFromObject(pvc))
+		log.Info("Not allowed to get the storage class, expanding without checking it", "storageClass", *pvc.Spec.StorageClassName)
+	} else if err != nil {
+		return err
+	} else if sc.AllowVolumeExpansion == nil || !*sc.AllowVolumeExpansion {
+		return invalidSpecError(fmt.Sprintf("storage class %s of %s doesn't allow volume expansion", sc.Name, pvc.Name))
+	}
+
+	patch := client.MergeFrom(pvc.DeepCopy())
+	pvc.Spec.Resources.Requests[corev1.ResourceStorage] = size
+	return r.Patch(ctx, pvc, patch)
+}
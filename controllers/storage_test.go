@@ -0,0 +1,176 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	mariak8gv1beta1 "github.com/mariadb/mariadb.org-tools/mariadb-operator/api/v1beta1"
+)
+
+func TestDesiredPersistentVolumeClaim(t *testing.T) {
+	class := "fast"
+	tests := []struct {
+		name      string
+		storage   mariak8gv1beta1.StorageSpec
+		wantModes []corev1.PersistentVolumeAccessMode
+		wantClass *string
+	}{
+		{
+			name:      "defaults",
+			storage:   mariak8gv1beta1.StorageSpec{Size: "1Gi"},
+			wantModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+		},
+		{
+			name: "class and access modes",
+			storage: mariak8gv1beta1.StorageSpec{
+				Size:             "1Gi",
+				StorageClassName: &class,
+				AccessModes:      []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOncePod},
+			},
+			wantModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOncePod},
+			wantClass: &class,
+		},
+	}
+
+	r := &MariaDBReconciler{Scheme: testScheme(t)}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			database := mariak8gv1beta1.MariaDB{
+				ObjectMeta: metav1.ObjectMeta{Name: "shop", Namespace: "default", UID: "1234"},
+				Spec:       mariak8gv1beta1.MariaDBSpec{Storage: tt.storage},
+			}
+			pvc, err := r.desiredPersistentVolumeClaim(database, resource.MustParse("1Gi"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if pvc.Name != "shop-data" || pvc.Labels["mariadb"] != "shop" {
+				t.Errorf("claim %s labelled %v", pvc.Name, pvc.Labels)
+			}
+			if got := pvc.Spec.Resources.Requests[corev1.ResourceStorage]; got.String() != "1Gi" {
+				t.Errorf("requested %s", got.String())
+			}
+			if len(pvc.Spec.AccessModes) != len(tt.wantModes) || pvc.Spec.AccessModes[0] != tt.wantModes[0] {
+				t.Errorf("access modes %v, want %v", pvc.Spec.AccessModes, tt.wantModes)
+			}
+			if (pvc.Spec.StorageClassName == nil) != (tt.wantClass == nil) {
+				t.Errorf("storage class %v, want %v", pvc.Spec.StorageClassName, tt.wantClass)
+			}
+			if owners := pvc.OwnerReferences; len(owners) != 1 || owners[0].UID != database.UID {
+				t.Errorf("claim is owned by %v", owners)
+			}
+		})
+	}
+}
+
+func TestReconcileStorage(t *testing.T) {
+	expandable, fixed := "expandable", "fixed"
+	allow, deny := true, false
+	classes := []client.Object{
+		&storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: expandable}, AllowVolumeExpansion: &allow},
+		&storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: fixed}, AllowVolumeExpansion: &deny},
+	}
+	claim := func(class, requested, capacity string) *corev1.PersistentVolumeClaim {
+		pvc := &corev1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Name: "shop-data", Namespace: "default", Labels: map[string]string{"mariadb": "shop"}},
+			Spec: corev1.PersistentVolumeClaimSpec{
+				StorageClassName: &class,
+				Resources:        corev1.ResourceRequirements{Requests: corev1.ResourceList{corev1.ResourceStorage: resource.MustParse(requested)}},
+			},
+		}
+		if capacity != "" {
+			pvc.Status.Capacity = corev1.ResourceList{corev1.ResourceStorage: resource.MustParse(capacity)}
+		}
+		return pvc
+	}
+
+	tests := []struct {
+		name          string
+		size          string
+		class         *string
+		existing      *corev1.PersistentVolumeClaim
+		wantResizing  bool
+		wantInvalid   bool
+		wantRequested string
+		wantStatus    string
+	}{
+		{name: "no storage size"},
+		{name: "new claim", size: "1Gi", wantRequested: "1Gi"},
+		{name: "same size", size: "1Gi", existing: claim(expandable, "1Gi", "1Gi"), wantRequested: "1Gi"},
+		{name: "grow", size: "2Gi", existing: claim(expandable, "1Gi", "1Gi"), wantResizing: true, wantRequested: "2Gi", wantStatus: "Resizing"},
+		{name: "grow without expansion", size: "2Gi", existing: claim(fixed, "1Gi", "1Gi"), wantInvalid: true},
+		{name: "shrink", size: "512Mi", existing: claim(expandable, "1Gi", "1Gi"), wantInvalid: true},
+		{name: "invalid size", size: "lots", wantInvalid: true},
+		{name: "storage class changed", size: "1Gi", class: &fixed, existing: claim(expandable, "1Gi", "1Gi"), wantInvalid: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			objects := append([]client.Object{}, classes...)
+			if tt.existing != nil {
+				objects = append(objects, tt.existing)
+			}
+			c := fake.NewClientBuilder().WithScheme(testScheme(t)).WithObjects(objects...).Build()
+			r := &MariaDBReconciler{Client: c, APIReader: c, Scheme: testScheme(t)}
+			database := mariak8gv1beta1.MariaDB{
+				ObjectMeta: metav1.ObjectMeta{Name: "shop", Namespace: "default"},
+				Spec:       mariak8gv1beta1.MariaDBSpec{Storage: mariak8gv1beta1.StorageSpec{Size: tt.size, StorageClassName: tt.class}},
+			}
+
+			resizing, err := r.reconcileStorage(context.Background(), &database)
+			if _, invalid := err.(invalidSpecError); invalid != tt.wantInvalid {
+				t.Fatalf("reconcileStorage() error = %v, want invalid spec: %v", err, tt.wantInvalid)
+			}
+			if tt.wantInvalid {
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resizing != tt.wantResizing {
+				t.Errorf("resizing = %v, want %v", resizing, tt.wantResizing)
+			}
+
+			var pvc corev1.PersistentVolumeClaim
+			err = c.Get(context.Background(), types.NamespacedName{Namespace: "default", Name: "shop-data"}, &pvc)
+			if tt.wantRequested == "" {
+				if err == nil || database.Status.Storage != nil {
+					t.Errorf("claim %s created without a storage size, status %v", pvc.Name, database.Status.Storage)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := pvc.Spec.Resources.Requests[corev1.ResourceStorage]; got.String() != tt.wantRequested {
+				t.Errorf("claim requests %s, want %s", got.String(), tt.wantRequested)
+			}
+			if len(database.Status.Storage) != 1 || database.Status.Storage[0].ResizeStatus != tt.wantStatus {
+				t.Errorf("storage status %+v, want resize status %q", database.Status.Storage, tt.wantStatus)
+			}
+		})
+	}
+}
@@ -0,0 +1,202 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	mariak8gv1beta1 "github.com/mariadb/mariadb.org-tools/mariadb-operator/api/v1beta1"
+)
+
+var versionRegexp = regexp.MustCompile(`^(\d+)\.(\d+)`)
+
+// mariadbVersion is the server version the instance is meant to run: the tag
+// of an explicitly set image, or ImageVersion otherwise.
+func mariadbVersion(database mariak8gv1beta1.MariaDB) string {
+	if database.Spec.Image == "" {
+		return database.Spec.ImageVersion
+	}
+	image := database.Spec.Image
+	if i := strings.LastIndex(image, "@"); i >= 0 {
+		image = image[:i]
+	}
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		return image[i+1:]
+	}
+	return "latest"
+}
+
+// majorVersion returns the release series (Ex. 10.6) a version belongs to,
+// which is what MariaDB calls a major version.
+func majorVersion(version string) (int, int, bool) {
+	m := versionRegexp.FindStringSubmatch(version)
+	if m == nil {
+		return 0, 0, false
+	}
+	major, _ := strconv.Atoi(m[1])
+	minor, _ := strconv.Atoi(m[2])
+	return major, minor, true
+}
+
+// validateVersionChange rejects going back to an older release series, as
+// the data directory can't be downgraded once mariadb-upgrade ran on it.
+func validateVersionChange(from, to string) error {
+	fromMajor, fromMinor, okFrom := majorVersion(from)
+	toMajor, toMinor, okTo := majorVersion(to)
+	if !okFrom || !okTo {
+		// tags like latest can't be compared, leave it to the user
+		return nil
+	}
+	if toMajor < fromMajor || (toMajor == fromMajor && toMinor < fromMinor) {
+		return fmt.Errorf("downgrade from %s to %s is not supported", from, to)
+	}
+	return nil
+}
+
+func podReady(pod corev1.Pod) bool {
+	for _, c := range pod.Status.Conditions {
+		if c.Type == corev1.PodReady && c.Status == corev1.ConditionTrue {
+			return true
+		}
+	}
+	return false
+}
+
+// deploymentRolledOut tells if every replica runs the current pod template and is ready.
+func deploymentRolledOut(deployment appsv1.Deployment) bool {
+	replicas := int32(1)
+	if deployment.Spec.Replicas != nil {
+		replicas = *deployment.Spec.Replicas
+	}
+	return deployment.Status.ObservedGeneration >= deployment.Generation &&
+		deployment.Status.UpdatedReplicas == replicas &&
+		deployment.Status.ReadyReplicas == replicas &&
+		deployment.Status.Replicas == replicas
+}
+
+// instancePods lists the running, non terminating pods of the instance.
+func (r *MariaDBReconciler) instancePods(ctx context.Context, database mariak8gv1beta1.MariaDB) ([]corev1.Pod, error) {
+	var pods corev1.PodList
+	if err := r.List(ctx, &pods, client.InNamespace(database.Namespace), client.MatchingLabels{"mariadb": database.Name}); err != nil {
+		return nil, err
+	}
+	var running []corev1.Pod
+	for _, pod := range pods.Items {
+		if pod.DeletionTimestamp == nil && pod.Status.Phase == corev1.PodRunning {
+			running = append(running, pod)
+		}
+	}
+	return running, nil
+}
+
+func containerImage(podSpec corev1.PodSpec, container string) string {
+	for _, c := range podSpec.Containers {
+		if c.Name == container {
+			return c.Image
+		}
+	}
+	return ""
+}
+
+// reconcileUpgrade runs mariadb-upgrade on the pod started with the new
+// version once the Deployment replaced the old one, and records the version
+// when it is done. It returns true while the upgrade is still in progress.
+func (r *MariaDBReconciler) reconcileUpgrade(ctx context.Context, database *mariak8gv1beta1.MariaDB, deployment appsv1.Deployment) (bool, error) {
+	target := mariadbVersion(*database)
+	status := &database.Status
+
+	if status.CurrentVersion == "" {
+		// first deployment, the data directory is initialized by this version
+		if deploymentRolledOut(deployment) {
+			status.CurrentVersion = target
+		}
+		return false, nil
+	}
+	if status.CurrentVersion == target {
+		status.Upgrade = nil
+		return false, nil
+	}
+	image := mariadbImage(*database)
+	if containerImage(deployment.Spec.Template.Spec, "mariadb") != image {
+		// held until the maintenance window, nothing runs the new version yet
+		return status.Upgrade != nil, nil
+	}
+
+	if status.Upgrade == nil || status.Upgrade.ToVersion != target {
+		now := metav1.Now()
+		status.Upgrade = &mariak8gv1beta1.UpgradeStatus{
+			FromVersion: status.CurrentVersion,
+			ToVersion:   target,
+			StartTime:   &now,
+		}
+		r.Recorder.Eventf(database, corev1.EventTypeNormal, EventReasonUpgradeStarted, "upgrading from %s to %s", status.CurrentVersion, target)
+	}
+
+	pods, err := r.instancePods(ctx, *database)
+	if err != nil {
+		return true, err
+	}
+
+	for _, pod := range pods {
+		if containerImage(pod.Spec, "mariadb") != image || !podReady(pod) || containsString(status.Upgrade.UpgradedPods, pod.Name) {
+			continue
+		}
+		command := []string{"sh", "-c", `exec mariadb-upgrade --user=root --password="$MARIADB_ROOT_PASSWORD"`}
+		if _, err := execInPod(ctx, r.Config, pod, "mariadb", command, nil); err != nil {
+			status.LastMessage = "mariadb-upgrade failed on " + pod.Name + ": " + err.Error()
+			r.Recorder.Event(database, corev1.EventTypeWarning, EventReasonUpgradeFailed, status.LastMessage)
+			return true, nil
+		}
+		status.Upgrade.UpgradedPods = append(status.Upgrade.UpgradedPods, pod.Name)
+	}
+
+	status.LastMessage = fmt.Sprintf("upgrading from %s to %s: %d pod(s) upgraded",
+		status.Upgrade.FromVersion, status.Upgrade.ToVersion, len(status.Upgrade.UpgradedPods))
+
+	if !deploymentRolledOut(deployment) {
+		return true, nil
+	}
+	for _, pod := range pods {
+		if !containsString(status.Upgrade.UpgradedPods, pod.Name) {
+			return true, nil
+		}
+	}
+
+	status.LastMessage = fmt.Sprintf("upgraded from %s to %s", status.Upgrade.FromVersion, status.Upgrade.ToVersion)
+	r.Recorder.Event(database, corev1.EventTypeNormal, EventReasonUpgraded, status.LastMessage)
+	status.CurrentVersion = target
+	status.Upgrade = nil
+	return false, nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,65 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"testing"
+
+	mariak8gv1beta1 "github.com/mariadb/mariadb.org-tools/mariadb-operator/api/v1beta1"
+)
+
+func TestValidateVersionChange(t *testing.T) {
+	tests := []struct {
+		from, to string
+		wantErr  bool
+	}{
+		{from: "10.5.12", to: "10.5.13"},
+		{from: "10.5", to: "10.6"},
+		{from: "10.6.4", to: "11.0.1"},
+		{from: "10.6.5", to: "10.6.4"},
+		{from: "10.6", to: "10.5", wantErr: true},
+		{from: "11.0.1", to: "10.11.2", wantErr: true},
+		{from: "10.6.4-focal", to: "10.5.13-focal", wantErr: true},
+		// tags that aren't versions can't be compared
+		{from: "latest", to: "10.5"},
+		{from: "10.6", to: "latest"},
+	}
+	for _, tt := range tests {
+		err := validateVersionChange(tt.from, tt.to)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("validateVersionChange(%q, %q) = %v, want error: %v", tt.from, tt.to, err, tt.wantErr)
+		}
+	}
+}
+
+func TestMariaDBVersion(t *testing.T) {
+	tests := []struct {
+		image, imageVersion, want string
+	}{
+		{imageVersion: "10.6", want: "10.6"},
+		{image: "mariadb:10.5.13", imageVersion: "10.6", want: "10.5.13"},
+		{image: "registry.example.com:5000/mariadb:10.4", want: "10.4"},
+		{image: "registry.example.com:5000/mariadb", want: "latest"},
+		{image: "mariadb:10.6@sha256:0123", want: "10.6"},
+	}
+	for _, tt := range tests {
+		database := mariak8gv1beta1.MariaDB{Spec: mariak8gv1beta1.MariaDBSpec{Image: tt.image, ImageVersion: tt.imageVersion}}
+		if got := mariadbVersion(database); got != tt.want {
+			t.Errorf("mariadbVersion(%q, %q) = %q, want %q", tt.image, tt.imageVersion, got, tt.want)
+		}
+	}
+}
@@ -4,8 +4,13 @@ go 1.16
 
 require (
 	github.com/go-logr/logr v0.4.0
+	github.com/google/gofuzz v1.1.0
+	github.com/klauspost/compress v1.13.6
 	github.com/onsi/ginkgo v1.16.4
 	github.com/onsi/gomega v1.15.0
+	github.com/prometheus/client_golang v1.11.0
+	github.com/robfig/cron/v3 v3.0.1
+	golang.org/x/time v0.0.0-20210723032227-1f47c861a9ac
 	k8s.io/api v0.22.1
 	k8s.io/apimachinery v0.22.1
 	k8s.io/client-go v0.22.1
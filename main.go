@@ -17,21 +17,35 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"flag"
 	"os"
+	"strings"
+	"time"
+	// Embed the time zone database, for the time zones of maintenance windows
+	_ "time/tzdata"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 
+	"golang.org/x/time/rate"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/util/workqueue"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 
 	mariak8gv1alpha1 "github.com/mariadb/mariadb.org-tools/mariadb-operator/api/v1alpha1"
+	mariak8gv1beta1 "github.com/mariadb/mariadb.org-tools/mariadb-operator/api/v1beta1"
+	"github.com/mariadb/mariadb.org-tools/mariadb-operator/archive"
 	"github.com/mariadb/mariadb.org-tools/mariadb-operator/controllers"
 	//+kubebuilder:scaffold:imports
 )
@@ -45,18 +59,154 @@ func init() {
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
 
 	utilruntime.Must(mariak8gv1alpha1.AddToScheme(scheme))
+	utilruntime.Must(mariak8gv1beta1.AddToScheme(scheme))
 	//+kubebuilder:scaffold:scheme
 }
 
+// newRateLimiter is the default rate limiter of the controllers, with
+// tunable per object delays.
+func newRateLimiter(baseDelay, maxDelay time.Duration) workqueue.RateLimiter {
+	return workqueue.NewMaxOfRateLimiter(
+		workqueue.NewItemExponentialFailureRateLimiter(baseDelay, maxDelay),
+		&workqueue.BucketRateLimiter{Limiter: rate.NewLimiter(rate.Limit(10), 100)},
+	)
+}
+
+// managerFlags are the manager options of the command line, set records
+// the flags given on it.
+type managerFlags struct {
+	metricsAddr          string
+	probeAddr            string
+	enableLeaderElection bool
+	syncPeriod           time.Duration
+	leaseDuration        time.Duration
+	renewDeadline        time.Duration
+	retryPeriod          time.Duration
+	set                  map[string]bool
+}
+
+// apply sets the flags in the options loaded from the config file. Flags set
+// on the command line win over the config file, the defaults of the others
+// only fill what it leaves unset.
+func (f managerFlags) apply(options ctrl.Options, fromFile bool) ctrl.Options {
+	if f.set["metrics-bind-address"] || options.MetricsBindAddress == "" {
+		options.MetricsBindAddress = f.metricsAddr
+	}
+	if f.set["health-probe-bind-address"] || options.HealthProbeBindAddress == "" {
+		options.HealthProbeBindAddress = f.probeAddr
+	}
+	if f.set["leader-elect"] || !fromFile {
+		options.LeaderElection = f.enableLeaderElection
+	}
+	if options.LeaderElectionID == "" {
+		options.LeaderElectionID = "68d14e4b.mariadb.org"
+	}
+	if options.Port == 0 {
+		options.Port = 9443
+	}
+	if f.set["sync-period"] || options.SyncPeriod == nil {
+		options.SyncPeriod = &f.syncPeriod
+	}
+	if f.set["leader-elect-lease-duration"] || options.LeaseDuration == nil {
+		options.LeaseDuration = &f.leaseDuration
+	}
+	if f.set["leader-elect-renew-deadline"] || options.RenewDeadline == nil {
+		options.RenewDeadline = &f.renewDeadline
+	}
+	if f.set["leader-elect-retry-period"] || options.RetryPeriod == nil {
+		options.RetryPeriod = &f.retryPeriod
+	}
+	return options
+}
+
+// parseNamespaces splits the comma separated watched namespaces, dropping
+// the empty ones.
+func parseNamespaces(value string) []string {
+	var namespaces []string
+	for _, namespace := range strings.Split(value, ",") {
+		if namespace = strings.TrimSpace(namespace); namespace != "" {
+			namespaces = append(namespaces, namespace)
+		}
+	}
+	return namespaces
+}
+
+// setWatchNamespaces restricts the cache of the manager to the watched
+// namespaces, all namespaces when there are none.
+func setWatchNamespaces(options *ctrl.Options, namespaces []string) {
+	switch len(namespaces) {
+	case 0:
+		setupLog.Info("watching all namespaces")
+	case 1:
+		options.Namespace = namespaces[0]
+		setupLog.Info("watching a single namespace", "namespace", namespaces[0])
+	default:
+		options.NewCache = cache.MultiNamespacedCacheBuilder(namespaces)
+		setupLog.Info("watching namespaces", "namespaces", namespaces)
+	}
+}
+
+// managerImage is the image of the manager container of the operator pod.
+func managerImage(reader client.Reader) (string, error) {
+	var pod corev1.Pod
+	key := client.ObjectKey{Namespace: os.Getenv("POD_NAMESPACE"), Name: os.Getenv("POD_NAME")}
+	if err := reader.Get(context.Background(), key, &pod); err != nil {
+		return "", err
+	}
+	for _, container := range pod.Spec.Containers {
+		if container.Name == "manager" {
+			return container.Image, nil
+		}
+	}
+	return "", nil
+}
+
 func main() {
-	var metricsAddr string
-	var enableLeaderElection bool
-	var probeAddr string
-	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
-	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
-	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
+	// the backup Jobs and the restores of dump backups run the manager image
+	if len(os.Args) > 1 && (os.Args[1] == "archive" || os.Args[1] == "restore") {
+		os.Exit(archive.Main(os.Args[1:]))
+	}
+
+	var flags managerFlags
+	var watchNamespaces string
+	var connectionSecretNamespaces string
+	var cloneSourceNamespaces string
+	var backupImage string
+	var configFile string
+	var maxConcurrentReconciles int
+	var rateLimiterBaseDelay, rateLimiterMaxDelay time.Duration
+	flag.StringVar(&configFile, "config", "",
+		"The controller manager configuration file (ControllerManagerConfig). "+
+			"Flags set on the command line override its values.")
+	flag.StringVar(&flags.metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
+	flag.StringVar(&flags.probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
+	flag.BoolVar(&flags.enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	flag.StringVar(&watchNamespaces, "watch-namespaces", os.Getenv("WATCH_NAMESPACE"),
+		"Comma separated namespaces the controller manager watches, all namespaces when empty. "+
+			"Defaults to the WATCH_NAMESPACE environment variable.")
+	flag.StringVar(&connectionSecretNamespaces, "connection-secret-namespaces", controllers.DefaultConnectionSecretNamespaces,
+		"Label selector of the namespaces MariaDB instances can copy their connection Secret to.")
+	flag.StringVar(&cloneSourceNamespaces, "clone-source-namespaces", controllers.DefaultCloneSourceNamespaces,
+		"Label selector of the namespaces MariaDB instances of other namespaces can be cloned from.")
+	flag.StringVar(&backupImage, "backup-image", os.Getenv("BACKUP_IMAGE"),
+		"The manager image run by dump backups and their restores, the image of the operator pod when empty. "+
+			"Defaults to the BACKUP_IMAGE environment variable.")
+	flag.IntVar(&maxConcurrentReconciles, "max-concurrent-reconciles", 1,
+		"The number of objects of each kind reconciled concurrently.")
+	flag.DurationVar(&flags.syncPeriod, "sync-period", 10*time.Hour,
+		"How often all watched objects are reconciled again, even without changes.")
+	flag.DurationVar(&flags.leaseDuration, "leader-elect-lease-duration", 15*time.Second,
+		"How long non-leaders wait before trying to acquire a leadership that wasn't renewed.")
+	flag.DurationVar(&flags.renewDeadline, "leader-elect-renew-deadline", 10*time.Second,
+		"How long the leader keeps trying to renew its leadership before giving it up.")
+	flag.DurationVar(&flags.retryPeriod, "leader-elect-retry-period", 2*time.Second,
+		"How long leader election clients wait between attempts.")
+	flag.DurationVar(&rateLimiterBaseDelay, "rate-limiter-base-delay", 5*time.Millisecond,
+		"The delay before retrying a failed reconcile, doubled on each consecutive failure.")
+	flag.DurationVar(&rateLimiterMaxDelay, "rate-limiter-max-delay", 1000*time.Second,
+		"The longest delay between retries of a failed reconcile.")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -65,27 +215,90 @@ func main() {
 
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
 
-	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
-		Scheme:                 scheme,
-		MetricsBindAddress:     metricsAddr,
-		Port:                   9443,
-		HealthProbeBindAddress: probeAddr,
-		LeaderElection:         enableLeaderElection,
-		LeaderElectionID:       "68d14e4b.mariadb.org",
-	})
+	connectionSecretSelector, err := labels.Parse(connectionSecretNamespaces)
+	if err != nil {
+		setupLog.Error(err, "invalid connection secret namespaces selector")
+		os.Exit(1)
+	}
+	cloneSourceSelector, err := labels.Parse(cloneSourceNamespaces)
+	if err != nil {
+		setupLog.Error(err, "invalid clone source namespaces selector")
+		os.Exit(1)
+	}
+
+	options := ctrl.Options{Scheme: scheme}
+	if configFile != "" {
+		options, err = options.AndFrom(ctrl.ConfigFile().AtPath(configFile))
+		if err != nil {
+			setupLog.Error(err, "unable to load the config file")
+			os.Exit(1)
+		}
+	}
+
+	setFlags := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) { setFlags[f.Name] = true })
+	flags.set = setFlags
+	options = flags.apply(options, configFile != "")
+
+	// each controller gets its own rate limiter, they keep per object
+	// failure counts and a shared bucket would throttle one with the other
+	controllerOptions := func() controller.Options {
+		controllerOpts := controller.Options{
+			RateLimiter: newRateLimiter(rateLimiterBaseDelay, rateLimiterMaxDelay),
+		}
+		// else the controllers use the groupKindConcurrency of the config file
+		if setFlags["max-concurrent-reconciles"] || len(options.Controller.GroupKindConcurrency) == 0 {
+			controllerOpts.MaxConcurrentReconciles = maxConcurrentReconciles
+		}
+		return controllerOpts
+	}
+	namespaces := parseNamespaces(watchNamespaces)
+	setWatchNamespaces(&options, namespaces)
+
+	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), options)
 	if err != nil {
 		setupLog.Error(err, "unable to start manager")
 		os.Exit(1)
 	}
 
+	if backupImage == "" {
+		backupImage, err = managerImage(mgr.GetAPIReader())
+		if err != nil || backupImage == "" {
+			setupLog.Error(err, "unable to find the manager image, dump backups need --backup-image")
+		}
+	}
+
 	if err = (&controllers.MariaDBReconciler{
+		Client:            mgr.GetClient(),
+		Log:               ctrl.Log.WithName("controllers").WithName("MariaDB1"),
+		Scheme:            mgr.GetScheme(),
+		Config:            mgr.GetConfig(),
+		APIReader:         mgr.GetAPIReader(),
+		Recorder:          mgr.GetEventRecorderFor("mariadb-controller"),
+		OperatorNamespace: os.Getenv("POD_NAMESPACE"),
+
+		WatchNamespaces:            namespaces,
+		ConnectionSecretNamespaces: connectionSecretSelector,
+		CloneSourceNamespaces:      cloneSourceSelector,
+		BackupImage:                backupImage,
+	}).SetupWithManager(mgr, controllerOptions()); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "MariaDB")
+		os.Exit(1)
+	}
+	if err = (&controllers.MariaDBSQLJobReconciler{
 		Client: mgr.GetClient(),
-		Log:    ctrl.Log.WithName("controllers").WithName("MariaDB1"),
+		Log:    ctrl.Log.WithName("controllers").WithName("MariaDBSQLJob"),
 		Scheme: mgr.GetScheme(),
-	}).SetupWithManager(mgr); err != nil {
-		setupLog.Error(err, "unable to create controller", "controller", "MariaDB")
+	}).SetupWithManager(mgr, controllerOptions()); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "MariaDBSQLJob")
 		os.Exit(1)
 	}
+	if os.Getenv("ENABLE_WEBHOOKS") != "false" {
+		if err = (&mariak8gv1beta1.MariaDB{}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "MariaDB")
+			os.Exit(1)
+		}
+	}
 	//+kubebuilder:scaffold:builder
 
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
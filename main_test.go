@@ -0,0 +1,152 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+func TestParseNamespaces(t *testing.T) {
+	tests := []struct {
+		value string
+		want  []string
+	}{
+		{value: ""},
+		{value: " , "},
+		{value: "shop", want: []string{"shop"}},
+		{value: "shop, billing,,", want: []string{"shop", "billing"}},
+	}
+	for _, tt := range tests {
+		if got := parseNamespaces(tt.value); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseNamespaces(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestSetWatchNamespaces(t *testing.T) {
+	tests := []struct {
+		name          string
+		namespaces    []string
+		wantNamespace string
+		wantMulti     bool
+	}{
+		{name: "all namespaces"},
+		{name: "single namespace", namespaces: []string{"shop"}, wantNamespace: "shop"},
+		{name: "namespaces", namespaces: []string{"shop", "billing"}, wantMulti: true},
+	}
+	for _, tt := range tests {
+		var options ctrl.Options
+		setWatchNamespaces(&options, tt.namespaces)
+		if options.Namespace != tt.wantNamespace {
+			t.Errorf("%s: namespace %q, want %q", tt.name, options.Namespace, tt.wantNamespace)
+		}
+		if (options.NewCache != nil) != tt.wantMulti {
+			t.Errorf("%s: multi-namespace cache %v, want %v", tt.name, options.NewCache != nil, tt.wantMulti)
+		}
+	}
+}
+
+func TestManagerFlagsApply(t *testing.T) {
+	flags := managerFlags{
+		metricsAddr:   ":8080",
+		probeAddr:     ":8081",
+		syncPeriod:    10 * time.Hour,
+		leaseDuration: 15 * time.Second,
+		renewDeadline: 10 * time.Second,
+		retryPeriod:   2 * time.Second,
+	}
+	fileSync, fileLease := time.Hour, time.Minute
+	fromFile := ctrl.Options{
+		MetricsBindAddress: ":9090",
+		LeaderElection:     true,
+		LeaderElectionID:   "shop.mariadb.org",
+		SyncPeriod:         &fileSync,
+		LeaseDuration:      &fileLease,
+	}
+
+	tests := []struct {
+		name        string
+		options     ctrl.Options
+		fromFile    bool
+		set         map[string]bool
+		leader      bool
+		wantMetrics string
+		wantLeader  bool
+		wantID      string
+		wantSync    time.Duration
+		wantLease   time.Duration
+	}{
+		{
+			name:        "flag defaults",
+			wantMetrics: ":8080",
+			wantID:      "68d14e4b.mariadb.org",
+			wantSync:    10 * time.Hour,
+			wantLease:   15 * time.Second,
+		},
+		{
+			name:        "config file",
+			options:     fromFile,
+			fromFile:    true,
+			wantMetrics: ":9090",
+			wantLeader:  true,
+			wantID:      "shop.mariadb.org",
+			wantSync:    time.Hour,
+			wantLease:   time.Minute,
+		},
+		{
+			name:        "flags set over the config file",
+			options:     fromFile,
+			fromFile:    true,
+			set:         map[string]bool{"metrics-bind-address": true, "leader-elect": true, "sync-period": true},
+			wantMetrics: ":8080",
+			wantID:      "shop.mariadb.org",
+			wantSync:    10 * time.Hour,
+			wantLease:   time.Minute,
+		},
+		{
+			name:        "leader election flag",
+			set:         map[string]bool{"leader-elect": true},
+			leader:      true,
+			wantMetrics: ":8080",
+			wantLeader:  true,
+			wantID:      "68d14e4b.mariadb.org",
+			wantSync:    10 * time.Hour,
+			wantLease:   15 * time.Second,
+		},
+	}
+	for _, tt := range tests {
+		f := flags
+		f.set = tt.set
+		f.enableLeaderElection = tt.leader
+		got := f.apply(tt.options, tt.fromFile)
+		if got.MetricsBindAddress != tt.wantMetrics || got.HealthProbeBindAddress != ":8081" || got.Port != 9443 {
+			t.Errorf("%s: addresses %s, %s and port %d", tt.name, got.MetricsBindAddress, got.HealthProbeBindAddress, got.Port)
+		}
+		if got.LeaderElection != tt.wantLeader || got.LeaderElectionID != tt.wantID {
+			t.Errorf("%s: leader election %v with ID %s", tt.name, got.LeaderElection, got.LeaderElectionID)
+		}
+		if *got.SyncPeriod != tt.wantSync || *got.LeaseDuration != tt.wantLease ||
+			*got.RenewDeadline != 10*time.Second || *got.RetryPeriod != 2*time.Second {
+			t.Errorf("%s: sync period %v, lease %v, renew deadline %v, retry period %v",
+				tt.name, *got.SyncPeriod, *got.LeaseDuration, *got.RenewDeadline, *got.RetryPeriod)
+		}
+	}
+}